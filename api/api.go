@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -16,6 +17,7 @@ import (
 
 	"v2ray-stat/config"
 	"v2ray-stat/constant"
+	"v2ray-stat/coreapi"
 	"v2ray-stat/db"
 	"v2ray-stat/db/manager"
 	"v2ray-stat/lua"
@@ -25,22 +27,81 @@ import (
 
 // User represents a user entity from the clients_stats table.
 type User struct {
-	User          string `json:"user"`
-	Uuid          string `json:"uuid"`
-	Rate          string `json:"rate"`
-	Enabled       string `json:"enabled"`
-	Created       string `json:"created"`
-	Sub_end       string `json:"sub_end"`
-	Renew         int    `json:"renew"`
-	Lim_ip        int    `json:"lim_ip"`
-	Ips           string `json:"ips"`
-	Uplink        int64  `json:"uplink"`
-	Downlink      int64  `json:"downlink"`
-	Sess_uplink   int64  `json:"sess_uplink"`
-	Sess_downlink int64  `json:"sess_downlink"`
+	User             string   `json:"user"`
+	Uuid             string   `json:"uuid"`
+	Rate             string   `json:"rate"`
+	Enabled          string   `json:"enabled"`
+	Created          string   `json:"created"`
+	Sub_end          string   `json:"sub_end"`
+	Renew            int      `json:"renew"`
+	Lim_ip           int      `json:"lim_ip"`
+	Ips              string   `json:"ips"`
+	Uplink           int64    `json:"uplink"`
+	Downlink         int64    `json:"downlink"`
+	Sess_uplink      int64    `json:"sess_uplink"`
+	Sess_downlink    int64    `json:"sess_downlink"`
+	Traffic_limit    int64    `json:"traffic_limit"`
+	Uplink_limit     int64    `json:"uplink_limit"`
+	Downlink_limit   int64    `json:"downlink_limit"`
+	Disabled_reason  string   `json:"disabled_reason"`
+	Reset_policy     string   `json:"reset_policy"`
+	Reset_day        int      `json:"reset_day"`
+	Last_reset       string   `json:"last_reset"`
+	Sub_token        string   `json:"sub_token,omitempty"` // Only returned to requests with the API token
+	Notes            string   `json:"notes"`
+	Tags             []string `json:"tags"`
+	Contact_telegram string   `json:"contact_telegram"`
+	Contact_email    string   `json:"contact_email"`
+}
+
+// queryUsers reads a page of users matching filter from the clients_stats table
+// and returns it with the total number of matching users.
+func queryUsers(manager *manager.DatabaseManager, cfg *config.Config, filter userFilter) ([]User, int, error) {
+	var users []User
+	var total int
+	err := manager.ExecuteLowPriority(func(db1 *sql.DB) error {
+		cfg.Logger.Debug("Executing query on clients_stats table")
+		where, args := filter.where()
+		if err := db1.QueryRow("SELECT COUNT(*) FROM clients_stats"+where, args...).Scan(&total); err != nil {
+			cfg.Logger.Error("Failed to count users", "error", err)
+			return fmt.Errorf("failed to count users: %v", err)
+		}
+
+		query := "SELECT user, uuid, rate, enabled, created, sub_end, renew, lim_ip, ips, uplink, downlink, sess_uplink, sess_downlink, traffic_limit, uplink_limit, downlink_limit, disabled_reason, reset_policy, reset_day, last_reset, sub_token, notes, tags, contact_telegram, contact_email FROM clients_stats" + where + filter.orderAndPage()
+		rows, err := db1.Query(query, args...)
+		if err != nil {
+			cfg.Logger.Error("Failed to execute SQL query", "error", err)
+			return fmt.Errorf("failed to execute SQL query: %v", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var user User
+			var tags string
+			if err := rows.Scan(&user.User, &user.Uuid, &user.Rate, &user.Enabled, &user.Created, &user.Sub_end, &user.Renew, &user.Lim_ip, &user.Ips, &user.Uplink, &user.Downlink, &user.Sess_uplink, &user.Sess_downlink, &user.Traffic_limit, &user.Uplink_limit, &user.Downlink_limit, &user.Disabled_reason, &user.Reset_policy, &user.Reset_day, &user.Last_reset, &user.Sub_token, &user.Notes, &tags, &user.Contact_telegram, &user.Contact_email); err != nil {
+				cfg.Logger.Error("Failed to scan row", "error", err)
+				return fmt.Errorf("failed to scan row: %v", err)
+			}
+			user.Tags = db.SplitTags(tags)
+			cfg.Logger.Trace("Read user", "user", user.User, "uuid", user.Uuid, "enabled", user.Enabled)
+			users = append(users, user)
+		}
+		if err := rows.Err(); err != nil {
+			cfg.Logger.Error("Error iterating rows", "error", err)
+			return fmt.Errorf("error iterating rows: %v", err)
+		}
+
+		if len(users) == 0 {
+			cfg.Logger.Warn("No users found in clients_stats table")
+		}
+		return nil
+	})
+	return users, total, err
 }
 
 // UsersHandler returns a list of users from the database in JSON format.
+// Query parameters filter, sort and paginate the list; the number of matching users
+// is returned in the X-Total-Count header.
 func UsersHandler(manager *manager.DatabaseManager, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		cfg.Logger.Debug("Starting UsersHandler request processing")
@@ -53,40 +114,21 @@ func UsersHandler(manager *manager.DatabaseManager, cfg *config.Config) http.Han
 			return
 		}
 
-		var users []User
-		err := manager.ExecuteLowPriority(func(db *sql.DB) error {
-			cfg.Logger.Debug("Executing query on clients_stats table")
-			rows, err := db.Query("SELECT user, uuid, rate, enabled, created, sub_end, renew, lim_ip, ips, uplink, downlink, sess_uplink, sess_downlink FROM clients_stats")
-			if err != nil {
-				cfg.Logger.Error("Failed to execute SQL query", "error", err)
-				return fmt.Errorf("failed to execute SQL query: %v", err)
-			}
-			defer rows.Close()
-
-			for rows.Next() {
-				var user User
-				if err := rows.Scan(&user.User, &user.Uuid, &user.Rate, &user.Enabled, &user.Created, &user.Sub_end, &user.Renew, &user.Lim_ip, &user.Ips, &user.Uplink, &user.Downlink, &user.Sess_uplink, &user.Sess_downlink); err != nil {
-					cfg.Logger.Error("Failed to scan row", "error", err)
-					return fmt.Errorf("failed to scan row: %v", err)
-				}
-				cfg.Logger.Trace("Read user", "user", user.User, "uuid", user.Uuid, "enabled", user.Enabled)
-				users = append(users, user)
-			}
-			if err := rows.Err(); err != nil {
-				cfg.Logger.Error("Error iterating rows", "error", err)
-				return fmt.Errorf("error iterating rows: %v", err)
-			}
+		filter, err := parseUserFilter(r, cfg)
+		if err != nil {
+			cfg.Logger.Warn("Invalid user list parameters", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-			if len(users) == 0 {
-				cfg.Logger.Warn("No users found in clients_stats table")
-			}
-			return nil
-		})
+		users, total, err := queryUsers(manager, cfg, filter)
 		if err != nil {
 			cfg.Logger.Error("Error in UsersHandler", "error", err)
 			http.Error(w, "Error processing data", http.StatusInternalServerError)
 			return
 		}
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		hideSubTokens(r, cfg, users)
 
 		cfg.Logger.Debug("Encoding response to JSON", "users_count", len(users))
 		if err := json.NewEncoder(w).Encode(users); err != nil {
@@ -95,7 +137,7 @@ func UsersHandler(manager *manager.DatabaseManager, cfg *config.Config) http.Han
 			return
 		}
 
-		cfg.Logger.Info("API users: completed successfully", "users_count", len(users))
+		cfg.Logger.Info("API users: completed successfully", "users_count", len(users), "total", total)
 	}
 }
 
@@ -217,34 +259,81 @@ func buildNetworkStats(builder *strings.Builder, cfg *config.Config) {
 	}
 }
 
+// serverColumnAliases maps traffic_stats columns to their table headers.
+var serverColumnAliases = map[string]string{
+	"source":        "Source",
+	"rate":          "Rate",
+	"uplink":        "Uplink",
+	"downlink":      "Downlink",
+	"sess_uplink":   "Sess Up",
+	"sess_downlink": "Sess Down",
+}
+
+// clientColumnAliases maps clients_stats columns to their table headers.
+var clientColumnAliases = map[string]string{
+	"user":          "User",
+	"uuid":          "ID",
+	"last_seen":     "Last seen",
+	"rate":          "Rate",
+	"uplink":        "Uplink",
+	"downlink":      "Downlink",
+	"sess_uplink":   "Sess Up",
+	"sess_downlink": "Sess Down",
+	"enabled":       "Enabled",
+	"sub_end":       "Sub end",
+	"renew":         "Renew",
+	"lim_ip":        "Lim",
+	"ips":           "Ips",
+	"created":       "Created",
+}
+
+// trafficColumnAliases lists the table headers of traffic columns that are formatted as data sizes.
+var trafficColumnAliases = []string{
+	"Rate",
+	"Uplink",
+	"Downlink",
+	"Sess Up",
+	"Sess Down",
+}
+
+// baseStatsColumns lists the traffic_stats and clients_stats columns shown by /api/v1/stats/base for each mode.
+var baseStatsColumns = map[string]struct{ server, client []string }{
+	"minimal": {
+		server: []string{"source", "rate", "uplink", "downlink"},
+		client: []string{"user", "last_seen", "rate", "uplink", "downlink"},
+	},
+	"standard": {
+		server: []string{"source", "rate", "uplink", "downlink"},
+		client: []string{"user", "last_seen", "rate", "uplink", "downlink", "enabled", "sub_end", "renew", "lim_ip", "ips"},
+	},
+	"extended": {
+		server: []string{"source", "rate", "sess_uplink", "sess_downlink", "uplink", "downlink"},
+		client: []string{"user", "last_seen", "rate", "sess_uplink", "sess_downlink", "uplink", "downlink", "enabled", "sub_end", "renew", "lim_ip", "ips"},
+	},
+	"full": {
+		server: []string{"source", "rate", "sess_uplink", "sess_downlink", "uplink", "downlink"},
+		client: []string{"user", "uuid", "last_seen", "rate", "sess_uplink", "sess_downlink", "uplink", "downlink", "enabled", "sub_end", "renew", "lim_ip", "ips", "created"},
+	},
+}
+
+// aliasedColumns builds a select list of columns with their table headers as aliases.
+func aliasedColumns(columns []string, aliases map[string]string) string {
+	var cols []string
+	for _, col := range columns {
+		if alias, ok := aliases[col]; ok {
+			cols = append(cols, fmt.Sprintf("%s AS \"%s\"", col, alias))
+		}
+	}
+	return strings.Join(cols, ", ")
+}
+
 // buildServerCustomStats collects custom server statistics.
 func buildServerCustomStats(builder *strings.Builder, manager *manager.DatabaseManager, cfg *config.Config) error {
 	cfg.Logger.Debug("Collecting custom server statistics")
-	serverColumnAliases := map[string]string{
-		"source":        "Source",
-		"rate":          "Rate",
-		"uplink":        "Uplink",
-		"downlink":      "Downlink",
-		"sess_uplink":   "Sess Up",
-		"sess_downlink": "Sess Down",
-	}
-	trafficAliases := []string{
-		"Rate",
-		"Uplink",
-		"Downlink",
-		"Sess Up",
-		"Sess Down",
-	}
 
 	if len(cfg.StatsColumns.Server.Columns) > 0 {
-		var serverCols []string
-		for _, col := range cfg.StatsColumns.Server.Columns {
-			if alias, ok := serverColumnAliases[col]; ok {
-				serverCols = append(serverCols, fmt.Sprintf("%s AS \"%s\"", col, alias))
-			}
-		}
 		serverQuery := fmt.Sprintf("SELECT %s FROM traffic_stats ORDER BY %s %s;",
-			strings.Join(serverCols, ", "), cfg.StatsColumns.Server.SortBy, cfg.StatsColumns.Server.SortOrder)
+			aliasedColumns(cfg.StatsColumns.Server.Columns, serverColumnAliases), cfg.StatsColumns.Server.SortBy, cfg.StatsColumns.Server.SortOrder)
 
 		err := manager.ExecuteLowPriority(func(db *sql.DB) error {
 			cfg.Logger.Debug("Executing server custom stats query", "query", serverQuery)
@@ -256,7 +345,7 @@ func buildServerCustomStats(builder *strings.Builder, manager *manager.DatabaseM
 			defer rows.Close()
 
 			appendStats(builder, "➤  Server Statistics:\n")
-			serverTable, err := formatTable(rows, trafficAliases, cfg)
+			serverTable, err := formatTable(rows, trafficColumnAliases, cfg)
 			if err != nil {
 				cfg.Logger.Error("Failed to format server stats table", "error", err)
 				return fmt.Errorf("failed to format server stats table: %v", err)
@@ -280,38 +369,7 @@ func buildServerCustomStats(builder *strings.Builder, manager *manager.DatabaseM
 func buildClientCustomStats(builder *strings.Builder, manager *manager.DatabaseManager, cfg *config.Config, sortBy, sortOrder string) error {
 	cfg.Logger.Debug("Collecting custom client statistics")
 
-	clientColumnAliases := map[string]string{
-		"user":          "User",
-		"uuid":          "ID",
-		"last_seen":     "Last seen",
-		"rate":          "Rate",
-		"uplink":        "Uplink",
-		"downlink":      "Downlink",
-		"sess_uplink":   "Sess Up",
-		"sess_downlink": "Sess Down",
-		"enabled":       "Enabled",
-		"sub_end":       "Sub end",
-		"renew":         "Renew",
-		"lim_ip":        "Lim",
-		"ips":           "Ips",
-		"created":       "Created",
-	}
-	clientAliases := []string{
-		"Rate",
-		"Uplink",
-		"Downlink",
-		"Sess Up",
-		"Sess Down",
-	}
-
 	if len(cfg.StatsColumns.Client.Columns) > 0 {
-		var clientCols []string
-		for _, col := range cfg.StatsColumns.Client.Columns {
-			if alias, ok := clientColumnAliases[col]; ok {
-				clientCols = append(clientCols, fmt.Sprintf("%s AS \"%s\"", col, alias))
-			}
-		}
-
 		clientSortBy := cfg.StatsColumns.Client.SortBy
 		if sortBy != "" {
 			clientSortBy = sortBy
@@ -323,7 +381,7 @@ func buildClientCustomStats(builder *strings.Builder, manager *manager.DatabaseM
 		}
 
 		clientQuery := fmt.Sprintf("SELECT %s FROM clients_stats ORDER BY %s %s;",
-			strings.Join(clientCols, ", "), clientSortBy, clientSortOrder)
+			aliasedColumns(cfg.StatsColumns.Client.Columns, clientColumnAliases), clientSortBy, clientSortOrder)
 
 		err := manager.ExecuteLowPriority(func(db *sql.DB) error {
 			cfg.Logger.Debug("Executing client stats query", "query", clientQuery)
@@ -335,7 +393,7 @@ func buildClientCustomStats(builder *strings.Builder, manager *manager.DatabaseM
 			defer rows.Close()
 
 			appendStats(builder, "➤  Client Statistics:\n")
-			clientTable, err := formatTable(rows, clientAliases, cfg)
+			clientTable, err := formatTable(rows, trafficColumnAliases, cfg)
 			if err != nil {
 				cfg.Logger.Error("Failed to format client stats table", "error", err)
 				return fmt.Errorf("failed to format client stats table: %v", err)
@@ -382,6 +440,46 @@ func StatsCustomHandler(manager *manager.DatabaseManager, cfg *config.Config) ht
 			return
 		}
 
+		if wantsJSON(r) {
+			clientSortBy := cfg.StatsColumns.Client.SortBy
+			if sortBy != "" {
+				clientSortBy = sortBy
+			}
+			clientSortOrder := cfg.StatsColumns.Client.SortOrder
+			if sortOrder != "" {
+				clientSortOrder = sortOrder
+			}
+
+			var resp StatsJSON
+			if cfg.Features["system_monitoring"] {
+				resp.ServerState = buildServerStateJSON(cfg)
+			}
+			if cfg.Features["network"] {
+				resp.Network = buildNetworkJSON(cfg)
+			}
+			if len(cfg.StatsColumns.Server.Columns) > 0 {
+				server, err := queryStatsTable(manager, cfg, "traffic_stats", cfg.StatsColumns.Server.Columns, cfg.StatsColumns.Server.SortBy+" "+cfg.StatsColumns.Server.SortOrder)
+				if err != nil {
+					http.Error(w, "Error retrieving server statistics", http.StatusInternalServerError)
+					return
+				}
+				resp.Server = server
+			}
+			if len(cfg.StatsColumns.Client.Columns) > 0 {
+				clients, err := queryStatsTable(manager, cfg, "clients_stats", cfg.StatsColumns.Client.Columns, clientSortBy+" "+clientSortOrder)
+				if err != nil {
+					http.Error(w, "Error retrieving client statistics", http.StatusInternalServerError)
+					return
+				}
+				resp.Clients = clients
+			}
+
+			if writeStatsJSON(w, cfg, resp) {
+				cfg.Logger.Info("API stats: completed successfully", "format", "json", "sort_by", sortBy, "sort_order", sortOrder)
+			}
+			return
+		}
+
 		var statsBuilder strings.Builder
 
 		if cfg.Features["system_monitoring"] {
@@ -422,30 +520,8 @@ func buildTrafficStats(builder *strings.Builder, manager *manager.DatabaseManage
 	cfg.Logger.Debug("Collecting traffic statistics")
 
 	appendStats(builder, "➤  Server Statistics:\n")
-	var serverQuery string
-	var trafficColsServer []string
-	switch mode {
-	case "minimal", "standard":
-		serverQuery = `
-            SELECT source AS "Source",
-                   rate AS "Rate",
-                   uplink AS "Uplink",
-                   downlink AS "Downlink"
-            FROM traffic_stats;
-        `
-		trafficColsServer = []string{"Rate", "Uplink", "Downlink"}
-	case "extended", "full":
-		serverQuery = `
-            SELECT source AS "Source",
-                   rate AS "Rate",
-                   sess_uplink AS "Sess Up",
-                   sess_downlink AS "Sess Down",
-                   uplink AS "Uplink",
-                   downlink AS "Downlink"
-            FROM traffic_stats;
-        `
-		trafficColsServer = []string{"Rate", "Sess Up", "Sess Down", "Uplink", "Downlink"}
-	}
+	columns := baseStatsColumns[mode]
+	serverQuery := fmt.Sprintf("SELECT %s FROM traffic_stats;", aliasedColumns(columns.server, serverColumnAliases))
 
 	err := manager.ExecuteLowPriority(func(db *sql.DB) error {
 		cfg.Logger.Debug("Executing server traffic stats query", "query", serverQuery)
@@ -456,7 +532,7 @@ func buildTrafficStats(builder *strings.Builder, manager *manager.DatabaseManage
 		}
 		defer rows.Close()
 
-		serverTable, err := formatTable(rows, trafficColsServer, cfg)
+		serverTable, err := formatTable(rows, trafficColumnAliases, cfg)
 		if err != nil {
 			cfg.Logger.Error("Failed to format server stats table", "error", err)
 			return err
@@ -470,71 +546,8 @@ func buildTrafficStats(builder *strings.Builder, manager *manager.DatabaseManage
 	}
 
 	appendStats(builder, "\n➤  Client Statistics:\n")
-	var clientQuery string
-	var trafficColsClients []string
-	switch mode {
-	case "minimal":
-		clientQuery = fmt.Sprintf(`
-            SELECT user AS "User", 
-                   last_seen AS "Last seen",
-                   rate AS "Rate", 
-                   uplink AS "Uplink", 
-                   downlink AS "Downlink"
-            FROM clients_stats
-            ORDER BY %s %s;`, sortBy, sortOrder)
-		trafficColsClients = []string{"Rate", "Uplink", "Downlink"}
-	case "standard":
-		clientQuery = fmt.Sprintf(`
-            SELECT user AS "User", 
-                   last_seen AS "Last seen",
-                   rate AS "Rate", 
-                   uplink AS "Uplink", 
-                   downlink AS "Downlink", 
-                   enabled AS "Enabled", 
-                   sub_end AS "Sub end",
-                   renew AS "Renew", 
-                   lim_ip AS "Lim", 
-                   ips AS "Ips"
-            FROM clients_stats
-            ORDER BY %s %s;`, sortBy, sortOrder)
-		trafficColsClients = []string{"Rate", "Uplink", "Downlink"}
-	case "extended":
-		clientQuery = fmt.Sprintf(`
-            SELECT user AS "User", 
-                   last_seen AS "Last seen",
-                   rate AS "Rate", 
-                   sess_uplink AS "Sess Up", 
-                   sess_downlink AS "Sess Down",
-                   uplink AS "Uplink", 
-                   downlink AS "Downlink", 
-                   enabled AS "Enabled", 
-                   sub_end AS "Sub end",
-                   renew AS "Renew", 
-                   lim_ip AS "Lim", 
-                   ips AS "Ips"
-            FROM clients_stats
-            ORDER BY %s %s;`, sortBy, sortOrder)
-		trafficColsClients = []string{"Rate", "Sess Up", "Sess Down", "Uplink", "Downlink"}
-	case "full":
-		clientQuery = fmt.Sprintf(`
-			SELECT user AS "User", 
-				   uuid AS "ID",
-				   last_seen AS "Last seen",
-				   rate AS "Rate",
-				   sess_uplink AS "Sess Up", 
-				   sess_downlink AS "Sess Down",
-				   uplink AS "Uplink", 
-				   downlink AS "Downlink", 
-				   enabled AS "Enabled", 
-				   sub_end AS "Sub end",
-				   renew AS "Renew", 
-				   lim_ip AS "Lim", 
-				   ips AS "Ips",
-				   created AS "Created"
-		    FROM clients_stats
-		    ORDER BY %s %s;`, sortBy, sortOrder)
-		trafficColsClients = []string{"Rate", "Sess Up", "Sess Down", "Uplink", "Downlink"}
-	}
+	clientQuery := fmt.Sprintf("SELECT %s FROM clients_stats ORDER BY %s %s;",
+		aliasedColumns(columns.client, clientColumnAliases), sortBy, sortOrder)
 
 	err = manager.ExecuteLowPriority(func(db *sql.DB) error {
 		cfg.Logger.Debug("Executing client traffic stats query", "query", clientQuery)
@@ -545,7 +558,7 @@ func buildTrafficStats(builder *strings.Builder, manager *manager.DatabaseManage
 		}
 		defer rows.Close()
 
-		clientTable, err := formatTable(rows, trafficColsClients, cfg)
+		clientTable, err := formatTable(rows, trafficColumnAliases, cfg)
 		if err != nil {
 			cfg.Logger.Error("Failed to format client stats table", "error", err)
 			return err
@@ -608,6 +621,33 @@ func StatsHandler(manager *manager.DatabaseManager, cfg *config.Config) http.Han
 			return
 		}
 
+		if wantsJSON(r) {
+			resp := StatsJSON{Mode: mode}
+			if cfg.Features["system_monitoring"] {
+				resp.ServerState = buildServerStateJSON(cfg)
+			}
+			if cfg.Features["network"] {
+				resp.Network = buildNetworkJSON(cfg)
+			}
+			columns := baseStatsColumns[mode]
+			server, err := queryStatsTable(manager, cfg, "traffic_stats", columns.server, "")
+			if err != nil {
+				http.Error(w, "Error processing statistics", http.StatusInternalServerError)
+				return
+			}
+			clients, err := queryStatsTable(manager, cfg, "clients_stats", columns.client, sortBy+" "+sortOrder)
+			if err != nil {
+				http.Error(w, "Error processing statistics", http.StatusInternalServerError)
+				return
+			}
+			resp.Server, resp.Clients = server, clients
+
+			if writeStatsJSON(w, cfg, resp) {
+				cfg.Logger.Info("API stats/base: completed successfully", "format", "json", "mode", mode, "sort_by", sortBy, "sort_order", sortOrder)
+			}
+			return
+		}
+
 		var statsBuilder strings.Builder
 
 		if cfg.Features["system_monitoring"] {
@@ -899,6 +939,143 @@ func UpdateIPLimitHandler(manager *manager.DatabaseManager, cfg *config.Config)
 	}
 }
 
+// UpdateTrafficLimitHandler updates the traffic limits of a user.
+func UpdateTrafficLimitHandler(manager *manager.DatabaseManager, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg.Logger.Debug("Starting UpdateTrafficLimitHandler request processing")
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		if r.Method != http.MethodPatch {
+			cfg.Logger.Warn("Invalid HTTP method", "method", r.Method)
+			http.Error(w, "Invalid method. Use PATCH", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cfg.Logger.Debug("Parsing form data")
+		if err := r.ParseForm(); err != nil {
+			cfg.Logger.Error("Failed to parse form data", "error", err)
+			http.Error(w, "Error parsing form data", http.StatusBadRequest)
+			return
+		}
+
+		userIdentifier := r.FormValue("user")
+		if userIdentifier == "" {
+			cfg.Logger.Warn("Empty user identifier")
+			http.Error(w, "User field is required", http.StatusBadRequest)
+			return
+		}
+		if len(userIdentifier) > 40 {
+			cfg.Logger.Warn("User identifier too long", "length", len(userIdentifier))
+			http.Error(w, "User identifier too long (max 40 characters)", http.StatusBadRequest)
+			return
+		}
+
+		var limit db.TrafficLimit
+		for _, field := range []struct {
+			name  string
+			value *int64
+		}{
+			{"traffic_limit", &limit.Total},
+			{"uplink_limit", &limit.Uplink},
+			{"downlink_limit", &limit.Downlink},
+		} {
+			value := r.FormValue(field.name)
+			if value == "" {
+				continue
+			}
+			size, err := util.ParseDataSize(value)
+			if err != nil {
+				cfg.Logger.Warn("Invalid traffic limit value", "field", field.name, "value", value, "error", err)
+				http.Error(w, fmt.Sprintf("Invalid %s value: must be bytes or a size like 500MiB, 100GB", field.name), http.StatusBadRequest)
+				return
+			}
+			*field.value = size
+		}
+		cfg.Logger.Trace("Received form parameters", "user", userIdentifier, "traffic_limit", limit.Total, "uplink_limit", limit.Uplink, "downlink_limit", limit.Downlink)
+
+		if err := db.UpdateTrafficLimit(manager, cfg, userIdentifier, limit); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if err := db.CheckTrafficLimits(manager, cfg); err != nil {
+			cfg.Logger.Error("Failed to apply traffic limits", "error", err)
+		}
+
+		cfg.Logger.Info("API update_traffic_limit: traffic limit update request completed successfully", "user", userIdentifier, "traffic_limit", limit.Total, "uplink_limit", limit.Uplink, "downlink_limit", limit.Downlink)
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "Traffic limit updated successfully")
+	}
+}
+
+// UpdateResetPolicyHandler updates the traffic reset policy for a user in the clients_stats table.
+func UpdateResetPolicyHandler(manager *manager.DatabaseManager, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg.Logger.Debug("Starting UpdateResetPolicyHandler request processing")
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		if r.Method != http.MethodPatch {
+			cfg.Logger.Warn("Invalid HTTP method", "method", r.Method)
+			http.Error(w, "Invalid method. Use PATCH", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cfg.Logger.Debug("Parsing form data")
+		if err := r.ParseForm(); err != nil {
+			cfg.Logger.Error("Failed to parse form data", "error", err)
+			http.Error(w, "Error parsing form data", http.StatusBadRequest)
+			return
+		}
+
+		userIdentifier := r.FormValue("user")
+		policy := r.FormValue("reset_policy")
+		dayStr := r.FormValue("reset_day")
+		cfg.Logger.Trace("Received form parameters", "user", userIdentifier, "reset_policy", policy, "reset_day", dayStr)
+
+		if userIdentifier == "" {
+			cfg.Logger.Warn("Empty user identifier")
+			http.Error(w, "User field is required", http.StatusBadRequest)
+			return
+		}
+		if len(userIdentifier) > 40 {
+			cfg.Logger.Warn("User identifier too long", "length", len(userIdentifier))
+			http.Error(w, "User identifier too long (max 40 characters)", http.StatusBadRequest)
+			return
+		}
+		if policy == "" {
+			policy = db.ResetPolicyNone
+		}
+
+		day := 0
+		if dayStr != "" {
+			var err error
+			day, err = strconv.Atoi(dayStr)
+			if err != nil {
+				cfg.Logger.Warn("Invalid reset_day value", "reset_day", dayStr)
+				http.Error(w, "Invalid reset_day value, must be a number", http.StatusBadRequest)
+				return
+			}
+		}
+		day, err := db.ValidateResetPolicy(policy, day)
+		if err != nil {
+			cfg.Logger.Warn("Invalid reset policy", "reset_policy", policy, "reset_day", dayStr, "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := db.UpdateResetPolicy(manager, cfg, userIdentifier, policy, day); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		cfg.Logger.Info("API update_reset_policy: reset policy update request completed successfully", "user", userIdentifier, "reset_policy", policy, "reset_day", day)
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "Reset policy updated successfully")
+	}
+}
+
 // DeleteDNSStatsHandler deletes all records from the dns_stats table.
 func DeleteDNSStatsHandler(manager *manager.DatabaseManager, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1177,56 +1354,184 @@ func UpdateRenewHandler(manager *manager.DatabaseManager, cfg *config.Config) ht
 	}
 }
 
+// InboundClient is the client of a user added to one inbound.
+type InboundClient struct {
+	Tag        string `json:"tag"`
+	Protocol   string `json:"protocol,omitempty"`
+	Credential string `json:"credential"` // As given or generated, e.g. uuid:password for tuic
+}
+
+// DBCredential returns the credential of the client as stored in clients_stats, e.g. the UUID for tuic.
+func (c InboundClient) DBCredential(user string, cfg *config.Config) string {
+	if cfg.V2rayStat.Type == "xray" {
+		return config.NewXrayClient(c.Protocol, user, c.Credential).Credential(c.Protocol)
+	}
+	return config.NewSingboxClient(c.Protocol, user, c.Credential).Credential(c.Protocol)
+}
+
+// expandInboundTags removes duplicate inbound tags, replacing the tag "all" with every inbound in allTags.
+func expandInboundTags(inboundTags, allTags []string) ([]string, error) {
+	if slices.Contains(inboundTags, "all") {
+		inboundTags = allTags
+	}
+	var tags []string
+	for _, tag := range inboundTags {
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("no inbounds to add the user to")
+	}
+	return tags, nil
+}
+
+// credentialGenerator returns a function that yields the credential of a user for an inbound protocol
+// and method: the given credential where it fits the protocol, otherwise a credential generated once
+// per protocol and method, so that inbounds of one protocol share a credential. The second function
+// returns an error if a credential was given but fits none of the inbounds it was asked for.
+func credentialGenerator(credential string, cfg *config.Config) (func(protocol, method string) (string, error), func() error) {
+	generated := make(map[string]string)
+	used := false
+	var rejected error
+	credentialFor := func(protocol, method string) (string, error) {
+		if credential != "" {
+			err := config.ValidateCredential(protocol, method, credential)
+			if err == nil {
+				used = true
+				return credential, nil
+			}
+			cfg.Logger.Debug("Credential does not fit inbound, generating one", "protocol", protocol, "method", method, "error", err)
+			if rejected == nil {
+				rejected = err
+			}
+		}
+		key := protocol + "/" + method
+		if c, ok := generated[key]; ok {
+			return c, nil
+		}
+		c, err := generateRandomPassword(protocol, method, cfg)
+		if err != nil {
+			return "", err
+		}
+		generated[key] = c
+		return c, nil
+	}
+	check := func() error {
+		if credential != "" && !used {
+			cfg.Logger.Warn("Credential does not fit any inbound", "error", rejected)
+			return fmt.Errorf("credential does not fit any of the inbounds: %v", rejected)
+		}
+		return nil
+	}
+	return credentialFor, check
+}
+
+// authLuaCredential returns the auth.lua credential of a user, taken from the first VLESS or Trojan
+// client: the raw UUID for vless and the SHA-224 hash of the password for trojan.
+func authLuaCredential(clients []InboundClient, cfg *config.Config) (string, bool) {
+	i := slices.IndexFunc(clients, func(c InboundClient) bool { return c.Protocol == "vless" || c.Protocol == "trojan" })
+	if i < 0 {
+		return "", false
+	}
+	if clients[i].Protocol == "trojan" {
+		hash := sha256.Sum224([]byte(clients[i].Credential))
+		credential := hex.EncodeToString(hash[:])
+		cfg.Logger.Trace("Hashed credential for trojan", "credential", credential)
+		return credential, true
+	}
+	cfg.Logger.Trace("Using raw credential for vless", "credential", clients[i].Credential)
+	return clients[i].Credential, true
+}
+
 // AddUserToConfig adds a user to the configuration file.
 func AddUserToConfig(user, credential, inboundTag string, cfg *config.Config) error {
-	cfg.Logger.Debug("Starting user addition to configuration", "user", user, "inboundTag", inboundTag)
+	_, err := AddUserToInbounds(user, credential, []string{inboundTag}, cfg)
+	return err
+}
+
+// InboundUser is a user to add to inbounds of the configuration file.
+type InboundUser struct {
+	User        string
+	Credential  string
+	InboundTags []string
+}
+
+// AddUserToInbounds adds a user to several inbounds of the configuration file, or to every inbound
+// with a supported protocol if inboundTags contains "all". A non-empty credential is used for every
+// inbound whose protocol it fits; the other inbounds get a credential of the right type generated
+// once per protocol. The file is written once, so nothing is changed if any of the inbounds fails.
+func AddUserToInbounds(user, credential string, inboundTags []string, cfg *config.Config) ([]InboundClient, error) {
+	added, failed, err := AddUsersToInbounds([]InboundUser{{User: user, Credential: credential, InboundTags: inboundTags}}, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := failed[user]; err != nil {
+		return nil, err
+	}
+	return added[user], nil
+}
+
+// AddUsersToInbounds adds several users to inbounds of the configuration file like AddUserToInbounds,
+// reading, validating and writing the file once. It returns the clients added for each user and the
+// errors of users that could not be added by user name; a user is either added to all of its inbounds
+// or to none, and a user listed again is skipped. The error is only set if the configuration file
+// could not be read or written.
+func AddUsersToInbounds(users []InboundUser, cfg *config.Config) (map[string][]InboundClient, map[string]error, error) {
+	cfg.Logger.Debug("Starting user addition to configuration", "count", len(users))
+	unlock, err := config.LockCoreFiles(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
 	configPath := cfg.Core.Config
 	data, err := os.ReadFile(configPath)
 	if err != nil {
 		cfg.Logger.Error("Failed to read config.json", "path", configPath, "error", err)
-		return fmt.Errorf("failed to read config.json: %v", err)
+		return nil, nil, fmt.Errorf("failed to read config.json: %v", err)
 	}
 
 	proxyType := cfg.V2rayStat.Type
 	var configData any
-	var protocol string
+	added := make(map[string][]InboundClient)
+	failed := make(map[string]error)
+	addUser := func(u InboundUser, add func(u InboundUser) ([]InboundClient, error)) {
+		if _, exists := added[u.User]; exists || failed[u.User] != nil {
+			cfg.Logger.Warn("User listed more than once, skipping", "user", u.User)
+			return
+		}
+		clients, err := add(u)
+		if err != nil {
+			cfg.Logger.Warn("Failed to add user to configuration", "user", u.User, "error", err)
+			failed[u.User] = err
+			return
+		}
+		added[u.User] = clients
+	}
+
+	type liveClient struct {
+		user    string
+		inbound int
+		client  config.XrayClient
+	}
+	var liveClients []liveClient
 
 	switch proxyType {
 	case "xray":
 		var cfgXray config.ConfigXray
 		if err := json.Unmarshal(data, &cfgXray); err != nil {
 			cfg.Logger.Error("Failed to parse JSON", "error", err)
-			return fmt.Errorf("failed to parse JSON: %v", err)
-		}
-
-		found := false
-		for i, inbound := range cfgXray.Inbounds {
-			if inbound.Tag == inboundTag {
-				protocol = inbound.Protocol
-				for _, client := range inbound.Settings.Clients {
-					if protocol == "vless" && client.ID == credential {
-						cfg.Logger.Warn("User with this ID already exists", "credential", credential)
-						return fmt.Errorf("user with this id already exists")
-					} else if protocol == "trojan" && client.Password == credential {
-						cfg.Logger.Warn("User with this password already exists", "credential", credential)
-						return fmt.Errorf("user with this password already exists")
-					}
-				}
-				newClient := config.XrayClient{Email: user}
-				switch protocol {
-				case "vless":
-					newClient.ID = credential
-				case "trojan":
-					newClient.Password = credential
-				}
-				cfgXray.Inbounds[i].Settings.Clients = append(cfgXray.Inbounds[i].Settings.Clients, newClient)
-				found = true
-				break
-			}
+			return nil, nil, fmt.Errorf("failed to parse JSON: %v", err)
 		}
-		if !found {
-			cfg.Logger.Warn("Inbound not found", "inboundTag", inboundTag)
-			return fmt.Errorf("inbound with tag %s not found", inboundTag)
+		for _, u := range users {
+			addUser(u, func(u InboundUser) ([]InboundClient, error) {
+				clients, inbounds, newClients, err := addUserXray(&cfgXray, u, cfg)
+				for i, inbound := range inbounds {
+					liveClients = append(liveClients, liveClient{user: u.User, inbound: inbound, client: newClients[i]})
+				}
+				return clients, err
+			})
 		}
 		configData = cfgXray
 
@@ -1234,76 +1539,208 @@ func AddUserToConfig(user, credential, inboundTag string, cfg *config.Config) er
 		var cfgSingBox config.ConfigSingbox
 		if err := json.Unmarshal(data, &cfgSingBox); err != nil {
 			cfg.Logger.Error("Failed to parse JSON", "error", err)
-			return fmt.Errorf("failed to parse JSON: %v", err)
-		}
-
-		found := false
-		for i, inbound := range cfgSingBox.Inbounds {
-			if inbound.Tag == inboundTag {
-				protocol = inbound.Type
-				for _, user := range inbound.Users {
-					if protocol == "vless" && user.UUID == credential {
-						cfg.Logger.Warn("User with this UUID already exists", "credential", credential)
-						return fmt.Errorf("user with this uuid already exists")
-					} else if protocol == "trojan" && user.Password == credential {
-						cfg.Logger.Warn("User with this password already exists", "credential", credential)
-						return fmt.Errorf("user with this password already exists")
-					}
-				}
-				newUser := config.SingboxClient{Name: user}
-				switch protocol {
-				case "vless":
-					newUser.UUID = credential
-				case "trojan":
-					newUser.Password = credential
-				}
-				cfgSingBox.Inbounds[i].Users = append(cfgSingBox.Inbounds[i].Users, newUser)
-				found = true
-				break
-			}
+			return nil, nil, fmt.Errorf("failed to parse JSON: %v", err)
 		}
-		if !found {
-			cfg.Logger.Warn("Inbound not found", "inboundTag", inboundTag)
-			return fmt.Errorf("inbound with tag %s not found", inboundTag)
+		for _, u := range users {
+			addUser(u, func(u InboundUser) ([]InboundClient, error) {
+				return addUserSingbox(&cfgSingBox, u, cfg)
+			})
 		}
 		configData = cfgSingBox
 
 	default:
 		cfg.Logger.Warn("Unsupported core type", "proxyType", proxyType)
-		return fmt.Errorf("unsupported core type: %s", proxyType)
+		return nil, nil, fmt.Errorf("unsupported core type: %s", proxyType)
+	}
+	if len(added) == 0 {
+		return added, failed, nil
 	}
 
-	updateData, err := json.MarshalIndent(configData, "", "  ")
+	updateData, err := config.MarshalCoreConfig(configData)
 	if err != nil {
 		cfg.Logger.Error("Failed to marshal JSON", "error", err)
-		return fmt.Errorf("failed to marshal JSON: %v", err)
+		return nil, nil, fmt.Errorf("failed to marshal JSON: %v", err)
 	}
-	if err := os.WriteFile(configPath, updateData, 0644); err != nil {
+	if err := config.WriteCoreFile(cfg, configPath, updateData); err != nil {
 		cfg.Logger.Error("Failed to write config.json", "path", configPath, "error", err)
-		return fmt.Errorf("failed to write config.json: %v", err)
+		return nil, nil, fmt.Errorf("failed to write config.json: %w", err)
 	}
 
-	cfg.Logger.Debug("User added to configuration", "user", user, "inboundTag", inboundTag)
+	cfg.Logger.Debug("Users added to configuration", "count", len(added))
+
+	if cfgXray, ok := configData.(config.ConfigXray); ok && coreapi.LiveUpdateEnabled(cfg) {
+		for _, live := range liveClients {
+			inbound := cfgXray.Inbounds[live.inbound]
+			if err := coreapi.AddInboundUser(cfg, inbound, live.client); err != nil {
+				cfg.Logger.Error("Failed to add user to running core", "user", live.user, "inboundTag", inbound.Tag, "error", err)
+			} else {
+				cfg.Logger.Debug("User added to running core", "user", live.user, "inboundTag", inbound.Tag)
+			}
+		}
+	}
 
 	if cfg.Features["auth_lua"] {
-		cfg.Logger.Debug("Adding user to auth.lua", "user", user)
-		var credentialToAdd string
-		if protocol == "trojan" {
-			hash := sha256.Sum224([]byte(credential))
-			credentialToAdd = hex.EncodeToString(hash[:])
-			cfg.Logger.Trace("Hashed credential for trojan", "credential", credentialToAdd)
-		} else {
-			credentialToAdd = credential
-			cfg.Logger.Trace("Using raw credential for vless", "credential", credentialToAdd)
+		for _, u := range users {
+			credentialToAdd, ok := authLuaCredential(added[u.User], cfg)
+			if !ok {
+				continue
+			}
+			cfg.Logger.Debug("Adding user to auth.lua", "user", u.User)
+			if err := lua.AddUserToAuthLua(cfg, u.User, credentialToAdd); err != nil {
+				cfg.Logger.Error("Failed to add user to auth.lua", "user", u.User, "error", err)
+			} else {
+				cfg.Logger.Debug("User added to auth.lua", "user", u.User)
+			}
 		}
-		if err := lua.AddUserToAuthLua(cfg, user, credentialToAdd); err != nil {
-			cfg.Logger.Error("Failed to add user to auth.lua", "user", user, "error", err)
-		} else {
-			cfg.Logger.Debug("User added to auth.lua", "user", user)
+	}
+
+	return added, failed, nil
+}
+
+// addUserXray adds a user to inbounds of an Xray config, changing nothing if any of the inbounds
+// fails. It returns the added clients and, for the running core, their inbound indexes and clients.
+func addUserXray(cfgXray *config.ConfigXray, u InboundUser, cfg *config.Config) ([]InboundClient, []int, []config.XrayClient, error) {
+	var allTags []string
+	for _, inbound := range cfgXray.Inbounds {
+		if config.IsUserProtocol(inbound.Protocol) {
+			allTags = append(allTags, inbound.Tag)
 		}
 	}
+	tags, err := expandInboundTags(u.InboundTags, allTags)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	credentialFor, checkCredential := credentialGenerator(u.Credential, cfg)
+
+	var added []InboundClient
+	var inbounds []int
+	var newClients []config.XrayClient
+	for _, inboundTag := range tags {
+		i := slices.IndexFunc(cfgXray.Inbounds, func(inbound config.XrayInbound) bool { return inbound.Tag == inboundTag })
+		if i < 0 {
+			cfg.Logger.Warn("Inbound not found", "inboundTag", inboundTag)
+			return nil, nil, nil, fmt.Errorf("inbound with tag %s not found", inboundTag)
+		}
+		inbound := cfgXray.Inbounds[i]
+		protocol := inbound.Protocol
+		if !config.IsUserProtocol(protocol) {
+			cfg.Logger.Warn("Unsupported protocol", "protocol", protocol, "inboundTag", inboundTag)
+			return nil, nil, nil, fmt.Errorf("inbound %s uses unsupported protocol %s", inboundTag, protocol)
+		}
+		method := ""
+		if inbound.Settings.Method != nil {
+			method = *inbound.Settings.Method
+		}
+		userCredential, err := credentialFor(protocol, method)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("inbound %s: %v", inboundTag, err)
+		}
 
-	return nil
+		newClient := config.NewXrayClient(protocol, u.User, userCredential)
+		for _, client := range inbound.Settings.Clients {
+			if client.Email == u.User {
+				cfg.Logger.Warn("User already exists in inbound", "user", u.User, "inboundTag", inboundTag)
+				return nil, nil, nil, fmt.Errorf("user %s already exists in inbound %s", u.User, inboundTag)
+			}
+			if client.Credential(protocol) == newClient.Credential(protocol) {
+				cfg.Logger.Warn("User with this credential already exists", "credential", userCredential, "inboundTag", inboundTag)
+				return nil, nil, nil, fmt.Errorf("user with this credential already exists in inbound %s", inboundTag)
+			}
+			if newClient.Flow == "" && client.Flow != "" {
+				newClient.Flow = client.Flow
+			}
+		}
+		inbounds = append(inbounds, i)
+		newClients = append(newClients, newClient)
+		added = append(added, InboundClient{Tag: inboundTag, Protocol: protocol, Credential: userCredential})
+	}
+
+	if err := checkCredential(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	for j, i := range inbounds {
+		cfgXray.Inbounds[i].Settings.Clients = append(cfgXray.Inbounds[i].Settings.Clients, newClients[j])
+	}
+	return added, inbounds, newClients, nil
+}
+
+// addUserSingbox adds a user to inbounds of a sing-box config, changing nothing if any of the
+// inbounds fails, and returns the added clients.
+func addUserSingbox(cfgSingBox *config.ConfigSingbox, u InboundUser, cfg *config.Config) ([]InboundClient, error) {
+	var allTags []string
+	for _, inbound := range cfgSingBox.Inbounds {
+		if config.IsUserProtocol(inbound.Type) {
+			allTags = append(allTags, inbound.Tag)
+		}
+	}
+	tags, err := expandInboundTags(u.InboundTags, allTags)
+	if err != nil {
+		return nil, err
+	}
+	credentialFor, checkCredential := credentialGenerator(u.Credential, cfg)
+
+	var added []InboundClient
+	var inbounds []int
+	var newUsers []config.SingboxClient
+	for _, inboundTag := range tags {
+		i := slices.IndexFunc(cfgSingBox.Inbounds, func(inbound config.SingboxInbound) bool { return inbound.Tag == inboundTag })
+		if i < 0 {
+			cfg.Logger.Warn("Inbound not found", "inboundTag", inboundTag)
+			return nil, fmt.Errorf("inbound with tag %s not found", inboundTag)
+		}
+		inbound := cfgSingBox.Inbounds[i]
+		protocol := inbound.Type
+		if !config.IsUserProtocol(protocol) {
+			cfg.Logger.Warn("Unsupported protocol", "protocol", protocol, "inboundTag", inboundTag)
+			return nil, fmt.Errorf("inbound %s uses unsupported protocol %s", inboundTag, protocol)
+		}
+		userCredential, err := credentialFor(protocol, inbound.Method)
+		if err != nil {
+			return nil, fmt.Errorf("inbound %s: %v", inboundTag, err)
+		}
+
+		newUser := config.NewSingboxClient(protocol, u.User, userCredential)
+		for _, existing := range inbound.Users {
+			if existing.Name == u.User {
+				cfg.Logger.Warn("User already exists in inbound", "user", u.User, "inboundTag", inboundTag)
+				return nil, fmt.Errorf("user %s already exists in inbound %s", u.User, inboundTag)
+			}
+			if existing.Credential(protocol) == newUser.Credential(protocol) {
+				cfg.Logger.Warn("User with this credential already exists", "credential", userCredential, "inboundTag", inboundTag)
+				return nil, fmt.Errorf("user with this credential already exists in inbound %s", inboundTag)
+			}
+			if newUser.Flow == "" && existing.Flow != "" {
+				newUser.Flow = existing.Flow
+			}
+		}
+		inbounds = append(inbounds, i)
+		newUsers = append(newUsers, newUser)
+		added = append(added, InboundClient{Tag: inboundTag, Protocol: protocol, Credential: userCredential})
+	}
+
+	if err := checkCredential(); err != nil {
+		return nil, err
+	}
+
+	for j, i := range inbounds {
+		cfgSingBox.Inbounds[i].Users = append(cfgSingBox.Inbounds[i].Users, newUsers[j])
+	}
+	return added, nil
+}
+
+// parseInboundTags splits comma-separated inbound tags, defaulting to vless-in.
+func parseInboundTags(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		tags = []string{"vless-in"}
+	}
+	return tags
 }
 
 // AddUserHandler handles HTTP requests to add a new user.
@@ -1318,6 +1755,10 @@ func AddUserHandler(cfg *config.Config) http.HandlerFunc {
 			http.Error(w, "Invalid method. Use POST", http.StatusMethodNotAllowed)
 			return
 		}
+		dryRun, ok := parseDryRun(w, r, cfg)
+		if !ok {
+			return
+		}
 
 		if err := r.ParseForm(); err != nil {
 			cfg.Logger.Error("Failed to parse form data", "error", err)
@@ -1327,32 +1768,47 @@ func AddUserHandler(cfg *config.Config) http.HandlerFunc {
 
 		userIdentifier := r.FormValue("user")
 		credential := r.FormValue("credential")
-		inboundTag := r.FormValue("inboundTag")
+		inboundTags := parseInboundTags(r.FormValue("inboundTag"))
 
-		if userIdentifier == "" || credential == "" {
-			cfg.Logger.Warn("Missing or empty user or credential parameters")
-			http.Error(w, "user and credential are required", http.StatusBadRequest)
+		if userIdentifier == "" {
+			cfg.Logger.Warn("Missing or empty user parameter")
+			http.Error(w, "user is required", http.StatusBadRequest)
 			return
 		}
 
-		if len(userIdentifier) > 40 || len(credential) > 40 {
-			cfg.Logger.Warn("User or credential too long", "user_length", len(userIdentifier), "credential_length", len(credential))
-			http.Error(w, "user or credential too long (max 40 characters)", http.StatusBadRequest)
+		if len(userIdentifier) > 40 {
+			cfg.Logger.Warn("User identifier too long", "length", len(userIdentifier))
+			http.Error(w, "user too long (max 40 characters)", http.StatusBadRequest)
 			return
 		}
-
-		if inboundTag == "" {
-			inboundTag = "vless-in"
-			cfg.Logger.Debug("Setting default inboundTag", "inboundTag", inboundTag)
+		if len(credential) > maxCredentialLength {
+			cfg.Logger.Warn("Credential too long", "credential_length", len(credential))
+			http.Error(w, fmt.Sprintf("credential too long (max %d characters)", maxCredentialLength), http.StatusBadRequest)
+			return
 		}
 
-		cfg.Logger.Trace("Request parameters", "user", userIdentifier, "credential", credential, "inboundTag", inboundTag)
+		cfg.Logger.Trace("Request parameters", "user", userIdentifier, "credential", credential, "inboundTags", strings.Join(inboundTags, ","))
+
+		if dryRun {
+			diff, err := previewConfigChange(cfg, func(dryCfg *config.Config) error {
+				_, err := AddUserToInbounds(userIdentifier, credential, inboundTags, dryCfg)
+				return err
+			})
+			if err != nil {
+				cfg.Logger.Warn("Dry run of user addition failed", "user", userIdentifier, "error", err)
+				http.Error(w, err.Error(), configWriteStatus(err, http.StatusBadRequest))
+				return
+			}
+			cfg.Logger.Info("API add_user: dry run completed", "user", userIdentifier)
+			writeDryRun(w, cfg, diff)
+			return
+		}
 
 		cfg.Logger.Debug("Adding user to configuration", "user", userIdentifier)
-		err := AddUserToConfig(userIdentifier, credential, inboundTag, cfg)
+		_, err := AddUserToInbounds(userIdentifier, credential, inboundTags, cfg)
 		if err != nil {
 			cfg.Logger.Error("Failed to add user", "user", userIdentifier, "error", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), configWriteStatus(err, http.StatusBadRequest))
 			return
 		}
 
@@ -1365,7 +1821,7 @@ func AddUserHandler(cfg *config.Config) http.HandlerFunc {
 // saveConfig saves configuration data to a file.
 func saveConfig(w http.ResponseWriter, configPath string, configData any, cfg *config.Config) error {
 	cfg.Logger.Debug("Marshaling JSON for configuration", "path", configPath)
-	updateData, err := json.MarshalIndent(configData, "", "  ")
+	updateData, err := config.MarshalCoreConfig(configData)
 	if err != nil {
 		cfg.Logger.Error("Failed to marshal JSON", "error", err)
 		if w != nil {
@@ -1375,7 +1831,7 @@ func saveConfig(w http.ResponseWriter, configPath string, configData any, cfg *c
 	}
 
 	cfg.Logger.Debug("Writing configuration file", "path", configPath)
-	if err := os.WriteFile(configPath, updateData, 0644); err != nil {
+	if err := config.WriteCoreFile(cfg, configPath, updateData); err != nil {
 		cfg.Logger.Error("Failed to write config.json", "path", configPath, "error", err)
 		if w != nil {
 			http.Error(w, "Error saving configuration", http.StatusInternalServerError)
@@ -1386,14 +1842,45 @@ func saveConfig(w http.ResponseWriter, configPath string, configData any, cfg *c
 	return nil
 }
 
+// configWriteStatus returns the HTTP status for an error of a change to the configuration files:
+// 422 if the core rejected the new config.json, otherwise status.
+func configWriteStatus(err error, status int) int {
+	if errors.Is(err, config.ErrCoreConfigRejected) {
+		return http.StatusUnprocessableEntity
+	}
+	return status
+}
+
 // DeleteUserFromConfig removes a user from the configuration files.
 func DeleteUserFromConfig(userIdentifier, inboundTag string, cfg *config.Config) error {
-	cfg.Logger.Debug("Starting user deletion from configuration", "user", userIdentifier, "inboundTag", inboundTag)
+	failed, err := DeleteUsersFromConfig([]string{userIdentifier}, inboundTag, cfg)
+	if err != nil {
+		return err
+	}
+	return failed[userIdentifier]
+}
+
+// DeleteUsersFromConfig removes several users of an inbound, or of every inbound if inboundTag is
+// empty, from the configuration files, writing each file once. It returns the errors of users that
+// were not found by user name; the error is only set if the configuration files could not be read
+// or written.
+func DeleteUsersFromConfig(users []string, inboundTag string, cfg *config.Config) (map[string]error, error) {
+	cfg.Logger.Debug("Starting users deletion from configuration", "count", len(users), "inboundTag", inboundTag)
+	unlock, err := config.LockCoreFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	configPath := cfg.Core.Config
 	disabledUsersPath := filepath.Join(cfg.Core.Dir, ".disabled_users")
 	proxyType := cfg.V2rayStat.Type
 
-	var userRemoved bool
+	pending := make(map[string]bool, len(users))
+	for _, user := range users {
+		pending[user] = true
+	}
+	removedUsers := make(map[string]bool)
 
 	switch proxyType {
 	case "xray":
@@ -1401,12 +1888,12 @@ func DeleteUserFromConfig(userIdentifier, inboundTag string, cfg *config.Config)
 		mainConfigData, err := os.ReadFile(configPath)
 		if err != nil {
 			cfg.Logger.Error("Failed to read config.json", "path", configPath, "error", err)
-			return fmt.Errorf("failed to read config.json: %v", err)
+			return nil, fmt.Errorf("failed to read config.json: %v", err)
 		}
 		var mainConfig config.ConfigXray
 		if err := json.Unmarshal(mainConfigData, &mainConfig); err != nil {
 			cfg.Logger.Error("Failed to parse JSON for config.json", "error", err)
-			return fmt.Errorf("failed to parse JSON for config.json: %v", err)
+			return nil, fmt.Errorf("failed to parse JSON for config.json: %v", err)
 		}
 
 		cfg.Logger.Debug("Reading disabled users config", "path", disabledUsersPath)
@@ -1415,58 +1902,67 @@ func DeleteUserFromConfig(userIdentifier, inboundTag string, cfg *config.Config)
 		if err == nil && len(disabledConfigData) > 0 {
 			if err := json.Unmarshal(disabledConfigData, &disabledConfig); err != nil {
 				cfg.Logger.Error("Failed to parse JSON for .disabled_users", "error", err)
-				return fmt.Errorf("failed to parse JSON for .disabled_users: %v", err)
+				return nil, fmt.Errorf("failed to parse JSON for .disabled_users: %v", err)
 			}
 		} else {
 			disabledConfig = config.DisabledUsersConfigXray{Inbounds: []config.XrayInbound{}}
 		}
 
-		// Function to remove user from inbounds (Xray)
-		removeXrayUser := func(inbounds []config.XrayInbound) ([]config.XrayInbound, bool) {
+		// Function to remove users from inbounds (Xray), returns the inbound tags of removed users
+		removeXrayUsers := func(inbounds []config.XrayInbound) map[string][]string {
+			removed := make(map[string][]string)
 			for i, inbound := range inbounds {
-				if inbound.Tag == inboundTag {
+				if inboundTag == "" || inbound.Tag == inboundTag {
 					updatedClients := make([]config.XrayClient, 0, len(inbound.Settings.Clients))
 					for _, client := range inbound.Settings.Clients {
-						if client.Email != userIdentifier {
+						if pending[client.Email] {
+							removed[client.Email] = append(removed[client.Email], inbound.Tag)
+						} else {
 							updatedClients = append(updatedClients, client)
 						}
 					}
-					if len(updatedClients) < len(inbound.Settings.Clients) {
-						inbounds[i].Settings.Clients = updatedClients
-						return inbounds, true
-					}
+					inbounds[i].Settings.Clients = updatedClients
 				}
 			}
-			return inbounds, false
+			return removed
 		}
 
 		// Check and remove from config.json
-		mainUpdated, removedFromMain := removeXrayUser(mainConfig.Inbounds)
-		if removedFromMain {
-			mainConfig.Inbounds = mainUpdated
+		removedFromMain := removeXrayUsers(mainConfig.Inbounds)
+		if len(removedFromMain) > 0 {
 			if err := saveConfig(nil, configPath, mainConfig, cfg); err != nil {
-				return err
+				return nil, err
+			}
+			for user, tags := range removedFromMain {
+				removedUsers[user] = true
+				if coreapi.LiveUpdateEnabled(cfg) {
+					for _, tag := range tags {
+						if err := coreapi.RemoveInboundUser(cfg, tag, user); err != nil {
+							cfg.Logger.Error("Failed to remove user from running core", "user", user, "tag", tag, "error", err)
+						}
+					}
+				}
 			}
-			userRemoved = true
 		}
 
 		// Check and remove from .disabled_users
-		disabledUpdated, removedFromDisabled := removeXrayUser(disabledConfig.Inbounds)
-		if removedFromDisabled {
-			disabledConfig.Inbounds = disabledUpdated
+		removedFromDisabled := removeXrayUsers(disabledConfig.Inbounds)
+		if len(removedFromDisabled) > 0 {
 			if len(disabledConfig.Inbounds) > 0 {
 				if err := saveConfig(nil, disabledUsersPath, disabledConfig, cfg); err != nil {
-					return err
+					return nil, err
 				}
 			} else {
 				cfg.Logger.Debug("Removing empty .disabled_users file", "path", disabledUsersPath)
 				if err := os.Remove(disabledUsersPath); err != nil && !os.IsNotExist(err) {
 					cfg.Logger.Error("Failed to remove empty .disabled_users", "error", err)
-					return fmt.Errorf("failed to remove empty .disabled_users: %v", err)
+					return nil, fmt.Errorf("failed to remove empty .disabled_users: %v", err)
 				}
-				cfg.Logger.Debug("User removed from .disabled_users", "user", userIdentifier, "inboundTag", inboundTag)
 			}
-			userRemoved = true
+			for user := range removedFromDisabled {
+				removedUsers[user] = true
+				cfg.Logger.Debug("User removed from .disabled_users", "user", user, "inboundTag", inboundTag)
+			}
 		}
 
 	case "singbox":
@@ -1474,12 +1970,12 @@ func DeleteUserFromConfig(userIdentifier, inboundTag string, cfg *config.Config)
 		mainConfigData, err := os.ReadFile(configPath)
 		if err != nil {
 			cfg.Logger.Error("Failed to read config.json", "path", configPath, "error", err)
-			return fmt.Errorf("failed to read config.json: %v", err)
+			return nil, fmt.Errorf("failed to read config.json: %v", err)
 		}
 		var mainConfig config.ConfigSingbox
 		if err := json.Unmarshal(mainConfigData, &mainConfig); err != nil {
 			cfg.Logger.Error("Failed to parse JSON for config.json", "error", err)
-			return fmt.Errorf("failed to parse JSON for config.json: %v", err)
+			return nil, fmt.Errorf("failed to parse JSON for config.json: %v", err)
 		}
 
 		cfg.Logger.Debug("Reading disabled users config", "path", disabledUsersPath)
@@ -1488,79 +1984,88 @@ func DeleteUserFromConfig(userIdentifier, inboundTag string, cfg *config.Config)
 		if err == nil && len(disabledConfigData) > 0 {
 			if err := json.Unmarshal(disabledConfigData, &disabledConfig); err != nil {
 				cfg.Logger.Error("Failed to parse JSON for .disabled_users", "error", err)
-				return fmt.Errorf("failed to parse JSON for .disabled_users: %v", err)
+				return nil, fmt.Errorf("failed to parse JSON for .disabled_users: %v", err)
 			}
 		} else {
 			disabledConfig = config.DisabledUsersConfigSingbox{Inbounds: []config.SingboxInbound{}}
 		}
 
-		// Function to remove user from inbounds (Singbox)
-		removeSingboxUser := func(inbounds []config.SingboxInbound) ([]config.SingboxInbound, bool) {
+		// Function to remove users from inbounds (Singbox), returns the inbound tags of removed users
+		removeSingboxUsers := func(inbounds []config.SingboxInbound) map[string][]string {
+			removed := make(map[string][]string)
 			for i, inbound := range inbounds {
-				if inbound.Tag == inboundTag {
+				if inboundTag == "" || inbound.Tag == inboundTag {
 					updatedUsers := make([]config.SingboxClient, 0, len(inbound.Users))
 					for _, user := range inbound.Users {
-						if user.Name != userIdentifier {
+						if pending[user.Name] {
+							removed[user.Name] = append(removed[user.Name], inbound.Tag)
+						} else {
 							updatedUsers = append(updatedUsers, user)
 						}
 					}
-					if len(updatedUsers) < len(inbound.Users) {
-						inbounds[i].Users = updatedUsers
-						return inbounds, true
-					}
+					inbounds[i].Users = updatedUsers
 				}
 			}
-			return inbounds, false
+			return removed
 		}
 
 		// Check and remove from config.json
-		mainUpdated, removedFromMain := removeSingboxUser(mainConfig.Inbounds)
-		if removedFromMain {
-			mainConfig.Inbounds = mainUpdated
+		removedFromMain := removeSingboxUsers(mainConfig.Inbounds)
+		if len(removedFromMain) > 0 {
 			if err := saveConfig(nil, configPath, mainConfig, cfg); err != nil {
-				return err
+				return nil, err
+			}
+			for user := range removedFromMain {
+				removedUsers[user] = true
 			}
-			userRemoved = true
 		}
 
 		// Check and remove from .disabled_users
-		disabledUpdated, removedFromDisabled := removeSingboxUser(disabledConfig.Inbounds)
-		if removedFromDisabled {
-			disabledConfig.Inbounds = disabledUpdated
+		removedFromDisabled := removeSingboxUsers(disabledConfig.Inbounds)
+		if len(removedFromDisabled) > 0 {
 			if len(disabledConfig.Inbounds) > 0 {
 				if err := saveConfig(nil, disabledUsersPath, disabledConfig, cfg); err != nil {
-					return err
+					return nil, err
 				}
 			} else {
 				cfg.Logger.Debug("Removing empty .disabled_users file", "path", disabledUsersPath)
 				if err := os.Remove(disabledUsersPath); err != nil && !os.IsNotExist(err) {
 					cfg.Logger.Error("Failed to remove empty .disabled_users", "error", err)
-					return fmt.Errorf("failed to remove empty .disabled_users: %v", err)
+					return nil, fmt.Errorf("failed to remove empty .disabled_users: %v", err)
 				}
-				cfg.Logger.Debug("User removed from .disabled_users", "user", userIdentifier, "inboundTag", inboundTag)
 			}
-			userRemoved = true
+			for user := range removedFromDisabled {
+				removedUsers[user] = true
+				cfg.Logger.Debug("User removed from .disabled_users", "user", user, "inboundTag", inboundTag)
+			}
 		}
 	}
 
-	// Handle auth.lua update if user was removed
-	if userRemoved && cfg.Features["auth_lua"] {
-		cfg.Logger.Debug("Deleting user from auth.lua", "user", userIdentifier)
-		if err := lua.DeleteUserFromAuthLua(cfg, userIdentifier); err != nil {
-			cfg.Logger.Error("Failed to delete user from auth.lua", "user", userIdentifier, "error", err)
-		} else {
-			cfg.Logger.Debug("User removed from auth.lua", "user", userIdentifier)
+	failed := make(map[string]error)
+	for _, user := range users {
+		// Handle auth.lua update if user was removed
+		if removedUsers[user] {
+			if cfg.Features["auth_lua"] {
+				cfg.Logger.Debug("Deleting user from auth.lua", "user", user)
+				if err := lua.DeleteUserFromAuthLua(cfg, user); err != nil {
+					cfg.Logger.Error("Failed to delete user from auth.lua", "user", user, "error", err)
+				} else {
+					cfg.Logger.Debug("User removed from auth.lua", "user", user)
+				}
+			}
+			cfg.Logger.Debug("User deleted successfully", "user", user, "inboundTag", inboundTag)
+			continue
 		}
-	}
 
-	// If user not found
-	if !userRemoved {
-		cfg.Logger.Warn("User not found in configuration", "user", userIdentifier, "inboundTag", inboundTag)
-		return fmt.Errorf("user %s not found in inbound %s in either config.json or .disabled_users", userIdentifier, inboundTag)
+		// If user not found
+		cfg.Logger.Warn("User not found in configuration", "user", user, "inboundTag", inboundTag)
+		if inboundTag == "" {
+			failed[user] = fmt.Errorf("user %s not found in any inbound in either config.json or .disabled_users", user)
+		} else {
+			failed[user] = fmt.Errorf("user %s not found in inbound %s in either config.json or .disabled_users", user, inboundTag)
+		}
 	}
-
-	cfg.Logger.Debug("User deleted successfully", "user", userIdentifier, "inboundTag", inboundTag)
-	return nil
+	return failed, nil
 }
 
 // DeleteUserHandler handles HTTP requests to delete a user.
@@ -1575,6 +2080,10 @@ func DeleteUserHandler(cfg *config.Config) http.HandlerFunc {
 			http.Error(w, "Invalid method. Use DELETE", http.StatusMethodNotAllowed)
 			return
 		}
+		dryRun, ok := parseDryRun(w, r, cfg)
+		if !ok {
+			return
+		}
 
 		if err := r.ParseForm(); err != nil {
 			cfg.Logger.Error("Failed to parse form data", "error", err)
@@ -1590,15 +2099,28 @@ func DeleteUserHandler(cfg *config.Config) http.HandlerFunc {
 			return
 		}
 		if inboundTag == "" {
-			inboundTag = "vless-in"
-			cfg.Logger.Debug("Setting default inboundTag", "inboundTag", inboundTag)
+			cfg.Logger.Debug("No inboundTag given, deleting user from all inbounds", "user", userIdentifier)
+		}
+
+		if dryRun {
+			diff, err := previewConfigChange(cfg, func(dryCfg *config.Config) error {
+				return DeleteUserFromConfig(userIdentifier, inboundTag, dryCfg)
+			})
+			if err != nil {
+				cfg.Logger.Warn("Dry run of user deletion failed", "user", userIdentifier, "error", err)
+				http.Error(w, err.Error(), configWriteStatus(err, http.StatusBadRequest))
+				return
+			}
+			cfg.Logger.Info("API delete_user: dry run completed", "user", userIdentifier, "inboundTag", inboundTag)
+			writeDryRun(w, cfg, diff)
+			return
 		}
 
 		cfg.Logger.Debug("Deleting user from configuration", "user", userIdentifier, "inboundTag", inboundTag)
 		err := DeleteUserFromConfig(userIdentifier, inboundTag, cfg)
 		if err != nil {
 			cfg.Logger.Error("Failed to delete user", "user", userIdentifier, "error", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), configWriteStatus(err, http.StatusBadRequest))
 			return
 		}
 
@@ -1620,6 +2142,10 @@ func SetEnabledHandler(manager *manager.DatabaseManager, cfg *config.Config) htt
 			http.Error(w, "Invalid method. Use PATCH", http.StatusMethodNotAllowed)
 			return
 		}
+		dryRun, ok := parseDryRun(w, r, cfg)
+		if !ok {
+			return
+		}
 
 		cfg.Logger.Debug("Parsing form data")
 		if err := r.ParseForm(); err != nil {
@@ -1664,8 +2190,36 @@ func SetEnabledHandler(manager *manager.DatabaseManager, cfg *config.Config) htt
 			cfg.Logger.Debug("Enabled value parsed successfully", "enabled", enabled)
 		}
 
+		if dryRun {
+			diff, err := previewConfigChange(cfg, func(dryCfg *config.Config) error {
+				return db.ToggleUserEnabled(manager, dryCfg, userIdentifier, enabled)
+			})
+			if err != nil {
+				cfg.Logger.Warn("Dry run of user status update failed", "user", userIdentifier, "error", err)
+				http.Error(w, err.Error(), configWriteStatus(err, http.StatusBadRequest))
+				return
+			}
+			cfg.Logger.Info("API set_enabled: dry run completed", "user", userIdentifier, "enabled", enabled)
+			writeDryRun(w, cfg, diff)
+			return
+		}
+
 		cfg.Logger.Debug("Updating user status", "user", userIdentifier, "enabled", enabled)
-		err := manager.ExecuteHighPriority(func(db1 *sql.DB) error {
+		// The config files are changed outside the database worker: writing them waits for the
+		// core file lock and the validate command, which would hold up every database request
+		err := db.ToggleUserEnabled(manager, cfg, userIdentifier, enabled)
+		if errors.Is(err, config.ErrCoreConfigRejected) {
+			cfg.Logger.Warn("Core rejected config in SetEnabledHandler", "user", userIdentifier, "error", err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			cfg.Logger.Error("Failed to toggle user status in configuration", "user", userIdentifier, "enabled", enabled, "error", err)
+			http.Error(w, "Error updating status", http.StatusInternalServerError)
+			return
+		}
+
+		err = manager.ExecuteHighPriority(func(db1 *sql.DB) error {
 			cfg.Logger.Debug("Starting transaction for status update")
 			tx, err := db1.Begin()
 			if err != nil {
@@ -1674,13 +2228,8 @@ func SetEnabledHandler(manager *manager.DatabaseManager, cfg *config.Config) htt
 			}
 			defer tx.Rollback()
 
-			if err := db.ToggleUserEnabled(manager, cfg, userIdentifier, enabled); err != nil {
-				cfg.Logger.Error("Failed to toggle user status in configuration", "user", userIdentifier, "enabled", enabled, "error", err)
-				return fmt.Errorf("failed to toggle user status: %v", err)
-			}
-
 			cfg.Logger.Debug("Executing status update query")
-			result, err := tx.Exec("UPDATE clients_stats SET enabled = ? WHERE user = ?", enabledStr, userIdentifier)
+			result, err := tx.Exec("UPDATE clients_stats SET enabled = ?, disabled_reason = '' WHERE user = ?", enabledStr, userIdentifier)
 			if err != nil {
 				cfg.Logger.Error("Failed to update status in database", "user", userIdentifier, "enabled", enabledStr, "error", err)
 				return fmt.Errorf("failed to update status for %s: %v", userIdentifier, err)
@@ -1706,6 +2255,9 @@ func SetEnabledHandler(manager *manager.DatabaseManager, cfg *config.Config) htt
 		})
 		if err != nil {
 			cfg.Logger.Error("Error in SetEnabledHandler", "error", err)
+			if revertErr := db.ToggleUserEnabled(manager, cfg, userIdentifier, !enabled); revertErr != nil {
+				cfg.Logger.Error("Failed to revert user status in configuration", "user", userIdentifier, "enabled", !enabled, "error", revertErr)
+			}
 			http.Error(w, "Error updating status", http.StatusInternalServerError)
 			return
 		}
@@ -1751,7 +2303,7 @@ func updateSubscriptionDate(manager *manager.DatabaseManager, cfg *config.Config
 
 	if subEndStr != "" {
 		cfg.Logger.Debug("Parsing current subscription date", "subEndStr", subEndStr)
-		baseDate, err = time.Parse("2006-01-02-15", subEndStr)
+		baseDate, err = db.ParseSubEnd(subEndStr)
 		if err != nil {
 			cfg.Logger.Error("Failed to parse current subscription date", "subEndStr", subEndStr, "error", err)
 			return fmt.Errorf("failed to parse current subscription date: %v", err)
@@ -3,87 +3,91 @@ package api
 import (
 	"bufio"
 	"crypto/rand"
-	"encoding/json"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"strings"
 
 	"v2ray-stat/config"
+	"v2ray-stat/db"
+	"v2ray-stat/db/manager"
+	"v2ray-stat/util"
 
 	"github.com/google/uuid"
 )
 
-// generateRandomPassword generates a random 30-character password for the trojan protocol using A-Za-z0-9.
-func generateRandomPassword(cfg *config.Config) (string, error) {
-	cfg.Logger.Debug("Generating random password for trojan protocol")
+// maxCredentialLength limits the length of a user credential (UUID, password, tuic uuid:password or SS2022 key).
+const maxCredentialLength = 80
+
+// randomString generates a random string of the given length using A-Za-z0-9.
+func randomString(length int) (string, error) {
 	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
-	const length = 30
 	bytes := make([]byte, length)
 	if _, err := rand.Read(bytes); err != nil {
-		cfg.Logger.Error("Failed to generate random password", "error", err)
-		return "", fmt.Errorf("failed to generate random password: %v", err)
+		return "", err
 	}
 	for i, b := range bytes {
 		bytes[i] = charset[b%byte(len(charset))]
 	}
-	password := string(bytes)
-	cfg.Logger.Trace("Generated password", "password_length", len(password))
-	return password, nil
+	return string(bytes), nil
 }
 
-// getProtocolByInboundTag determines the protocol (vless or trojan) by inboundTag.
-func getProtocolByInboundTag(inboundTag string, cfg *config.Config) (string, error) {
-	if inboundTag == "" {
-		cfg.Logger.Warn("Empty inboundTag")
-		return "", fmt.Errorf("inboundTag is empty")
-	}
+// generateRandomPassword generates a credential suitable for the inbound protocol:
+// a UUID for vless and vmess, uuid:password for tuic, a base64 key of the method's size
+// for Shadowsocks 2022 and a random 30-character password for trojan, hysteria2 and other Shadowsocks methods.
+func generateRandomPassword(protocol, method string, cfg *config.Config) (string, error) {
+	cfg.Logger.Debug("Generating random credential", "protocol", protocol, "method", method)
+	switch protocol {
+	case "vless", "vmess":
+		return uuid.New().String(), nil
 
-	cfg.Logger.Debug("Reading config file to determine protocol", "inboundTag", inboundTag)
-	configPath := cfg.Core.Config
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		cfg.Logger.Error("Failed to read config file", "path", configPath, "error", err)
-		return "", fmt.Errorf("failed to read config.json: %v", err)
-	}
+	case "tuic":
+		password, err := randomString(30)
+		if err != nil {
+			cfg.Logger.Error("Failed to generate random password", "error", err)
+			return "", fmt.Errorf("failed to generate random password: %v", err)
+		}
+		return uuid.New().String() + ":" + password, nil
 
-	switch cfg.V2rayStat.Type {
-	case "xray":
-		var cfgXray config.ConfigXray
-		if err := json.Unmarshal(data, &cfgXray); err != nil {
-			cfg.Logger.Error("Failed to parse JSON for Xray", "error", err)
-			return "", fmt.Errorf("failed to parse JSON: %v", err)
-		}
-		for _, inbound := range cfgXray.Inbounds {
-			if inbound.Tag == inboundTag {
-				cfg.Logger.Trace("Found protocol for inboundTag", "inboundTag", inboundTag, "protocol", inbound.Protocol)
-				return inbound.Protocol, nil
+	case "shadowsocks":
+		if config.IsShadowsocks2022(method) {
+			key := make([]byte, config.Shadowsocks2022KeySize(method))
+			if _, err := rand.Read(key); err != nil {
+				cfg.Logger.Error("Failed to generate random key", "error", err)
+				return "", fmt.Errorf("failed to generate random key: %v", err)
 			}
+			return base64.StdEncoding.EncodeToString(key), nil
 		}
-	case "singbox":
-		var cfgSingBox config.ConfigSingbox
-		if err := json.Unmarshal(data, &cfgSingBox); err != nil {
-			cfg.Logger.Error("Failed to parse JSON for Singbox", "error", err)
-			return "", fmt.Errorf("failed to parse JSON: %v", err)
-		}
-		for _, inbound := range cfgSingBox.Inbounds {
-			if inbound.Tag == inboundTag {
-				cfg.Logger.Trace("Found protocol for inboundTag", "inboundTag", inboundTag, "protocol", inbound.Type)
-				return inbound.Type, nil
-			}
+		fallthrough
+
+	case "trojan", "hysteria2":
+		password, err := randomString(30)
+		if err != nil {
+			cfg.Logger.Error("Failed to generate random password", "error", err)
+			return "", fmt.Errorf("failed to generate random password: %v", err)
 		}
+		cfg.Logger.Trace("Generated password", "password_length", len(password))
+		return password, nil
 	}
-	cfg.Logger.Warn("inboundTag not found in configuration", "inboundTag", inboundTag)
-	return "", fmt.Errorf("inbound with tag %s not found", inboundTag)
+	return "", fmt.Errorf("unsupported protocol: %s", protocol)
 }
 
-// AddUsersFromFile adds users from a file with format: user,credential[,inboundTag].
-func AddUsersFromFile(file io.Reader, cfg *config.Config) error {
+// AddUsersFromFile adds users from a file with format:
+// user,credential[,inboundTags[,traffic_limit[,contact_telegram[,contact_email[,tags]]]]] [notes]
+// where inbound tags (or "all") and tags are separated by semicolons and the text after the first
+// space (without a leading #) is stored as notes. With a nil manager, as in a dry run, only the
+// configuration files are changed and the attributes stored in the database are skipped.
+func AddUsersFromFile(manager *manager.DatabaseManager, file io.Reader, cfg *config.Config) error {
 	cfg.Logger.Debug("Starting processing of users file")
 	scanner := bufio.NewScanner(file)
 	lineNumber := 0
-	successCount := 0
+
+	// Users are added to the configuration after the file is read, so that it is written and
+	// validated once
+	var users []InboundUser
+	lineNumbers := make(map[string]int)
+	updates := make(map[string]db.UserUpdate)
 
 	for scanner.Scan() {
 		lineNumber++
@@ -93,11 +97,13 @@ func AddUsersFromFile(file io.Reader, cfg *config.Config) error {
 			continue
 		}
 
-		// Split line by first space, ignoring comments
+		// Split line by first space, the rest of the line is stored as notes
 		parts := strings.SplitN(line, " ", 2)
 		data := strings.TrimSpace(parts[0])
+		notes := ""
 		if len(parts) > 1 {
-			cfg.Logger.Trace("Found comment in line", "line_number", lineNumber, "comment", strings.TrimSpace(parts[1]))
+			notes = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(parts[1]), "#"))
+			cfg.Logger.Trace("Found notes in line", "line_number", lineNumber, "notes", notes)
 		}
 
 		if data == "" {
@@ -126,65 +132,116 @@ func AddUsersFromFile(file io.Reader, cfg *config.Config) error {
 		credential := ""
 		if len(fields) > 1 && fields[1] != "" {
 			credential = fields[1]
-			if len(credential) > 40 {
+			if len(credential) > maxCredentialLength {
 				cfg.Logger.Warn("Credential too long", "line_number", lineNumber, "user", user, "credential_length", len(credential))
 				continue
 			}
 		}
 
-		inboundTag := "vless-in" // Default value
+		inboundTags := []string{"vless-in"} // Default value
 		if len(fields) > 2 && fields[2] != "" {
-			inboundTag = fields[2]
+			inboundTags = strings.Split(fields[2], ";")
 		}
 
-		cfg.Logger.Trace("Processing line", "line_number", lineNumber, "user", user, "credential", credential, "inboundTag", inboundTag)
-
-		// Determine protocol by inboundTag
-		protocol, err := getProtocolByInboundTag(inboundTag, cfg)
-		if err != nil {
-			cfg.Logger.Error("Failed to determine protocol", "line_number", lineNumber, "inboundTag", inboundTag, "error", err)
-			continue
+		var trafficLimit int64
+		if len(fields) > 3 && fields[3] != "" {
+			var err error
+			trafficLimit, err = util.ParseDataSize(fields[3])
+			if err != nil {
+				cfg.Logger.Warn("Invalid traffic limit", "line_number", lineNumber, "user", user, "traffic_limit", fields[3], "error", err)
+				continue
+			}
 		}
 
-		// Generate credential based on protocol
-		if credential == "" {
-			if protocol == "vless" {
-				credential = uuid.New().String()
-				cfg.Logger.Debug("Generated UUID for vless", "user", user, "credential", credential)
-			} else if protocol == "trojan" {
-				credential, err = generateRandomPassword(cfg)
-				if err != nil {
-					cfg.Logger.Error("Failed to generate password", "line_number", lineNumber, "user", user, "error", err)
-					continue
-				}
-				cfg.Logger.Debug("Generated password for trojan", "user", user)
-			} else {
-				cfg.Logger.Warn("Unsupported protocol", "line_number", lineNumber, "protocol", protocol, "inboundTag", inboundTag)
+		var update db.UserUpdate
+		if trafficLimit > 0 {
+			update.TrafficLimit = &trafficLimit
+		}
+		if notes != "" {
+			if err := db.ValidateNotes(notes); err != nil {
+				cfg.Logger.Warn("Invalid notes", "line_number", lineNumber, "user", user, "error", err)
+				continue
+			}
+			update.Notes = &notes
+		}
+		if len(fields) > 4 && fields[4] != "" {
+			if err := db.ValidateContactTelegram(fields[4]); err != nil {
+				cfg.Logger.Warn("Invalid Telegram contact", "line_number", lineNumber, "user", user, "error", err)
+				continue
+			}
+			update.ContactTelegram = &fields[4]
+		}
+		if len(fields) > 5 && fields[5] != "" {
+			if err := db.ValidateContactEmail(fields[5]); err != nil {
+				cfg.Logger.Warn("Invalid email contact", "line_number", lineNumber, "user", user, "error", err)
 				continue
 			}
+			update.ContactEmail = &fields[5]
+		}
+		if len(fields) > 6 && fields[6] != "" {
+			tags, err := db.NormalizeTags(strings.Split(fields[6], ";"))
+			if err != nil {
+				cfg.Logger.Warn("Invalid tags", "line_number", lineNumber, "user", user, "error", err)
+				continue
+			}
+			update.Tags = tags
 		}
 
-		cfg.Logger.Debug("Adding user to configuration", "user", user, "inboundTag", inboundTag)
-		if err := AddUserToConfig(user, credential, inboundTag, cfg); err != nil {
-			cfg.Logger.Error("Failed to add user", "line_number", lineNumber, "user", user, "error", err)
+		if first, exists := lineNumbers[user]; exists {
+			cfg.Logger.Warn("User listed more than once, skipping", "line_number", lineNumber, "user", user, "first_line_number", first)
 			continue
 		}
 
-		successCount++
-		cfg.Logger.Trace("User added successfully", "line_number", lineNumber, "user", user)
+		cfg.Logger.Trace("Processing line", "line_number", lineNumber, "user", user, "credential", credential, "inboundTags", strings.Join(inboundTags, ","), "traffic_limit", trafficLimit)
+		users = append(users, InboundUser{User: user, Credential: credential, InboundTags: inboundTags})
+		lineNumbers[user] = lineNumber
+		updates[user] = update
 	}
 
 	if err := scanner.Err(); err != nil {
 		cfg.Logger.Error("Failed to read file", "error", err)
 		return fmt.Errorf("failed to read file: %v", err)
 	}
+	if len(users) == 0 {
+		cfg.Logger.Info("File processing completed", "success_count", 0, "total_lines", lineNumber)
+		return nil
+	}
+
+	// A missing credential is generated based on the protocol of each inbound
+	cfg.Logger.Debug("Adding users to configuration", "count", len(users))
+	added, failed, err := AddUsersToInbounds(users, cfg)
+	if err != nil {
+		cfg.Logger.Error("Failed to add users", "count", len(users), "error", err)
+		return err
+	}
+
+	successCount := 0
+	for _, u := range users {
+		if err := failed[u.User]; err != nil {
+			cfg.Logger.Error("Failed to add user", "line_number", lineNumbers[u.User], "user", u.User, "error", err)
+			continue
+		}
+
+		update := updates[u.User]
+		hasAttributes := update.TrafficLimit != nil || update.Notes != nil || update.ContactTelegram != nil || update.ContactEmail != nil || update.Tags != nil
+		if manager != nil && hasAttributes {
+			if err := db.EnsureUserInDB(manager, cfg, u.User, added[u.User][0].DBCredential(u.User, cfg)); err != nil {
+				cfg.Logger.Error("Failed to add user to database", "line_number", lineNumbers[u.User], "user", u.User, "error", err)
+			} else if err := db.UpdateUser(manager, cfg, u.User, update); err != nil {
+				cfg.Logger.Error("Failed to set user attributes", "line_number", lineNumbers[u.User], "user", u.User, "error", err)
+			}
+		}
+
+		successCount++
+		cfg.Logger.Trace("User added successfully", "line_number", lineNumbers[u.User], "user", u.User)
+	}
 
 	cfg.Logger.Info("File processing completed", "success_count", successCount, "total_lines", lineNumber)
 	return nil
 }
 
 // BulkAddUsersHandler handles POST requests with a users file.
-func BulkAddUsersHandler(cfg *config.Config) http.HandlerFunc {
+func BulkAddUsersHandler(manager *manager.DatabaseManager, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		cfg.Logger.Debug("Starting BulkAddUsersHandler request processing")
 
@@ -193,6 +250,10 @@ func BulkAddUsersHandler(cfg *config.Config) http.HandlerFunc {
 			http.Error(w, "Method not allowed, use POST", http.StatusMethodNotAllowed)
 			return
 		}
+		dryRun, ok := parseDryRun(w, r, cfg)
+		if !ok {
+			return
+		}
 
 		// Retrieve file from request
 		file, _, err := r.FormFile("users_file")
@@ -203,11 +264,25 @@ func BulkAddUsersHandler(cfg *config.Config) http.HandlerFunc {
 		}
 		defer file.Close()
 
+		if dryRun {
+			diff, err := previewConfigChange(cfg, func(dryCfg *config.Config) error {
+				return AddUsersFromFile(nil, file, dryCfg)
+			})
+			if err != nil {
+				cfg.Logger.Warn("Dry run of users file failed", "error", err)
+				http.Error(w, fmt.Sprintf("Failed to process file: %v", err), configWriteStatus(err, http.StatusInternalServerError))
+				return
+			}
+			cfg.Logger.Info("API bulk_add_users: dry run completed")
+			writeDryRun(w, cfg, diff)
+			return
+		}
+
 		// Process file
 		cfg.Logger.Debug("Processing users file")
-		if err := AddUsersFromFile(file, cfg); err != nil {
+		if err := AddUsersFromFile(manager, file, cfg); err != nil {
 			cfg.Logger.Error("Failed to process file", "error", err)
-			http.Error(w, fmt.Sprintf("Failed to process file: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Failed to process file: %v", err), configWriteStatus(err, http.StatusInternalServerError))
 			return
 		}
 
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"v2ray-stat/backup"
+	"v2ray-stat/config"
+	"v2ray-stat/coreapi"
+)
+
+// ConfigBackupMiddleware saves a version of the core config files around requests that may
+// change them. A version taken before the request records changes made outside the API; the
+// one taken after it is labeled with the request method, path and user.
+func ConfigBackupMiddleware(cfg *config.Config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		backup.Snapshot(cfg, "external change")
+		next.ServeHTTP(w, r)
+
+		reason := r.Method + " " + r.URL.Path
+		if user := r.Form.Get("user"); user != "" {
+			reason += " user=" + user
+		}
+		backup.Snapshot(cfg, reason)
+	}
+}
+
+// ConfigVersionsHandler returns the saved versions of the core config files, newest first.
+func ConfigVersionsHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg.Logger.Debug("Starting ConfigVersionsHandler request processing")
+
+		if r.Method != http.MethodGet {
+			cfg.Logger.Warn("Invalid HTTP method", "method", r.Method)
+			http.Error(w, "Method not allowed, use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		if cfg.Backup.Dir == "" {
+			cfg.Logger.Warn("Config backups are disabled")
+			http.Error(w, "Config backups are disabled", http.StatusNotFound)
+			return
+		}
+
+		versions, err := backup.List(cfg)
+		if err != nil {
+			cfg.Logger.Error("Failed to list config versions", "error", err)
+			http.Error(w, "Error processing data", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, cfg, http.StatusOK, versions)
+	}
+}
+
+// ConfigVersionsDiffHandler returns a unified diff between two versions of the core config
+// files; to=current, the default, compares with the files in use.
+func ConfigVersionsDiffHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg.Logger.Debug("Starting ConfigVersionsDiffHandler request processing")
+
+		if r.Method != http.MethodGet {
+			cfg.Logger.Warn("Invalid HTTP method", "method", r.Method)
+			http.Error(w, "Method not allowed, use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		if cfg.Backup.Dir == "" {
+			cfg.Logger.Warn("Config backups are disabled")
+			http.Error(w, "Config backups are disabled", http.StatusNotFound)
+			return
+		}
+
+		fromID := strings.TrimSpace(r.URL.Query().Get("from"))
+		toID := strings.TrimSpace(r.URL.Query().Get("to"))
+		if toID == "" {
+			toID = "current"
+		}
+		if fromID == "" {
+			cfg.Logger.Warn("Missing from parameter")
+			http.Error(w, "from is required", http.StatusBadRequest)
+			return
+		}
+
+		var files [2]map[string][]byte
+		for i, id := range []string{fromID, toID} {
+			var err error
+			files[i], err = backup.Files(cfg, id)
+			if errors.Is(err, backup.ErrVersionNotFound) {
+				cfg.Logger.Warn("Config version not found", "version", id)
+				http.Error(w, "Version "+id+" not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				cfg.Logger.Error("Failed to read config version", "version", id, "error", err)
+				http.Error(w, "Error processing data", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(backup.Diff(fromID, files[0], toID, files[1])))
+		cfg.Logger.Debug("Config versions compared", "from", fromID, "to", toID)
+	}
+}
+
+// ConfigRollbackHandler restores the core config files of a saved version and, with live
+// updates enabled, brings the users of the running core in line with the restored config.
+func ConfigRollbackHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg.Logger.Debug("Starting ConfigRollbackHandler request processing")
+
+		if r.Method != http.MethodPost {
+			cfg.Logger.Warn("Invalid HTTP method", "method", r.Method)
+			http.Error(w, "Method not allowed, use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if cfg.Backup.Dir == "" {
+			cfg.Logger.Warn("Config backups are disabled")
+			http.Error(w, "Config backups are disabled", http.StatusNotFound)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			cfg.Logger.Error("Failed to parse form data", "error", err)
+			http.Error(w, "Error parsing form data", http.StatusBadRequest)
+			return
+		}
+		version := strings.TrimSpace(r.FormValue("version"))
+		if version == "" {
+			cfg.Logger.Warn("Missing version parameter")
+			http.Error(w, "version is required", http.StatusBadRequest)
+			return
+		}
+
+		err := backup.Restore(cfg, version)
+		if errors.Is(err, backup.ErrVersionNotFound) {
+			cfg.Logger.Warn("Config version not found", "version", version)
+			http.Error(w, "Version "+version+" not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, config.ErrCoreConfigRejected) {
+			cfg.Logger.Warn("Core rejected rolled back config", "version", version, "error", err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			cfg.Logger.Error("Failed to roll back config", "version", version, "error", err)
+			http.Error(w, "Error rolling back config", http.StatusInternalServerError)
+			return
+		}
+
+		if coreapi.LiveUpdateEnabled(cfg) {
+			if err := coreapi.ReconcileInboundUsers(cfg); err != nil {
+				cfg.Logger.Warn("Failed to reconcile live users after rollback", "version", version, "error", err)
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		cfg.Logger.Info("API config_versions/rollback: config rolled back successfully", "version", version)
+	}
+}
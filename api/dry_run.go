@@ -0,0 +1,116 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"v2ray-stat/backup"
+	"v2ray-stat/config"
+)
+
+// parseDryRun reads the dry_run query parameter, writing a 400 response if it is invalid.
+func parseDryRun(w http.ResponseWriter, r *http.Request, cfg *config.Config) (dryRun, ok bool) {
+	value := r.URL.Query().Get("dry_run")
+	if value == "" {
+		return false, true
+	}
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		cfg.Logger.Warn("Invalid dry_run value", "dry_run", value)
+		http.Error(w, "dry_run must be true or false", http.StatusBadRequest)
+		return false, false
+	}
+	return dryRun, true
+}
+
+// dryRunFile is a file compared by a dry run and its copy.
+type dryRunFile struct {
+	path string
+	copy string
+	data []byte // nil if the file does not exist
+}
+
+// previewConfigChange runs change against copies of config.json, .disabled_users and auth.lua
+// in a temporary directory and returns a unified diff of what it would change in them. change
+// gets a copy of cfg pointing to the copies, with live updates of the core, config validation,
+// core reloads and backups disabled, and must not change the database.
+func previewConfigChange(cfg *config.Config, change func(dryCfg *config.Config) error) (string, error) {
+	dir, err := os.MkdirTemp("", "v2ray-stat-dry-run-")
+	if err != nil {
+		cfg.Logger.Error("Failed to create dry run directory", "error", err)
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	dryCfg := *cfg
+	dryCfg.Core.Dir = dir
+	dryCfg.Core.Config = filepath.Join(dir, filepath.Base(cfg.Core.Config))
+	dryCfg.Core.ValidateCommand = ""
+	dryCfg.Core.ReloadCommand = ""
+	dryCfg.Core.API.LiveUpdate = false
+	dryCfg.Paths.AuthLua = filepath.Join(dir, "auth.lua")
+	dryCfg.Backup.Dir = ""
+
+	files := []*dryRunFile{
+		{path: cfg.Core.Config, copy: dryCfg.Core.Config},
+		{path: filepath.Join(cfg.Core.Dir, ".disabled_users"), copy: filepath.Join(dir, ".disabled_users")},
+	}
+	if cfg.Features["auth_lua"] {
+		files = append(files, &dryRunFile{path: cfg.Paths.AuthLua, copy: dryCfg.Paths.AuthLua})
+	}
+
+	unlock, err := config.LockCoreFiles(cfg)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			err = os.WriteFile(file.copy, data, 0600)
+		}
+		if err != nil {
+			unlock()
+			cfg.Logger.Error("Failed to copy file for dry run", "path", file.path, "error", err)
+			return "", err
+		}
+		file.data = data
+	}
+	unlock()
+
+	if err := change(&dryCfg); err != nil {
+		return "", err
+	}
+
+	var diff strings.Builder
+	for _, file := range files {
+		data, err := os.ReadFile(file.copy)
+		if err != nil && !os.IsNotExist(err) {
+			cfg.Logger.Error("Failed to read dry run result", "path", file.copy, "error", err)
+			return "", err
+		}
+		fromName, toName := "a"+file.path, "b"+file.path
+		if file.data == nil {
+			fromName = "/dev/null"
+		}
+		if err != nil {
+			toName = "/dev/null"
+		}
+		diff.WriteString(backup.UnifiedDiff(fromName, toName, file.data, data))
+	}
+	return diff.String(), nil
+}
+
+// writeDryRun writes the diff of a dry run as a text response.
+func writeDryRun(w http.ResponseWriter, cfg *config.Config, diff string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(diff)); err != nil {
+		cfg.Logger.Error("Failed to write dry run response", "error", err)
+	}
+}
@@ -1,54 +1,55 @@
 package api
 
 import (
-	"context"
 	"fmt"
-	"strconv"
-	"time"
 
 	"v2ray-stat/config"
+	"v2ray-stat/coreapi"
 
 	statsSingbox "github.com/v2ray/v2ray-core/app/stats/command"
 	statsXray "github.com/xtls/xray-core/app/stats/command"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 // Stat represents a single statistic entry.
 type Stat struct {
 	Name  string
-	Value string
+	Value int64
 }
 
 // ApiResponse contains the collected statistics.
 type ApiResponse struct {
 	Stat []Stat
+	// Uptime of the core in seconds, queried in reset mode for Xray only; 0 if unknown.
+	Uptime uint32
 }
 
 // GetApiResponse retrieves statistics from the gRPC server for Xray or Singbox.
+// With core.api.reset_on_read enabled, counters are reset by the core and the values are deltas since the previous call.
 func GetApiResponse(cfg *config.Config) (*ApiResponse, error) {
-	cfg.Logger.Debug("Connecting to gRPC server", "address", "127.0.0.1:9953")
-	clientConn, err := grpc.NewClient("127.0.0.1:9953", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	clientConn, err := coreapi.Conn(cfg)
 	if err != nil {
 		cfg.Logger.Error("Failed to connect to gRPC server", "error", err)
 		return nil, fmt.Errorf("failed to connect to gRPC server: %w", err)
 	}
-	defer clientConn.Close()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := coreapi.Context(cfg)
 	defer cancel()
 
+	reset := cfg.Core.API.ResetOnRead
 	var stats []Stat
+	var uptime uint32
 
 	switch cfg.V2rayStat.Type {
 	case "xray":
-		cfg.Logger.Debug("Executing gRPC request for Xray")
+		cfg.Logger.Debug("Executing gRPC request for Xray", "reset", reset)
 		client := statsXray.NewStatsServiceClient(clientConn)
 		req := &statsXray.QueryStatsRequest{
 			Pattern: "",
+			Reset_:  reset,
 		}
 		xrayResp, err := client.QueryStats(ctx, req)
 		if err != nil {
+			coreapi.Fail(cfg, err)
 			cfg.Logger.Error("Failed to execute gRPC request for Xray", "error", err)
 			return nil, fmt.Errorf("failed to execute gRPC request for Xray: %w", err)
 		}
@@ -57,19 +58,31 @@ func GetApiResponse(cfg *config.Config) (*ApiResponse, error) {
 			cfg.Logger.Trace("Processing Xray stat", "name", s.GetName(), "value", s.GetValue())
 			stats = append(stats, Stat{
 				Name:  s.GetName(),
-				Value: strconv.FormatInt(s.GetValue(), 10),
+				Value: s.GetValue(),
 			})
 		}
 		cfg.Logger.Trace("Retrieved Xray stats", "count", len(xrayResp.GetStat()))
 
+		// Reset counters always start from zero, so a restart can only be seen in the uptime
+		if reset {
+			sysResp, err := client.GetSysStats(ctx, &statsXray.SysStatsRequest{})
+			if err != nil {
+				cfg.Logger.Warn("Failed to query Xray system stats", "error", err)
+			} else {
+				uptime = sysResp.GetUptime()
+			}
+		}
+
 	case "singbox":
-		cfg.Logger.Debug("Executing gRPC request for Singbox")
+		cfg.Logger.Debug("Executing gRPC request for Singbox", "reset", reset)
 		client := statsSingbox.NewStatsServiceClient(clientConn)
 		req := &statsSingbox.QueryStatsRequest{
 			Pattern: "",
+			Reset_:  reset,
 		}
 		singboxResp, err := client.QueryStats(ctx, req)
 		if err != nil {
+			coreapi.Fail(cfg, err)
 			cfg.Logger.Error("Failed to execute gRPC request for Singbox", "error", err)
 			return nil, fmt.Errorf("failed to execute gRPC request for Singbox: %w", err)
 		}
@@ -77,11 +90,12 @@ func GetApiResponse(cfg *config.Config) (*ApiResponse, error) {
 			cfg.Logger.Trace("Processing Singbox stat", "name", s.GetName(), "value", s.GetValue())
 			stats = append(stats, Stat{
 				Name:  s.GetName(),
-				Value: strconv.FormatInt(s.GetValue(), 10),
+				Value: s.GetValue(),
 			})
 		}
 		cfg.Logger.Debug("Retrieved Singbox stats", "count", len(singboxResp.GetStat()))
 	}
 
-	return &ApiResponse{Stat: stats}, nil
+	coreapi.Succeed()
+	return &ApiResponse{Stat: stats, Uptime: uptime}, nil
 }
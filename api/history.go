@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db"
+	"v2ray-stat/db/manager"
+)
+
+const maxHistoryPoints = 10000
+
+// HistoryResponse is the JSON response of the history endpoint.
+type HistoryResponse struct {
+	Kind     string            `json:"kind"`
+	Name     string            `json:"name"`
+	From     string            `json:"from"`
+	To       string            `json:"to"`
+	Step     int64             `json:"step"`
+	Uplink   int64             `json:"uplink"`
+	Downlink int64             `json:"downlink"`
+	Points   []db.HistoryPoint `json:"points"`
+}
+
+// parseHistoryTime parses a unix timestamp, RFC3339 time, "2006-01-02 15:04" or "2006-01-02" date.
+func parseHistoryTime(value string, loc *time.Location) (time.Time, error) {
+	if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported time format: %s", value)
+}
+
+// parseHistoryStep parses a step like "300", "5m", "1h" or "1d" into seconds.
+func parseHistoryStep(value string) (int64, error) {
+	var step int64
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.ParseInt(strings.TrimSuffix(value, "d"), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		step = days * db.HistoryResolution1d
+	} else if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		step = seconds
+	} else {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, err
+		}
+		step = int64(d / time.Second)
+	}
+	if step < db.HistoryResolution5m || step%db.HistoryResolution5m != 0 {
+		return 0, fmt.Errorf("step must be a multiple of 5m")
+	}
+	return step, nil
+}
+
+// HistoryHandler returns bucketed traffic history of a user or source in JSON format.
+func HistoryHandler(manager *manager.DatabaseManager, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg.Logger.Debug("Starting HistoryHandler request processing")
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if r.Method != http.MethodGet {
+			cfg.Logger.Warn("Invalid HTTP method", "method", r.Method)
+			http.Error(w, "Invalid method. Use GET", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !cfg.Features["history"] {
+			cfg.Logger.Warn("History feature is disabled")
+			http.Error(w, "History feature is disabled", http.StatusNotFound)
+			return
+		}
+
+		query := r.URL.Query()
+		user := query.Get("user")
+		source := query.Get("source")
+		if (user == "") == (source == "") {
+			cfg.Logger.Warn("Exactly one of user or source must be specified", "user", user, "source", source)
+			http.Error(w, "Exactly one of user or source must be specified", http.StatusBadRequest)
+			return
+		}
+		kind, name := db.HistoryKindUser, user
+		if source != "" {
+			kind, name = db.HistoryKindSource, source
+		}
+
+		loc := db.HistoryLocation(cfg)
+		to := time.Now()
+		if value := query.Get("to"); value != "" {
+			t, err := parseHistoryTime(value, loc)
+			if err != nil {
+				cfg.Logger.Warn("Invalid to value", "to", value, "error", err)
+				http.Error(w, "Invalid to value", http.StatusBadRequest)
+				return
+			}
+			to = t
+		}
+		from := to.Add(-24 * time.Hour)
+		if value := query.Get("from"); value != "" {
+			t, err := parseHistoryTime(value, loc)
+			if err != nil {
+				cfg.Logger.Warn("Invalid from value", "from", value, "error", err)
+				http.Error(w, "Invalid from value", http.StatusBadRequest)
+				return
+			}
+			from = t
+		}
+		if !from.Before(to) {
+			cfg.Logger.Warn("Invalid time range", "from", from, "to", to)
+			http.Error(w, "from must be before to", http.StatusBadRequest)
+			return
+		}
+
+		step := int64(db.HistoryResolution1h)
+		if value := query.Get("step"); value != "" {
+			s, err := parseHistoryStep(value)
+			if err != nil {
+				cfg.Logger.Warn("Invalid step value", "step", value, "error", err)
+				http.Error(w, "Invalid step value, must be a multiple of 5m (e.g. 5m, 1h, 1d)", http.StatusBadRequest)
+				return
+			}
+			step = s
+		}
+		if (to.Unix()-from.Unix())/step > maxHistoryPoints {
+			cfg.Logger.Warn("Too many history points requested", "from", from, "to", to, "step", step)
+			http.Error(w, fmt.Sprintf("Too many points, increase step (max %d)", maxHistoryPoints), http.StatusBadRequest)
+			return
+		}
+
+		points, err := db.GetTrafficHistory(manager, cfg, kind, name, from, to, step)
+		if err != nil {
+			http.Error(w, "Error processing data", http.StatusInternalServerError)
+			return
+		}
+
+		resp := HistoryResponse{
+			Kind:   kind,
+			Name:   name,
+			From:   from.In(loc).Format(time.RFC3339),
+			To:     to.In(loc).Format(time.RFC3339),
+			Step:   step,
+			Points: points,
+		}
+		for _, p := range points {
+			resp.Uplink += p.Uplink
+			resp.Downlink += p.Downlink
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			cfg.Logger.Error("Failed to encode JSON", "error", err)
+			http.Error(w, "Error forming response", http.StatusInternalServerError)
+			return
+		}
+
+		cfg.Logger.Info("API history: completed successfully", "kind", kind, "name", name, "points", len(points))
+	}
+}
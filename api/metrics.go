@@ -0,0 +1,231 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db"
+	"v2ray-stat/db/manager"
+	"v2ray-stat/stats"
+)
+
+// metricsWriter builds a response in the Prometheus text exposition format.
+type metricsWriter struct {
+	builder strings.Builder
+}
+
+// header writes the HELP and TYPE lines of a metric family.
+func (m *metricsWriter) header(name, help, metricType string) {
+	fmt.Fprintf(&m.builder, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// sample writes a single sample with optional label pairs (name, value, name, value, ...).
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	m.builder.WriteString(name)
+	if len(labels) > 0 {
+		m.builder.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.builder.WriteByte(',')
+			}
+			fmt.Fprintf(&m.builder, "%s=\"%s\"", labels[i], escapeLabelValue(labels[i+1]))
+		}
+		m.builder.WriteByte('}')
+	}
+	m.builder.WriteByte(' ')
+	m.builder.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	m.builder.WriteByte('\n')
+}
+
+// escapeLabelValue escapes a label value as required by the text format.
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// userMetrics holds the clients_stats values exported as metrics.
+type userMetrics struct {
+	user     string
+	uplink   int64
+	downlink int64
+	rate     int64
+	enabled  string
+	limIP    int
+	subEnd   string
+}
+
+// sourceMetrics holds the traffic_stats values exported as metrics.
+type sourceMetrics struct {
+	source   string
+	uplink   int64
+	downlink int64
+	rate     int64
+}
+
+// writeUserMetrics writes per-user metrics from clients_stats.
+func writeUserMetrics(m *metricsWriter, users []userMetrics) {
+	m.header("v2ray_stat_user_uplink_bytes_total", "Total uploaded traffic of the user in bytes.", "counter")
+	for _, u := range users {
+		m.sample("v2ray_stat_user_uplink_bytes_total", float64(u.uplink), "user", u.user)
+	}
+	m.header("v2ray_stat_user_downlink_bytes_total", "Total downloaded traffic of the user in bytes.", "counter")
+	for _, u := range users {
+		m.sample("v2ray_stat_user_downlink_bytes_total", float64(u.downlink), "user", u.user)
+	}
+	m.header("v2ray_stat_user_rate_bits_per_second", "Current traffic rate of the user in bits per second.", "gauge")
+	for _, u := range users {
+		m.sample("v2ray_stat_user_rate_bits_per_second", float64(u.rate), "user", u.user)
+	}
+	m.header("v2ray_stat_user_enabled", "Whether the user is enabled (1) or disabled (0).", "gauge")
+	for _, u := range users {
+		enabled := 0.0
+		if u.enabled == "true" {
+			enabled = 1
+		}
+		m.sample("v2ray_stat_user_enabled", enabled, "user", u.user)
+	}
+	m.header("v2ray_stat_user_ip_limit", "Maximum number of IP addresses allowed for the user, 0 means unlimited.", "gauge")
+	for _, u := range users {
+		m.sample("v2ray_stat_user_ip_limit", float64(u.limIP), "user", u.user)
+	}
+	m.header("v2ray_stat_user_subscription_remaining_seconds", "Seconds until the subscription of the user ends, negative if expired.", "gauge")
+	now := time.Now()
+	for _, u := range users {
+		if u.subEnd == "" {
+			continue
+		}
+		subEnd, err := db.ParseSubEnd(u.subEnd)
+		if err != nil {
+			continue
+		}
+		m.sample("v2ray_stat_user_subscription_remaining_seconds", subEnd.Sub(now).Seconds(), "user", u.user)
+	}
+}
+
+// writeSourceMetrics writes per-source metrics from traffic_stats.
+func writeSourceMetrics(m *metricsWriter, sources []sourceMetrics) {
+	m.header("v2ray_stat_source_uplink_bytes_total", "Total uploaded traffic of the inbound/outbound in bytes.", "counter")
+	for _, s := range sources {
+		m.sample("v2ray_stat_source_uplink_bytes_total", float64(s.uplink), "source", s.source)
+	}
+	m.header("v2ray_stat_source_downlink_bytes_total", "Total downloaded traffic of the inbound/outbound in bytes.", "counter")
+	for _, s := range sources {
+		m.sample("v2ray_stat_source_downlink_bytes_total", float64(s.downlink), "source", s.source)
+	}
+	m.header("v2ray_stat_source_rate_bits_per_second", "Current traffic rate of the inbound/outbound in bits per second.", "gauge")
+	for _, s := range sources {
+		m.sample("v2ray_stat_source_rate_bits_per_second", float64(s.rate), "source", s.source)
+	}
+}
+
+// writeSystemMetrics writes network, memory, disk and service metrics.
+func writeSystemMetrics(m *metricsWriter, cfg *config.Config) {
+	if trafficMonitor := stats.GetTrafficMonitor(); trafficMonitor != nil {
+		rxSpeed, txSpeed, rxPacketsPerSec, txPacketsPerSec, totalRxBytes, totalTxBytes := trafficMonitor.GetStats()
+		iface := trafficMonitor.Iface
+
+		m.header("v2ray_stat_network_receive_bits_per_second", "Receive speed of the network interface in bits per second.", "gauge")
+		m.sample("v2ray_stat_network_receive_bits_per_second", rxSpeed, "interface", iface)
+		m.header("v2ray_stat_network_transmit_bits_per_second", "Transmit speed of the network interface in bits per second.", "gauge")
+		m.sample("v2ray_stat_network_transmit_bits_per_second", txSpeed, "interface", iface)
+		m.header("v2ray_stat_network_receive_packets_per_second", "Received packets per second on the network interface.", "gauge")
+		m.sample("v2ray_stat_network_receive_packets_per_second", rxPacketsPerSec, "interface", iface)
+		m.header("v2ray_stat_network_transmit_packets_per_second", "Transmitted packets per second on the network interface.", "gauge")
+		m.sample("v2ray_stat_network_transmit_packets_per_second", txPacketsPerSec, "interface", iface)
+		m.header("v2ray_stat_network_receive_bytes_total", "Bytes received on the network interface since monitoring started or was reset.", "counter")
+		m.sample("v2ray_stat_network_receive_bytes_total", float64(totalRxBytes), "interface", iface)
+		m.header("v2ray_stat_network_transmit_bytes_total", "Bytes transmitted on the network interface since monitoring started or was reset.", "counter")
+		m.sample("v2ray_stat_network_transmit_bytes_total", float64(totalTxBytes), "interface", iface)
+	}
+
+	if used, total, err := stats.GetMemoryBytes(cfg); err == nil {
+		m.header("v2ray_stat_memory_used_bytes", "Used memory in bytes.", "gauge")
+		m.sample("v2ray_stat_memory_used_bytes", float64(used))
+		m.header("v2ray_stat_memory_total_bytes", "Total memory in bytes.", "gauge")
+		m.sample("v2ray_stat_memory_total_bytes", float64(total))
+	}
+
+	if used, total, err := stats.GetDiskBytes(cfg); err == nil {
+		m.header("v2ray_stat_disk_used_bytes", "Used space of the root filesystem in bytes.", "gauge")
+		m.sample("v2ray_stat_disk_used_bytes", float64(used))
+		m.header("v2ray_stat_disk_total_bytes", "Total space of the root filesystem in bytes.", "gauge")
+		m.sample("v2ray_stat_disk_total_bytes", float64(total))
+	}
+
+	m.header("v2ray_stat_service_up", "Whether the monitored service is running (1) or not (0).", "gauge")
+	for _, svc := range cfg.Services {
+		up := 0.0
+		if stats.IsServiceRunning(svc, cfg) {
+			up = 1
+		}
+		m.sample("v2ray_stat_service_up", up, "service", svc)
+	}
+}
+
+// MetricsHandler exposes user, traffic and system metrics in the Prometheus text format.
+func MetricsHandler(manager *manager.DatabaseManager, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg.Logger.Debug("Starting MetricsHandler request processing")
+
+		if r.Method != http.MethodGet {
+			cfg.Logger.Warn("Invalid HTTP method", "method", r.Method)
+			http.Error(w, "Invalid method. Use GET", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var users []userMetrics
+		var sources []sourceMetrics
+		err := manager.ExecuteLowPriority(func(db *sql.DB) error {
+			rows, err := db.Query("SELECT user, uplink, downlink, rate, enabled, lim_ip, sub_end FROM clients_stats ORDER BY user")
+			if err != nil {
+				return fmt.Errorf("failed to query clients_stats: %v", err)
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var u userMetrics
+				var enabled sql.NullString
+				if err := rows.Scan(&u.user, &u.uplink, &u.downlink, &u.rate, &enabled, &u.limIP, &u.subEnd); err != nil {
+					return fmt.Errorf("failed to scan clients_stats row: %v", err)
+				}
+				u.enabled = enabled.String
+				users = append(users, u)
+			}
+			if err := rows.Err(); err != nil {
+				return fmt.Errorf("error iterating clients_stats rows: %v", err)
+			}
+
+			rows, err = db.Query("SELECT source, uplink, downlink, rate FROM traffic_stats ORDER BY source")
+			if err != nil {
+				return fmt.Errorf("failed to query traffic_stats: %v", err)
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var s sourceMetrics
+				if err := rows.Scan(&s.source, &s.uplink, &s.downlink, &s.rate); err != nil {
+					return fmt.Errorf("failed to scan traffic_stats row: %v", err)
+				}
+				sources = append(sources, s)
+			}
+			return rows.Err()
+		})
+		if err != nil {
+			cfg.Logger.Error("Failed to collect metrics", "error", err)
+			http.Error(w, "Error processing data", http.StatusInternalServerError)
+			return
+		}
+
+		var m metricsWriter
+		writeUserMetrics(&m, users)
+		writeSourceMetrics(&m, sources)
+		writeSystemMetrics(&m, cfg)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		fmt.Fprint(w, m.builder.String())
+
+		cfg.Logger.Debug("API metrics: completed successfully", "users", len(users), "sources", len(sources))
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db"
+	"v2ray-stat/db/manager"
+)
+
+// NotificationStateHandler returns stored notification and alert states in JSON format.
+func NotificationStateHandler(manager *manager.DatabaseManager, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg.Logger.Debug("Starting NotificationStateHandler request processing")
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if r.Method != http.MethodGet {
+			cfg.Logger.Warn("Invalid HTTP method", "method", r.Method)
+			http.Error(w, "Invalid method. Use GET", http.StatusMethodNotAllowed)
+			return
+		}
+
+		kind := r.URL.Query().Get("kind")
+		if kind != "" && !db.ValidNotificationKind(kind) {
+			cfg.Logger.Warn("Invalid notification kind", "kind", kind)
+			http.Error(w, "Invalid kind value, must be expired, renewed, service or alert", http.StatusBadRequest)
+			return
+		}
+		key := r.URL.Query().Get("key")
+
+		states, err := db.GetNotificationStates(manager, cfg, kind, key)
+		if err != nil {
+			http.Error(w, "Error processing data", http.StatusInternalServerError)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(states); err != nil {
+			cfg.Logger.Error("Failed to encode JSON", "error", err)
+			http.Error(w, "Error forming response", http.StatusInternalServerError)
+			return
+		}
+
+		cfg.Logger.Info("API notification_state: completed successfully", "states_count", len(states))
+	}
+}
+
+// ClearNotificationStateHandler deletes stored notification and alert states.
+func ClearNotificationStateHandler(manager *manager.DatabaseManager, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg.Logger.Debug("Starting ClearNotificationStateHandler request processing")
+
+		if r.Method != http.MethodPost {
+			cfg.Logger.Warn("Invalid HTTP method", "method", r.Method)
+			http.Error(w, "Invalid method. Use POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cfg.Logger.Debug("Parsing form data")
+		if err := r.ParseForm(); err != nil {
+			cfg.Logger.Error("Failed to parse form data", "error", err)
+			http.Error(w, "Error parsing form data", http.StatusBadRequest)
+			return
+		}
+
+		kind := r.FormValue("kind")
+		key := r.FormValue("key")
+		cfg.Logger.Trace("Received form parameters", "kind", kind, "key", key)
+
+		if kind != "" && !db.ValidNotificationKind(kind) {
+			cfg.Logger.Warn("Invalid notification kind", "kind", kind)
+			http.Error(w, "Invalid kind value, must be expired, renewed, service or alert", http.StatusBadRequest)
+			return
+		}
+
+		deleted, err := db.ClearNotificationState(manager, cfg, kind, key)
+		if err != nil {
+			http.Error(w, "Failed to clear notification state", http.StatusInternalServerError)
+			return
+		}
+
+		cfg.Logger.Info("API clear_notification_state: completed successfully", "kind", kind, "key", key, "deleted", deleted)
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "Notification state cleared, %d entries deleted\n", deleted)
+	}
+}
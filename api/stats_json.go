@@ -0,0 +1,191 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db/manager"
+	"v2ray-stat/stats"
+	"v2ray-stat/util"
+)
+
+// StatsTableJSON holds rows of a statistics section; columns keep the configured order.
+type StatsTableJSON struct {
+	Columns []string         `json:"columns"`
+	Rows    []map[string]any `json:"rows"`
+}
+
+// DataSizeJSON holds a raw byte count together with its human-readable form.
+type DataSizeJSON struct {
+	Bytes uint64 `json:"bytes"`
+	Human string `json:"human"`
+}
+
+// ServerStateJSON holds the server state section in structured form.
+type ServerStateJSON struct {
+	UptimeSeconds float64              `json:"uptime_seconds"`
+	Uptime        string               `json:"uptime"`
+	LoadAverage   []float64            `json:"load_average"`
+	MemoryUsed    DataSizeJSON         `json:"memory_used"`
+	MemoryTotal   DataSizeJSON         `json:"memory_total"`
+	DiskUsed      DataSizeJSON         `json:"disk_used"`
+	DiskTotal     DataSizeJSON         `json:"disk_total"`
+	Services      []stats.ServiceState `json:"services"`
+}
+
+// NetworkJSON holds the network section in structured form.
+type NetworkJSON struct {
+	Interface       string       `json:"interface"`
+	RxSpeed         float64      `json:"rx_speed"`
+	RxSpeedHuman    string       `json:"rx_speed_human"`
+	TxSpeed         float64      `json:"tx_speed"`
+	TxSpeedHuman    string       `json:"tx_speed_human"`
+	RxPacketsPerSec float64      `json:"rx_packets_per_sec"`
+	TxPacketsPerSec float64      `json:"tx_packets_per_sec"`
+	RxTotal         DataSizeJSON `json:"rx_total"`
+	TxTotal         DataSizeJSON `json:"tx_total"`
+}
+
+// StatsJSON is the JSON response of /api/v1/stats and /api/v1/stats/base.
+type StatsJSON struct {
+	Mode        string           `json:"mode,omitempty"`
+	ServerState *ServerStateJSON `json:"server_state,omitempty"`
+	Network     *NetworkJSON     `json:"network,omitempty"`
+	Server      *StatsTableJSON  `json:"server,omitempty"`
+	Clients     *StatsTableJSON  `json:"clients,omitempty"`
+}
+
+// trafficColumns lists columns holding traffic values; rate is in bits per second, others in bytes.
+var trafficColumns = []string{"rate", "uplink", "downlink", "sess_uplink", "sess_downlink"}
+
+// wantsJSON reports whether the client requested JSON via ?format=json or the Accept header.
+func wantsJSON(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "json"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// dataSize returns a raw byte count with its human-readable form.
+func dataSize(bytes uint64) DataSizeJSON {
+	return DataSizeJSON{Bytes: bytes, Human: util.FormatData(float64(bytes), "byte")}
+}
+
+// buildServerStateJSON collects server state statistics in structured form.
+func buildServerStateJSON(cfg *config.Config) *ServerStateJSON {
+	cfg.Logger.Debug("Collecting server state statistics")
+	state := &ServerStateJSON{
+		Uptime:   stats.GetUptime(cfg),
+		Services: stats.GetServiceStates(cfg),
+	}
+	if uptime, err := stats.GetUptimeSeconds(cfg); err == nil {
+		state.UptimeSeconds = uptime
+	}
+	if load1, load5, load15, err := stats.GetLoadAverageValues(cfg); err == nil {
+		state.LoadAverage = []float64{load1, load5, load15}
+	}
+	if used, total, err := stats.GetMemoryBytes(cfg); err == nil {
+		state.MemoryUsed, state.MemoryTotal = dataSize(used), dataSize(total)
+	}
+	if used, total, err := stats.GetDiskBytes(cfg); err == nil {
+		state.DiskUsed, state.DiskTotal = dataSize(used), dataSize(total)
+	}
+	return state
+}
+
+// buildNetworkJSON collects network statistics in structured form.
+func buildNetworkJSON(cfg *config.Config) *NetworkJSON {
+	trafficMonitor := stats.GetTrafficMonitor()
+	if trafficMonitor == nil {
+		cfg.Logger.Warn("Traffic monitor not initialized")
+		return nil
+	}
+
+	rxSpeed, txSpeed, rxPacketsPerSec, txPacketsPerSec, totalRxBytes, totalTxBytes := trafficMonitor.GetStats()
+	return &NetworkJSON{
+		Interface:       trafficMonitor.Iface,
+		RxSpeed:         rxSpeed,
+		RxSpeedHuman:    util.FormatData(rxSpeed, "bps"),
+		TxSpeed:         txSpeed,
+		TxSpeedHuman:    util.FormatData(txSpeed, "bps"),
+		RxPacketsPerSec: rxPacketsPerSec,
+		TxPacketsPerSec: txPacketsPerSec,
+		RxTotal:         dataSize(totalRxBytes),
+		TxTotal:         dataSize(totalTxBytes),
+	}
+}
+
+// queryStatsTable selects the given columns and returns the rows keyed by column name.
+// Traffic columns get an additional "<column>_human" field formatted by util.FormatData.
+func queryStatsTable(manager *manager.DatabaseManager, cfg *config.Config, table string, columns []string, orderBy string) (*StatsTableJSON, error) {
+	result := &StatsTableJSON{Columns: columns, Rows: []map[string]any{}}
+	if len(columns) == 0 {
+		return result, nil
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), table)
+	if orderBy != "" {
+		query += " ORDER BY " + orderBy
+	}
+
+	err := manager.ExecuteLowPriority(func(db *sql.DB) error {
+		cfg.Logger.Debug("Executing stats query", "query", query)
+		rows, err := db.Query(query)
+		if err != nil {
+			cfg.Logger.Error("Failed to execute stats query", "table", table, "error", err)
+			return fmt.Errorf("failed to execute %s query: %v", table, err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			values := make([]any, len(columns))
+			valuePtrs := make([]any, len(columns))
+			for i := range columns {
+				valuePtrs[i] = &values[i]
+			}
+			if err := rows.Scan(valuePtrs...); err != nil {
+				cfg.Logger.Error("Failed to scan row", "error", err)
+				return fmt.Errorf("failed to scan row: %v", err)
+			}
+
+			row := make(map[string]any, len(columns))
+			for i, col := range columns {
+				val := values[i]
+				if b, ok := val.([]byte); ok {
+					val = string(b)
+				}
+				row[col] = val
+				if contains(trafficColumns, col) {
+					if numVal, ok := val.(int64); ok {
+						unit := "byte"
+						if col == "rate" {
+							unit = "bps"
+						}
+						row[col+"_human"] = util.FormatData(float64(numVal), unit)
+					}
+				}
+			}
+			result.Rows = append(result.Rows, row)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// writeStatsJSON encodes a stats response as JSON.
+func writeStatsJSON(w http.ResponseWriter, cfg *config.Config, resp StatsJSON) bool {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		cfg.Logger.Error("Failed to encode JSON", "error", err)
+		http.Error(w, "Error forming response", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db"
+	"v2ray-stat/db/manager"
+	"v2ray-stat/subscription"
+)
+
+// subscriptionFormat picks the output format from the format query parameter or the client's User-Agent.
+func subscriptionFormat(r *http.Request) string {
+	switch format := strings.ToLower(r.URL.Query().Get("format")); format {
+	case subscription.FormatBase64, subscription.FormatClash, subscription.FormatSingbox:
+		return format
+	case "mihomo":
+		return subscription.FormatClash
+	case "sing-box":
+		return subscription.FormatSingbox
+	}
+
+	agent := strings.ToLower(r.UserAgent())
+	switch {
+	case strings.Contains(agent, "clash"), strings.Contains(agent, "mihomo"), strings.Contains(agent, "stash"):
+		return subscription.FormatClash
+	case strings.Contains(agent, "sing-box"), strings.Contains(agent, "sfa"), strings.Contains(agent, "sfi"):
+		return subscription.FormatSingbox
+	}
+	return subscription.FormatBase64
+}
+
+// subscriptionUserinfo formats the subscription-userinfo header value of a user.
+func subscriptionUserinfo(user *db.SubscriptionUser) string {
+	var expire int64
+	if user.SubEnd != "" {
+		if subEnd, err := db.ParseSubEnd(user.SubEnd); err == nil {
+			expire = subEnd.Unix()
+		}
+	}
+	return fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d", user.Uplink, user.Downlink, user.TrafficLimit, expire)
+}
+
+// SubscriptionHandler serves a user's connection links at /sub/<token> as base64, Clash/Mihomo YAML or sing-box JSON.
+func SubscriptionHandler(manager *manager.DatabaseManager, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg.Logger.Debug("Starting SubscriptionHandler request processing")
+
+		if r.Method != http.MethodGet {
+			cfg.Logger.Warn("Invalid HTTP method", "method", r.Method)
+			http.Error(w, "Invalid method. Use GET", http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := strings.TrimPrefix(r.URL.Path, "/sub/")
+		if token == "" || strings.Contains(token, "/") {
+			cfg.Logger.Warn("Missing or invalid subscription token")
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
+
+		user, err := db.GetUserBySubToken(manager, cfg, token)
+		if err != nil {
+			http.Error(w, "Error processing data", http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			cfg.Logger.Warn("Unknown subscription token")
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		endpoints, err := subscription.UserEndpoints(cfg, user.User, host)
+		if err != nil {
+			cfg.Logger.Error("Failed to build subscription endpoints", "user", user.User, "error", err)
+			http.Error(w, "Error processing data", http.StatusInternalServerError)
+			return
+		}
+
+		format := subscriptionFormat(r)
+		var body []byte
+		switch format {
+		case subscription.FormatClash:
+			body, err = subscription.Clash(endpoints, cfg.Subscription.Title)
+			w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+		case subscription.FormatSingbox:
+			body, err = subscription.Singbox(endpoints)
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		default:
+			body = subscription.Base64(endpoints)
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		if err != nil {
+			cfg.Logger.Error("Failed to render subscription", "user", user.User, "format", format, "error", err)
+			http.Error(w, "Error forming response", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Subscription-Userinfo", subscriptionUserinfo(user))
+		w.Header().Set("Profile-Update-Interval", strconv.Itoa(cfg.Subscription.UpdateInterval))
+		w.Header().Set("Profile-Title", cfg.Subscription.Title)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", cfg.Subscription.Title))
+		if _, err := w.Write(body); err != nil {
+			cfg.Logger.Error("Failed to write response", "error", err)
+			return
+		}
+
+		cfg.Logger.Info("API sub: completed successfully", "user", user.User, "format", format, "endpoints", len(endpoints))
+	}
+}
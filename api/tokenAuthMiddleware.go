@@ -36,50 +36,76 @@ func getClientIP(r *http.Request, cfg *config.Config) string {
 	return ip
 }
 
-// TokenAuthMiddleware verifies the token in the Authorization header.
-func TokenAuthMiddleware(cfg *config.Config, next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		clientIP := getClientIP(r, cfg)
-		cfg.Logger.Debug("Verifying token for request", "client_ip", clientIP)
+// verifyToken checks the token in the Authorization header and returns a description of the failure, if any.
+func verifyToken(r *http.Request, cfg *config.Config) (string, bool) {
+	clientIP := getClientIP(r, cfg)
+	cfg.Logger.Debug("Verifying token for request", "client_ip", clientIP)
 
-		// Allow access if no API token is set
-		if cfg.API.APIToken == "" {
-			cfg.Logger.Warn("API_TOKEN not set, request allowed", "client_ip", clientIP)
-			next.ServeHTTP(w, r)
-			return
-		}
+	// Allow access if no API token is set
+	if cfg.API.APIToken == "" {
+		cfg.Logger.Warn("API_TOKEN not set, request allowed", "client_ip", clientIP)
+		return "", true
+	}
 
-		// Check Authorization header
-		authHeader := r.Header.Get("Authorization")
-		cfg.Logger.Trace("Read Authorization header", "header", authHeader)
-		if authHeader == "" {
-			cfg.Logger.Warn("Missing Authorization header", "client_ip", clientIP)
-			http.Error(w, "Missing Authorization header", http.StatusUnauthorized)
-			return
-		}
+	// Check Authorization header
+	authHeader := r.Header.Get("Authorization")
+	cfg.Logger.Trace("Read Authorization header", "header", authHeader)
+	if authHeader == "" {
+		cfg.Logger.Warn("Missing Authorization header", "client_ip", clientIP)
+		return "Missing Authorization header", false
+	}
 
-		// Expect format "Bearer <token>"
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			cfg.Logger.Warn("Invalid Authorization header format", "client_ip", clientIP, "header", authHeader)
-			http.Error(w, "Invalid Authorization header format", http.StatusUnauthorized)
-			return
-		}
+	// Expect format "Bearer <token>"
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		cfg.Logger.Warn("Invalid Authorization header format", "client_ip", clientIP, "header", authHeader)
+		return "Invalid Authorization header format", false
+	}
 
-		// Verify token
-		token := strings.TrimSpace(parts[1])
-		if token == "" {
-			cfg.Logger.Warn("Empty token in Authorization header", "client_ip", clientIP)
-			http.Error(w, "Empty token", http.StatusUnauthorized)
-			return
-		}
-		if token != cfg.API.APIToken {
-			cfg.Logger.Warn("Invalid token", "client_ip", clientIP)
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+	// Verify token
+	token := strings.TrimSpace(parts[1])
+	if token == "" {
+		cfg.Logger.Warn("Empty token in Authorization header", "client_ip", clientIP)
+		return "Empty token", false
+	}
+	if token != cfg.API.APIToken {
+		cfg.Logger.Warn("Invalid token", "client_ip", clientIP)
+		return "Invalid token", false
+	}
+
+	cfg.Logger.Info("Token verified successfully", "client_ip", clientIP)
+	return "", true
+}
+
+// hasToken reports whether the request carries the API token or no API token is set. Unlike
+// verifyToken it does not log a missing token, for public endpoints that return more to clients
+// with the token.
+func hasToken(r *http.Request, cfg *config.Config) bool {
+	if cfg.API.APIToken == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && strings.TrimSpace(token) == cfg.API.APIToken
+}
+
+// hideSubTokens clears the subscription tokens of users for requests without the API token, since
+// a token gives access to the user's subscription.
+func hideSubTokens(r *http.Request, cfg *config.Config, users []User) {
+	if hasToken(r, cfg) {
+		return
+	}
+	for i := range users {
+		users[i].Sub_token = ""
+	}
+}
+
+// TokenAuthMiddleware verifies the token in the Authorization header.
+func TokenAuthMiddleware(cfg *config.Config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if message, ok := verifyToken(r, cfg); !ok {
+			http.Error(w, message, http.StatusUnauthorized)
 			return
 		}
-
-		cfg.Logger.Info("Token verified successfully", "client_ip", clientIP)
 		next.ServeHTTP(w, r)
 	}
 }
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db"
+	"v2ray-stat/db/manager"
+)
+
+// TrafficResetsHandler returns archived traffic cycles of users in JSON format.
+func TrafficResetsHandler(manager *manager.DatabaseManager, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg.Logger.Debug("Starting TrafficResetsHandler request processing")
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if r.Method != http.MethodGet {
+			cfg.Logger.Warn("Invalid HTTP method", "method", r.Method)
+			http.Error(w, "Invalid method. Use GET", http.StatusMethodNotAllowed)
+			return
+		}
+
+		limit := 100
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			l, err := strconv.Atoi(limitStr)
+			if err != nil || l < 1 || l > 1000 {
+				cfg.Logger.Warn("Invalid limit value", "limit", limitStr)
+				http.Error(w, "Invalid limit value, must be between 1 and 1000", http.StatusBadRequest)
+				return
+			}
+			limit = l
+		}
+		user := r.URL.Query().Get("user")
+
+		resets, err := db.GetTrafficResets(manager, cfg, user, limit)
+		if err != nil {
+			http.Error(w, "Error processing data", http.StatusInternalServerError)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(resets); err != nil {
+			cfg.Logger.Error("Failed to encode JSON", "error", err)
+			http.Error(w, "Error forming response", http.StatusInternalServerError)
+			return
+		}
+
+		cfg.Logger.Info("API traffic_resets: completed successfully", "resets_count", len(resets))
+	}
+}
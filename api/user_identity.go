@@ -0,0 +1,450 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"v2ray-stat/config"
+	"v2ray-stat/coreapi"
+	"v2ray-stat/db"
+	"v2ray-stat/db/manager"
+	"v2ray-stat/lua"
+)
+
+// coreConfigFiles holds config.json and .disabled_users of the core, for changes to users that
+// may be enabled or disabled and must be written together.
+type coreConfigFiles struct {
+	coreType        string
+	configPath      string
+	disabledPath    string
+	xray            config.ConfigXray
+	xrayDisabled    config.DisabledUsersConfigXray
+	singbox         config.ConfigSingbox
+	singboxDisabled config.DisabledUsersConfigSingbox
+}
+
+// userClient is a client of a user in one inbound of config.json or .disabled_users.
+type userClient struct {
+	Tag      string
+	Protocol string
+	Method   string
+	Disabled bool
+
+	xrayInbound *config.XrayInbound
+	xray        *config.XrayClient
+	singbox     *config.SingboxClient
+}
+
+// readCoreConfigFiles reads config.json and .disabled_users; a missing .disabled_users has no inbounds.
+func readCoreConfigFiles(cfg *config.Config) (*coreConfigFiles, error) {
+	files := &coreConfigFiles{
+		coreType:     cfg.V2rayStat.Type,
+		configPath:   cfg.Core.Config,
+		disabledPath: filepath.Join(cfg.Core.Dir, ".disabled_users"),
+	}
+
+	data, err := os.ReadFile(files.configPath)
+	if err != nil {
+		cfg.Logger.Error("Failed to read config.json", "path", files.configPath, "error", err)
+		return nil, fmt.Errorf("failed to read config.json: %v", err)
+	}
+	disabledData, err := os.ReadFile(files.disabledPath)
+	if err != nil && !os.IsNotExist(err) {
+		cfg.Logger.Error("Failed to read .disabled_users", "path", files.disabledPath, "error", err)
+		return nil, fmt.Errorf("failed to read .disabled_users: %v", err)
+	}
+
+	var main, disabled any
+	switch cfg.V2rayStat.Type {
+	case "xray":
+		main, disabled = &files.xray, &files.xrayDisabled
+	case "singbox":
+		main, disabled = &files.singbox, &files.singboxDisabled
+	default:
+		return nil, fmt.Errorf("unsupported core type: %s", cfg.V2rayStat.Type)
+	}
+	if err := json.Unmarshal(data, main); err != nil {
+		cfg.Logger.Error("Failed to parse JSON for config.json", "error", err)
+		return nil, fmt.Errorf("failed to parse JSON for config.json: %v", err)
+	}
+	if len(disabledData) > 0 {
+		if err := json.Unmarshal(disabledData, disabled); err != nil {
+			cfg.Logger.Error("Failed to parse JSON for .disabled_users", "error", err)
+			return nil, fmt.Errorf("failed to parse JSON for .disabled_users: %v", err)
+		}
+	}
+	return files, nil
+}
+
+// userInConfig reports whether a user has a client in config.json or .disabled_users.
+func userInConfig(user string, cfg *config.Config) (bool, error) {
+	unlock, err := config.LockCoreFiles(cfg)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	files, err := readCoreConfigFiles(cfg)
+	if err != nil {
+		return false, err
+	}
+	return len(files.userClients(user)) > 0, nil
+}
+
+// save writes config.json and .disabled_users with config.WriteCoreFiles, removing .disabled_users
+// if it has no inbounds. The caller must hold config.LockCoreFiles since the files were read.
+func (f *coreConfigFiles) save(cfg *config.Config) error {
+	main, disabled, disabledInbounds := any(f.xray), any(f.xrayDisabled), len(f.xrayDisabled.Inbounds)
+	if f.coreType == "singbox" {
+		main, disabled, disabledInbounds = f.singbox, f.singboxDisabled, len(f.singboxDisabled.Inbounds)
+	}
+
+	mainData, err := config.MarshalCoreConfig(main)
+	if err != nil {
+		cfg.Logger.Error("Failed to marshal JSON for config.json", "error", err)
+		return fmt.Errorf("failed to save config.json: %v", err)
+	}
+	var disabledData []byte
+	if disabledInbounds > 0 {
+		if disabledData, err = config.MarshalCoreConfig(disabled); err != nil {
+			cfg.Logger.Error("Failed to marshal JSON for .disabled_users", "error", err)
+			return fmt.Errorf("failed to save .disabled_users: %v", err)
+		}
+	}
+	if err := config.WriteCoreFiles(cfg, mainData, disabledData); err != nil {
+		cfg.Logger.Error("Failed to write configuration files", "error", err)
+		return fmt.Errorf("failed to save configuration files: %w", err)
+	}
+	return nil
+}
+
+// clients calls fn for every client in every inbound of both files, in the order of the files.
+// The clients point into the files, so changes to them are written by save.
+func (f *coreConfigFiles) clients(fn func(name string, client userClient)) {
+	xrayClients := func(inbounds []config.XrayInbound, disabled bool) {
+		for i := range inbounds {
+			inbound := &inbounds[i]
+			method := ""
+			if inbound.Settings.Method != nil {
+				method = *inbound.Settings.Method
+			}
+			for j := range inbound.Settings.Clients {
+				fn(inbound.Settings.Clients[j].Email, userClient{Tag: inbound.Tag, Protocol: inbound.Protocol, Method: method, Disabled: disabled,
+					xrayInbound: inbound, xray: &inbound.Settings.Clients[j]})
+			}
+		}
+	}
+	singboxClients := func(inbounds []config.SingboxInbound, disabled bool) {
+		for i := range inbounds {
+			inbound := &inbounds[i]
+			for j := range inbound.Users {
+				fn(inbound.Users[j].Name, userClient{Tag: inbound.Tag, Protocol: inbound.Type, Method: inbound.Method, Disabled: disabled,
+					singbox: &inbound.Users[j]})
+			}
+		}
+	}
+
+	xrayClients(f.xray.Inbounds, false)
+	xrayClients(f.xrayDisabled.Inbounds, true)
+	singboxClients(f.singbox.Inbounds, false)
+	singboxClients(f.singboxDisabled.Inbounds, true)
+}
+
+// userClients returns the clients of a user in every inbound of both files.
+func (f *coreConfigFiles) userClients(user string) []userClient {
+	var clients []userClient
+	f.clients(func(name string, client userClient) {
+		if name == user {
+			clients = append(clients, client)
+		}
+	})
+	return clients
+}
+
+// setName changes the user name of the client.
+func (c userClient) setName(name string) {
+	if c.xray != nil {
+		c.xray.Email = name
+	} else {
+		c.singbox.Name = name
+	}
+}
+
+// setCredential stores a credential in the fields used by the protocol of the client.
+func (c userClient) setCredential(credential string) {
+	if c.xray != nil {
+		c.xray.SetCredential(c.Protocol, credential)
+	} else {
+		c.singbox.SetCredential(c.Protocol, credential)
+	}
+}
+
+// inboundClient returns the tag, protocol and credential of the client.
+func (c userClient) inboundClient() InboundClient {
+	if c.xray != nil {
+		return InboundClient{Tag: c.Tag, Protocol: c.Protocol, Credential: c.xray.Credential(c.Protocol)}
+	}
+	credential := c.singbox.Credential(c.Protocol)
+	if c.Protocol == "tuic" {
+		credential = c.singbox.UUID + ":" + c.singbox.Password
+	}
+	return InboundClient{Tag: c.Tag, Protocol: c.Protocol, Credential: credential}
+}
+
+// replaceLiveClients replaces the clients of the running Xray inbounds that are enabled in
+// config.json; oldName is the user name the running core knows the clients by.
+func replaceLiveClients(cfg *config.Config, clients []userClient, oldName string) {
+	if cfg.V2rayStat.Type != "xray" || !coreapi.LiveUpdateEnabled(cfg) {
+		return
+	}
+	for _, client := range clients {
+		if client.Disabled {
+			continue
+		}
+		if err := coreapi.RemoveInboundUser(cfg, client.Tag, oldName); err != nil {
+			cfg.Logger.Error("Failed to remove user from running core", "user", oldName, "inboundTag", client.Tag, "error", err)
+			continue
+		}
+		if err := coreapi.AddInboundUser(cfg, *client.xrayInbound, *client.xray); err != nil {
+			cfg.Logger.Error("Failed to add user to running core", "user", client.xray.Email, "inboundTag", client.Tag, "error", err)
+		}
+	}
+}
+
+// replaceAuthLuaUser replaces the auth.lua entry of a user with the credential of its clients.
+func replaceAuthLuaUser(cfg *config.Config, oldName, newName string, clients []InboundClient) {
+	credential, ok := authLuaCredential(clients, cfg)
+	if !cfg.Features["auth_lua"] || !ok {
+		return
+	}
+	if err := lua.DeleteUserFromAuthLua(cfg, oldName); err != nil {
+		cfg.Logger.Warn("Failed to delete user from auth.lua", "user", oldName, "error", err)
+	}
+	if err := lua.AddUserToAuthLua(cfg, newName, credential); err != nil {
+		cfg.Logger.Error("Failed to add user to auth.lua", "user", newName, "error", err)
+	} else {
+		cfg.Logger.Debug("User updated in auth.lua", "user", newName)
+	}
+}
+
+// RotateUserCredential replaces the credential of a user in every inbound of config.json and
+// .disabled_users with a newly generated one, shared by inbounds of the same protocol, and
+// updates the running core and auth.lua. It returns the new credentials.
+func RotateUserCredential(user string, cfg *config.Config) ([]InboundClient, error) {
+	cfg.Logger.Debug("Rotating user credential", "user", user)
+	unlock, err := config.LockCoreFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	files, err := readCoreConfigFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	clients := files.userClients(user)
+	if len(clients) == 0 {
+		cfg.Logger.Warn("User not found in configuration", "user", user)
+		return nil, fmt.Errorf("user %s not found in either config.json or .disabled_users", user)
+	}
+
+	credentialFor, _ := credentialGenerator("", cfg)
+	rotated := make([]InboundClient, 0, len(clients))
+	for _, client := range clients {
+		credential, err := credentialFor(client.Protocol, client.Method)
+		if err != nil {
+			return nil, err
+		}
+		client.setCredential(credential)
+		rotated = append(rotated, InboundClient{Tag: client.Tag, Protocol: client.Protocol, Credential: credential})
+	}
+
+	if err := files.save(cfg); err != nil {
+		return nil, err
+	}
+	replaceLiveClients(cfg, clients, user)
+	replaceAuthLuaUser(cfg, user, user, rotated)
+
+	cfg.Logger.Debug("User credential rotated", "user", user, "inbounds", len(rotated))
+	return rotated, nil
+}
+
+// RenameUserInConfig changes the name of a user in every inbound of config.json and
+// .disabled_users and updates the running core and auth.lua.
+func RenameUserInConfig(oldName, newName string, cfg *config.Config) error {
+	cfg.Logger.Debug("Renaming user in configuration", "user", oldName, "new_user", newName)
+	unlock, err := config.LockCoreFiles(cfg)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	files, err := readCoreConfigFiles(cfg)
+	if err != nil {
+		return err
+	}
+	if len(files.userClients(newName)) > 0 {
+		cfg.Logger.Warn("User already exists in configuration", "user", newName)
+		return fmt.Errorf("%w in configuration: %s", db.ErrUserExists, newName)
+	}
+	clients := files.userClients(oldName)
+	if len(clients) == 0 {
+		cfg.Logger.Warn("User not found in configuration", "user", oldName)
+		return fmt.Errorf("%w in either config.json or .disabled_users: %s", db.ErrUserNotFound, oldName)
+	}
+
+	renamed := make([]InboundClient, 0, len(clients))
+	for _, client := range clients {
+		client.setName(newName)
+		renamed = append(renamed, client.inboundClient())
+	}
+
+	if err := files.save(cfg); err != nil {
+		return err
+	}
+	replaceLiveClients(cfg, clients, oldName)
+	replaceAuthLuaUser(cfg, oldName, newName, renamed)
+
+	cfg.Logger.Debug("User renamed in configuration", "user", oldName, "new_user", newName, "inbounds", len(clients))
+	return nil
+}
+
+// RotateCredentialHandler handles HTTP requests to regenerate the credential of a user and,
+// with sub_token=true, their subscription token.
+func RotateCredentialHandler(manager *manager.DatabaseManager, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg.Logger.Debug("Starting RotateCredentialHandler request processing")
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		if r.Method != http.MethodPost {
+			cfg.Logger.Warn("Invalid HTTP method", "method", r.Method)
+			http.Error(w, "Invalid method. Use POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			cfg.Logger.Error("Failed to parse form data", "error", err)
+			http.Error(w, "Error parsing form data", http.StatusBadRequest)
+			return
+		}
+
+		userIdentifier := r.FormValue("user")
+		if userIdentifier == "" {
+			cfg.Logger.Warn("Missing or empty user parameter")
+			http.Error(w, "user is required", http.StatusBadRequest)
+			return
+		}
+		rotateSubToken := false
+		if value := r.FormValue("sub_token"); value != "" {
+			var err error
+			if rotateSubToken, err = strconv.ParseBool(value); err != nil {
+				cfg.Logger.Warn("Invalid sub_token value", "sub_token", value)
+				http.Error(w, "sub_token must be true or false", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// The user sync stays locked until the database has the new credential. A user that is
+		// not in the database yet gets it from the next sync.
+		unlockSync := db.LockUserSync()
+		rotated, err := RotateUserCredential(userIdentifier, cfg)
+		if err != nil {
+			unlockSync()
+			cfg.Logger.Error("Failed to rotate user credential", "user", userIdentifier, "error", err)
+			http.Error(w, err.Error(), configWriteStatus(err, http.StatusNotFound))
+			return
+		}
+		if err := db.UpdateUserCredential(manager, cfg, userIdentifier, rotated[0].DBCredential(userIdentifier, cfg)); err != nil && !errors.Is(err, db.ErrUserNotFound) {
+			cfg.Logger.Error("Failed to update user credential in database", "user", userIdentifier, "error", err)
+		}
+		unlockSync()
+
+		var builder strings.Builder
+		for _, client := range rotated {
+			fmt.Fprintf(&builder, "%s: %s\n", client.Tag, client.Credential)
+		}
+		if rotateSubToken {
+			token, err := db.RotateSubToken(manager, cfg, userIdentifier)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Credential rotated, but failed to rotate subscription token: %v", err), http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprintf(&builder, "sub_token: %s\n", token)
+		}
+
+		cfg.Logger.Info("API rotate_credential: credential rotated successfully", "user", userIdentifier, "inbounds", len(rotated), "sub_token", rotateSubToken)
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, builder.String())
+	}
+}
+
+// RenameUserHandler handles HTTP requests to rename a user, keeping their statistics and subscription.
+func RenameUserHandler(manager *manager.DatabaseManager, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg.Logger.Debug("Starting RenameUserHandler request processing")
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		if r.Method != http.MethodPatch {
+			cfg.Logger.Warn("Invalid HTTP method", "method", r.Method)
+			http.Error(w, "Invalid method. Use PATCH", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			cfg.Logger.Error("Failed to parse form data", "error", err)
+			http.Error(w, "Error parsing form data", http.StatusBadRequest)
+			return
+		}
+
+		userIdentifier := r.FormValue("user")
+		newUser := strings.TrimSpace(r.FormValue("new_user"))
+		if userIdentifier == "" || newUser == "" {
+			cfg.Logger.Warn("Missing user or new_user parameter")
+			http.Error(w, "user and new_user are required", http.StatusBadRequest)
+			return
+		}
+		if len(newUser) > 40 {
+			cfg.Logger.Warn("New user name too long", "length", len(newUser))
+			http.Error(w, "new_user too long (max 40 characters)", http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(newUser, "/") {
+			cfg.Logger.Warn("Invalid new user name", "new_user", newUser)
+			http.Error(w, "new_user must not contain /", http.StatusBadRequest)
+			return
+		}
+		if newUser == userIdentifier {
+			http.Error(w, "new_user must differ from user", http.StatusBadRequest)
+			return
+		}
+
+		err := db.RenameUser(manager, cfg, userIdentifier, newUser, func(from, to string) error {
+			return RenameUserInConfig(from, to, cfg)
+		})
+		if err != nil {
+			status := http.StatusBadRequest
+			switch {
+			case errors.Is(err, db.ErrUserNotFound):
+				status = http.StatusNotFound
+			case errors.Is(err, db.ErrUserExists):
+				status = http.StatusConflict
+			case errors.Is(err, config.ErrCoreConfigRejected):
+				status = http.StatusUnprocessableEntity
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		cfg.Logger.Info("API rename_user: user renamed successfully", "user", userIdentifier, "new_user", newUser)
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "User renamed successfully")
+	}
+}
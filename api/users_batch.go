@@ -0,0 +1,234 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db"
+	"v2ray-stat/db/manager"
+)
+
+// usersBatchName is the sub-path of the user resource that accepts batch requests with POST.
+const usersBatchName = "batch"
+
+// Actions of a batch request.
+const (
+	batchActionSetEnabled  = "set_enabled"
+	batchActionAdjustDate  = "adjust_date"
+	batchActionUpdateRenew = "update_renew"
+	batchActionUpdateLimIP = "update_lim_ip"
+	batchActionDelete      = "delete"
+)
+
+// userBatchRequest is the JSON body of a batch request: an action with its value applied to
+// the users given by name or selected by tag.
+type userBatchRequest struct {
+	Action  string   `json:"action"`
+	Users   []string `json:"users"`
+	Tag     string   `json:"tag"`
+	Enabled *bool    `json:"enabled"`
+	SubEnd  *string  `json:"sub_end"`
+	Renew   *int     `json:"renew"`
+	LimIP   *int     `json:"lim_ip"`
+	Inbound string   `json:"inbound"`
+}
+
+// BatchUserResult is the outcome of a batch action for one user.
+type BatchUserResult struct {
+	User   string `json:"user"`
+	Status string `json:"status"` // "ok" or "error"
+	Error  string `json:"error,omitempty"`
+}
+
+// BatchResultV2 is the per-user report of a batch request.
+type BatchResultV2 struct {
+	Action    string            `json:"action"`
+	Total     int               `json:"total"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []BatchUserResult `json:"results"`
+}
+
+// userUpdate checks that the request carries exactly the value of its action and converts it to a database update.
+func (req userBatchRequest) userUpdate() (db.UserUpdate, error) {
+	values := map[string]bool{
+		batchActionSetEnabled:  req.Enabled != nil,
+		batchActionAdjustDate:  req.SubEnd != nil,
+		batchActionUpdateRenew: req.Renew != nil,
+		batchActionUpdateLimIP: req.LimIP != nil,
+		batchActionDelete:      req.Inbound != "",
+	}
+	fields := map[string]string{
+		batchActionSetEnabled:  "enabled",
+		batchActionAdjustDate:  "sub_end",
+		batchActionUpdateRenew: "renew",
+		batchActionUpdateLimIP: "lim_ip",
+		batchActionDelete:      "inbound",
+	}
+
+	if req.Action == "" {
+		return db.UserUpdate{}, fmt.Errorf("action is required")
+	}
+	if _, ok := fields[req.Action]; !ok {
+		return db.UserUpdate{}, fmt.Errorf("invalid action %q, must be set_enabled, adjust_date, update_renew, update_lim_ip or delete", req.Action)
+	}
+	for action, set := range values {
+		if set && action != req.Action {
+			return db.UserUpdate{}, fmt.Errorf("%s cannot be used with action %s", fields[action], req.Action)
+		}
+	}
+	if !values[req.Action] && req.Action != batchActionDelete {
+		return db.UserUpdate{}, fmt.Errorf("%s is required for action %s", fields[req.Action], req.Action)
+	}
+
+	return userV2Request{Enabled: req.Enabled, SubEnd: req.SubEnd, Renew: req.Renew, LimIP: req.LimIP}.userUpdate()
+}
+
+// selectBatchUsers returns the existing users selected by a batch request and the requested
+// users that do not exist.
+func selectBatchUsers(manager *manager.DatabaseManager, cfg *config.Config, req userBatchRequest) (selected, missing []string, err error) {
+	filter := userFilter{Tag: req.Tag, SortBy: "user", SortOrder: "ASC"}
+	if req.Tag == "" {
+		var names []string
+		seen := make(map[string]bool)
+		for _, user := range req.Users {
+			if user != "" && !seen[user] {
+				names = append(names, user)
+				seen[user] = true
+			}
+		}
+		filter = userFilter{Users: names, FilterUsers: true}
+	}
+
+	users, _, err := queryUsers(manager, cfg, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	existing := make(map[string]bool, len(users))
+	for _, user := range users {
+		existing[user.User] = true
+	}
+
+	if req.Tag != "" {
+		for _, user := range users {
+			selected = append(selected, user.User)
+		}
+		return selected, nil, nil
+	}
+	// Keep the order of the request
+	for _, user := range filter.Users {
+		if existing[user] {
+			selected = append(selected, user)
+		} else {
+			missing = append(missing, user)
+		}
+	}
+	return selected, missing, nil
+}
+
+// applyBatchAction applies the action of a batch request to users and returns the errors of
+// the users it failed for. The core config files are read and written once for all users.
+func applyBatchAction(manager *manager.DatabaseManager, cfg *config.Config, req userBatchRequest, update db.UserUpdate, users []string) (map[string]error, error) {
+	switch req.Action {
+	case batchActionDelete:
+		failed, err := DeleteUsersFromConfig(users, req.Inbound, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if len(failed) < len(users) {
+			if err := db.DelUserFromDB(manager, cfg); err != nil {
+				cfg.Logger.Error("Failed to remove users from database", "error", err)
+			}
+		}
+		return failed, nil
+
+	case batchActionSetEnabled:
+		failed, err := db.ToggleUsersEnabled(manager, cfg, users, *req.Enabled)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			if failed[user] != nil {
+				continue
+			}
+			if err := db.UpdateUser(manager, cfg, user, update); err != nil {
+				failed[user] = err
+			}
+		}
+		return failed, nil
+
+	default:
+		failed := make(map[string]error)
+		for _, user := range users {
+			if err := db.UpdateUser(manager, cfg, user, update); err != nil {
+				failed[user] = err
+			}
+		}
+		applyUserChecks(manager, cfg, update)
+		return failed, nil
+	}
+}
+
+// batchUsersV2 applies set_enabled, adjust_date, update_renew, update_lim_ip or delete to a list
+// of users or to all users with a tag and writes a per-user report.
+func batchUsersV2(w http.ResponseWriter, r *http.Request, manager *manager.DatabaseManager, cfg *config.Config) {
+	var req userBatchRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUserBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		cfg.Logger.Warn("Invalid request body", "error", err)
+		writeJSONError(w, cfg, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+
+	if (len(req.Users) == 0) == (req.Tag == "") {
+		writeJSONError(w, cfg, http.StatusBadRequest, "exactly one of users or tag is required")
+		return
+	}
+	if len(req.Users) > maxUsersLimit {
+		writeJSONError(w, cfg, http.StatusBadRequest, fmt.Sprintf("too many users (max %d)", maxUsersLimit))
+		return
+	}
+	update, err := req.userUpdate()
+	if err != nil {
+		cfg.Logger.Warn("Invalid batch request", "action", req.Action, "error", err)
+		writeJSONError(w, cfg, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	users, missing, err := selectBatchUsers(manager, cfg, req)
+	if err != nil {
+		writeJSONError(w, cfg, http.StatusInternalServerError, "Error processing data")
+		return
+	}
+
+	failed := map[string]error{}
+	if len(users) > 0 {
+		if failed, err = applyBatchAction(manager, cfg, req, update, users); err != nil {
+			cfg.Logger.Error("Failed to apply batch action", "action", req.Action, "error", err)
+			writeJSONError(w, cfg, http.StatusInternalServerError, fmt.Sprintf("Failed to apply %s: %v", req.Action, err))
+			return
+		}
+	}
+
+	result := BatchResultV2{Action: req.Action, Results: []BatchUserResult{}}
+	for _, user := range users {
+		if err := failed[user]; err != nil {
+			result.Results = append(result.Results, BatchUserResult{User: user, Status: "error", Error: err.Error()})
+			result.Failed++
+		} else {
+			result.Results = append(result.Results, BatchUserResult{User: user, Status: "ok"})
+			result.Succeeded++
+		}
+	}
+	for _, user := range missing {
+		result.Results = append(result.Results, BatchUserResult{User: user, Status: "error", Error: fmt.Sprintf("user %s not found", user)})
+		result.Failed++
+	}
+	result.Total = len(result.Results)
+
+	writeJSON(w, cfg, http.StatusOK, result)
+	cfg.Logger.Info("API v2 users: batch completed", "action", req.Action, "succeeded", result.Succeeded, "failed", result.Failed)
+}
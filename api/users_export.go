@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db/manager"
+)
+
+// UserExport is a user with the attributes and inbound credentials needed to recreate them on
+// another server. It is the format of the export and of the import.
+type UserExport struct {
+	User            string          `json:"user"`
+	Enabled         bool            `json:"enabled"`
+	SubEnd          string          `json:"sub_end"`
+	Renew           int             `json:"renew"`
+	LimIP           int             `json:"lim_ip"`
+	TrafficLimit    int64           `json:"traffic_limit"`
+	UplinkLimit     int64           `json:"uplink_limit"`
+	DownlinkLimit   int64           `json:"downlink_limit"`
+	ResetPolicy     string          `json:"reset_policy"`
+	ResetDay        int             `json:"reset_day"`
+	Uplink          int64           `json:"uplink"`
+	Downlink        int64           `json:"downlink"`
+	SubToken        string          `json:"sub_token"`
+	Notes           string          `json:"notes"`
+	Tags            []string        `json:"tags"`
+	ContactTelegram string          `json:"contact_telegram"`
+	ContactEmail    string          `json:"contact_email"`
+	Inbounds        []InboundClient `json:"inbounds"`
+}
+
+// userExportColumns are the CSV columns of the export and the import. Tags and inbounds are
+// separated by semicolons, and an inbound is written as tag=credential.
+var userExportColumns = []string{
+	"user", "enabled", "sub_end", "renew", "lim_ip", "traffic_limit", "uplink_limit", "downlink_limit",
+	"reset_policy", "reset_day", "uplink", "downlink", "sub_token", "notes", "tags",
+	"contact_telegram", "contact_email", "inbounds",
+}
+
+// formatInbounds writes inbound credentials as tag=credential pairs separated by semicolons.
+func formatInbounds(inbounds []InboundClient) string {
+	pairs := make([]string, len(inbounds))
+	for i, inbound := range inbounds {
+		pairs[i] = inbound.Tag + "=" + inbound.Credential
+	}
+	return strings.Join(pairs, ";")
+}
+
+// csvRecord returns the values of the user in the order of userExportColumns.
+func (u UserExport) csvRecord() []string {
+	return []string{
+		u.User, strconv.FormatBool(u.Enabled), u.SubEnd, strconv.Itoa(u.Renew), strconv.Itoa(u.LimIP),
+		strconv.FormatInt(u.TrafficLimit, 10), strconv.FormatInt(u.UplinkLimit, 10), strconv.FormatInt(u.DownlinkLimit, 10),
+		u.ResetPolicy, strconv.Itoa(u.ResetDay), strconv.FormatInt(u.Uplink, 10), strconv.FormatInt(u.Downlink, 10),
+		u.SubToken, u.Notes, strings.Join(u.Tags, ";"), u.ContactTelegram, u.ContactEmail, formatInbounds(u.Inbounds),
+	}
+}
+
+// exportUsers returns all users of clients_stats with their inbound credentials from
+// config.json and .disabled_users.
+func exportUsers(manager *manager.DatabaseManager, cfg *config.Config) ([]UserExport, error) {
+	users, _, err := queryUsers(manager, cfg, userFilter{SortBy: "user", SortOrder: "ASC"})
+	if err != nil {
+		return nil, err
+	}
+	files, err := readCoreConfigFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	inbounds := make(map[string][]InboundClient)
+	files.clients(func(name string, client userClient) {
+		inbounds[name] = append(inbounds[name], client.inboundClient())
+	})
+
+	exported := make([]UserExport, 0, len(users))
+	for _, user := range users {
+		tags := user.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		userInbounds := inbounds[user.User]
+		if userInbounds == nil {
+			userInbounds = []InboundClient{}
+		}
+		exported = append(exported, UserExport{
+			User:            user.User,
+			Enabled:         user.Enabled != "false",
+			SubEnd:          user.Sub_end,
+			Renew:           user.Renew,
+			LimIP:           user.Lim_ip,
+			TrafficLimit:    user.Traffic_limit,
+			UplinkLimit:     user.Uplink_limit,
+			DownlinkLimit:   user.Downlink_limit,
+			ResetPolicy:     user.Reset_policy,
+			ResetDay:        user.Reset_day,
+			Uplink:          user.Uplink,
+			Downlink:        user.Downlink,
+			SubToken:        user.Sub_token,
+			Notes:           user.Notes,
+			Tags:            tags,
+			ContactTelegram: user.Contact_telegram,
+			ContactEmail:    user.Contact_email,
+			Inbounds:        userInbounds,
+		})
+	}
+	return exported, nil
+}
+
+// ExportUsersHandler returns all users with their credentials, inbounds, subscription, limits
+// and traffic totals as JSON or, with format=csv, as CSV.
+func ExportUsersHandler(manager *manager.DatabaseManager, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg.Logger.Debug("Starting ExportUsersHandler request processing")
+
+		if r.Method != http.MethodGet {
+			cfg.Logger.Warn("Invalid HTTP method", "method", r.Method)
+			http.Error(w, "Method not allowed, use GET", http.StatusMethodNotAllowed)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		if format != "json" && format != "csv" {
+			cfg.Logger.Warn("Invalid export format", "format", format)
+			http.Error(w, "Invalid format, must be json or csv", http.StatusBadRequest)
+			return
+		}
+
+		users, err := exportUsers(manager, cfg)
+		if err != nil {
+			cfg.Logger.Error("Failed to export users", "error", err)
+			http.Error(w, "Error processing data", http.StatusInternalServerError)
+			return
+		}
+
+		if format == "json" {
+			writeJSON(w, cfg, http.StatusOK, users)
+		} else {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+			writer := csv.NewWriter(w)
+			writer.Write(userExportColumns)
+			for _, user := range users {
+				writer.Write(user.csvRecord())
+			}
+			writer.Flush()
+			if err := writer.Error(); err != nil {
+				cfg.Logger.Error("Failed to write CSV", "error", err)
+				return
+			}
+		}
+		cfg.Logger.Info("API users/export: users exported successfully", "count", len(users), "format", format)
+	}
+}
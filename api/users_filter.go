@@ -0,0 +1,241 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db"
+	"v2ray-stat/util"
+)
+
+// maxUsersLimit limits the number of users returned in one page of the user list.
+const maxUsersLimit = 10000
+
+// userSortColumns maps sort_by values of the user list to SQL expressions.
+var userSortColumns = map[string]string{
+	"user":          "user",
+	"created":       "created",
+	"sub_end":       "sub_end",
+	"renew":         "renew",
+	"lim_ip":        "lim_ip",
+	"enabled":       "enabled",
+	"last_seen":     "last_seen",
+	"rate":          "CAST(rate AS INTEGER)",
+	"uplink":        "uplink",
+	"downlink":      "downlink",
+	"traffic":       "uplink + downlink",
+	"sess_uplink":   "sess_uplink",
+	"sess_downlink": "sess_downlink",
+	"traffic_limit": "traffic_limit",
+}
+
+// userFilter holds the filters, sort order and page of a user list query; zero values disable a filter.
+type userFilter struct {
+	User           string // Exact user name
+	Enabled        string // "true" or "false"
+	Expired        string // "true" or "false"
+	ExpiringWithin time.Duration
+	Online         string   // "true" or "false"
+	Users          []string // Set by the inbound filter or a list of user names
+	FilterUsers    bool
+	Prefix         string
+	Search         string
+	Tag            string
+	Contact        string
+	Notes          string
+	MinTraffic     int64
+	SortBy         string
+	SortOrder      string
+	Limit          int
+	Offset         int
+}
+
+// parseBoolParam normalizes an optional boolean query parameter to "true", "false" or "".
+func parseBoolParam(r *http.Request, name string) (string, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return "", nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s value, must be true or false", name)
+	}
+	return strconv.FormatBool(b), nil
+}
+
+// parseUserFilter reads the user list query parameters: enabled, expired, expiring_within, online,
+// inbound, prefix, search, tag, contact, notes, min_traffic, sort_by, sort_order, limit and offset.
+func parseUserFilter(r *http.Request, cfg *config.Config) (userFilter, error) {
+	query := r.URL.Query()
+	filter := userFilter{
+		Prefix:    query.Get("prefix"),
+		Search:    query.Get("search"),
+		Tag:       strings.TrimSpace(query.Get("tag")),
+		Contact:   query.Get("contact"),
+		Notes:     query.Get("notes"),
+		SortBy:    "user",
+		SortOrder: "ASC",
+	}
+
+	var err error
+	if filter.Enabled, err = parseBoolParam(r, "enabled"); err != nil {
+		return filter, err
+	}
+	if filter.Expired, err = parseBoolParam(r, "expired"); err != nil {
+		return filter, err
+	}
+	if filter.Online, err = parseBoolParam(r, "online"); err != nil {
+		return filter, err
+	}
+
+	if value := query.Get("expiring_within"); value != "" {
+		if filter.ExpiringWithin, err = config.ParseDaysHours(value); err != nil {
+			return filter, fmt.Errorf("invalid expiring_within value: %v", err)
+		}
+	}
+
+	if inboundTag := query.Get("inbound"); inboundTag != "" {
+		if filter.Users, err = db.InboundUsers(cfg, inboundTag); err != nil {
+			cfg.Logger.Error("Failed to read inbound users", "inboundTag", inboundTag, "error", err)
+			return filter, fmt.Errorf("failed to read users of inbound %s", inboundTag)
+		}
+		filter.FilterUsers = true
+	}
+
+	if value := query.Get("min_traffic"); value != "" {
+		if filter.MinTraffic, err = util.ParseDataSize(value); err != nil {
+			return filter, fmt.Errorf("invalid min_traffic value: must be bytes or a size like 500MiB, 100GB")
+		}
+	}
+
+	if sortBy := query.Get("sort_by"); sortBy != "" {
+		if _, ok := userSortColumns[sortBy]; !ok {
+			return filter, fmt.Errorf("invalid sort_by parameter: %s", sortBy)
+		}
+		filter.SortBy = sortBy
+	}
+	if sortOrder := query.Get("sort_order"); sortOrder != "" {
+		if sortOrder != "ASC" && sortOrder != "DESC" {
+			return filter, fmt.Errorf("invalid sort_order parameter: %s, must be ASC or DESC", sortOrder)
+		}
+		filter.SortOrder = sortOrder
+	}
+
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 1 || filter.Limit > maxUsersLimit {
+			return filter, fmt.Errorf("invalid limit value, must be between 1 and %d", maxUsersLimit)
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		if filter.Offset, err = strconv.Atoi(value); err != nil || filter.Offset < 0 {
+			return filter, fmt.Errorf("invalid offset value, must be a non-negative number")
+		}
+	}
+	return filter, nil
+}
+
+// escapeLike escapes the LIKE wildcards of a value for use with ESCAPE '\'.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// where builds the WHERE clause of the filter and its arguments.
+func (f userFilter) where() (string, []any) {
+	var conds []string
+	var args []any
+
+	if f.User != "" {
+		conds = append(conds, "user = ?")
+		args = append(args, f.User)
+	}
+	if f.Enabled != "" {
+		conds = append(conds, "enabled = ?")
+		args = append(args, f.Enabled)
+	}
+
+	// sub_end is stored as 2006-01-02-15 in local time, so it can be compared as a string
+	now := time.Now()
+	nowStr := now.Format("2006-01-02-15")
+	switch f.Expired {
+	case "true":
+		conds = append(conds, "sub_end != '' AND sub_end < ?")
+		args = append(args, nowStr)
+	case "false":
+		conds = append(conds, "(sub_end = '' OR sub_end >= ?)")
+		args = append(args, nowStr)
+	}
+	if f.ExpiringWithin > 0 {
+		conds = append(conds, "sub_end != '' AND sub_end >= ? AND sub_end <= ?")
+		args = append(args, nowStr, now.Add(f.ExpiringWithin).Format("2006-01-02-15"))
+	}
+
+	switch f.Online {
+	case "true":
+		conds = append(conds, "last_seen = 'online'")
+	case "false":
+		conds = append(conds, "last_seen != 'online'")
+	}
+
+	if f.FilterUsers {
+		if len(f.Users) == 0 {
+			conds = append(conds, "0")
+		} else {
+			conds = append(conds, "user IN (?"+strings.Repeat(", ?", len(f.Users)-1)+")")
+			for _, user := range f.Users {
+				args = append(args, user)
+			}
+		}
+	}
+
+	if f.Prefix != "" {
+		conds = append(conds, `user LIKE ? ESCAPE '\'`)
+		args = append(args, escapeLike(f.Prefix)+"%")
+	}
+	if f.Search != "" {
+		conds = append(conds, `user LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(f.Search)+"%")
+	}
+	if f.Tag != "" {
+		conds = append(conds, `(',' || tags || ',') LIKE ? ESCAPE '\'`)
+		args = append(args, "%,"+escapeLike(f.Tag)+",%")
+	}
+	if f.Contact != "" {
+		conds = append(conds, `(contact_telegram LIKE ? ESCAPE '\' OR contact_email LIKE ? ESCAPE '\')`)
+		pattern := "%" + escapeLike(f.Contact) + "%"
+		args = append(args, pattern, pattern)
+	}
+	if f.Notes != "" {
+		conds = append(conds, `notes LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(f.Notes)+"%")
+	}
+	if f.MinTraffic > 0 {
+		conds = append(conds, "uplink + downlink >= ?")
+		args = append(args, f.MinTraffic)
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// orderAndPage builds the ORDER BY, LIMIT and OFFSET clauses of the filter.
+func (f userFilter) orderAndPage() string {
+	clause := ""
+	if f.SortBy != "" {
+		clause = fmt.Sprintf(" ORDER BY %s %s", userSortColumns[f.SortBy], f.SortOrder)
+		if f.SortBy != "user" {
+			clause += ", user ASC"
+		}
+	}
+	if f.Limit > 0 {
+		clause += fmt.Sprintf(" LIMIT %d OFFSET %d", f.Limit, f.Offset)
+	} else if f.Offset > 0 {
+		clause += fmt.Sprintf(" LIMIT -1 OFFSET %d", f.Offset)
+	}
+	return clause
+}
@@ -0,0 +1,793 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"v2ray-stat/config"
+	"v2ray-stat/coreapi"
+	"v2ray-stat/db"
+	"v2ray-stat/db/manager"
+	"v2ray-stat/util"
+)
+
+// maxImportBodySize limits the size of an import request body.
+const maxImportBodySize = 32 << 20
+
+// subTokenRegex matches the subscription tokens accepted by the import.
+var subTokenRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{16,64}$`)
+
+// userImportRecord is a user of an import in the format of UserExport. Omitted attributes are
+// left unchanged for existing users and take their defaults for new users. The user is added to
+// the listed inbounds they are not in yet, with the given credential or a generated one if it is
+// empty; inbounds that are not listed are left unchanged.
+type userImportRecord struct {
+	User            string          `json:"user"`
+	Enabled         *bool           `json:"enabled"`
+	SubEnd          *string         `json:"sub_end"`
+	Renew           *int            `json:"renew"`
+	LimIP           *int            `json:"lim_ip"`
+	TrafficLimit    *sizeValue      `json:"traffic_limit"`
+	UplinkLimit     *sizeValue      `json:"uplink_limit"`
+	DownlinkLimit   *sizeValue      `json:"downlink_limit"`
+	ResetPolicy     *string         `json:"reset_policy"`
+	ResetDay        *int            `json:"reset_day"`
+	Uplink          *sizeValue      `json:"uplink"`
+	Downlink        *sizeValue      `json:"downlink"`
+	SubToken        *string         `json:"sub_token"`
+	Notes           *string         `json:"notes"`
+	Tags            *[]string       `json:"tags"`
+	ContactTelegram *string         `json:"contact_telegram"`
+	ContactEmail    *string         `json:"contact_email"`
+	Inbounds        []InboundClient `json:"inbounds"`
+}
+
+// UserImportResult is the outcome of the import of one user.
+type UserImportResult struct {
+	User    string   `json:"user"`
+	Status  string   `json:"status"` // "created", "updated", "unchanged" or "error"
+	Changes []string `json:"changes,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// UserImportReport is the per-user report of an import. With dry_run nothing is changed and
+// the report shows what the import would do.
+type UserImportReport struct {
+	DryRun    bool               `json:"dry_run"`
+	Total     int                `json:"total"`
+	Created   int                `json:"created"`
+	Updated   int                `json:"updated"`
+	Unchanged int                `json:"unchanged"`
+	Failed    int                `json:"failed"`
+	Results   []UserImportResult `json:"results"`
+}
+
+// userImportPlan holds the changes of one imported user that are applied after the config
+// files are written.
+type userImportPlan struct {
+	result      int // Index in the report
+	user        string
+	created     bool // Not in clients_stats yet
+	update      db.UserUpdate
+	credentials bool // Clients were added or got new credentials
+	live        []liveClient
+	toggle      *bool // Move the user between config.json and .disabled_users
+}
+
+// liveClient is a client to add to a running Xray inbound, replacing the client of the same user.
+type liveClient struct {
+	inbound config.XrayInbound
+	client  config.XrayClient
+	replace bool
+}
+
+// parseImportCSV reads import records from CSV with a header of userExportColumns. Missing
+// columns are left unchanged, as are empty numbers, booleans, sub_token and reset_policy.
+func parseImportCSV(reader io.Reader) ([]userImportRecord, error) {
+	rows, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("CSV header is missing")
+	}
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(userExportColumns, name) {
+			return nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["user"]; !ok {
+		return nil, fmt.Errorf("CSV column user is required")
+	}
+
+	records := make([]userImportRecord, 0, len(rows)-1)
+	for n, row := range rows[1:] {
+		line := n + 2
+		var parseErr error
+		fail := func(name, value string) {
+			if parseErr == nil {
+				parseErr = fmt.Errorf("line %d: invalid %s %q", line, name, value)
+			}
+		}
+		value := func(name string) (string, bool) {
+			i, ok := columns[name]
+			if !ok {
+				return "", false
+			}
+			return strings.TrimSpace(row[i]), true
+		}
+		text := func(name string, emptyOmitted bool) *string {
+			v, ok := value(name)
+			if !ok || (emptyOmitted && v == "") {
+				return nil
+			}
+			return &v
+		}
+		number := func(name string) *int {
+			v, ok := value(name)
+			if !ok || v == "" {
+				return nil
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				fail(name, v)
+			}
+			return &n
+		}
+		size := func(name string) *sizeValue {
+			v, ok := value(name)
+			if !ok || v == "" {
+				return nil
+			}
+			n, err := util.ParseDataSize(v)
+			if err != nil {
+				fail(name, v)
+			}
+			s := sizeValue(n)
+			return &s
+		}
+
+		user, _ := value("user")
+		record := userImportRecord{
+			User:            user,
+			SubEnd:          text("sub_end", false),
+			Renew:           number("renew"),
+			LimIP:           number("lim_ip"),
+			TrafficLimit:    size("traffic_limit"),
+			UplinkLimit:     size("uplink_limit"),
+			DownlinkLimit:   size("downlink_limit"),
+			ResetPolicy:     text("reset_policy", true),
+			ResetDay:        number("reset_day"),
+			Uplink:          size("uplink"),
+			Downlink:        size("downlink"),
+			SubToken:        text("sub_token", true),
+			Notes:           text("notes", false),
+			ContactTelegram: text("contact_telegram", false),
+			ContactEmail:    text("contact_email", false),
+		}
+		if v, ok := value("enabled"); ok && v != "" {
+			enabled, err := strconv.ParseBool(v)
+			if err != nil {
+				fail("enabled", v)
+			}
+			record.Enabled = &enabled
+		}
+		if v, ok := value("tags"); ok {
+			tags := []string{}
+			if v != "" {
+				tags = strings.Split(v, ";")
+			}
+			record.Tags = &tags
+		}
+		if v, ok := value("inbounds"); ok {
+			for _, pair := range strings.Split(v, ";") {
+				if pair = strings.TrimSpace(pair); pair != "" {
+					tag, credential, _ := strings.Cut(pair, "=")
+					record.Inbounds = append(record.Inbounds, InboundClient{Tag: strings.TrimSpace(tag), Credential: strings.TrimSpace(credential)})
+				}
+			}
+		}
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// userUpdate validates the attributes of the record and returns the database update of the
+// attributes that differ from the current user, with their names.
+func (rec userImportRecord) userUpdate(current User) (db.UserUpdate, []string, error) {
+	var update db.UserUpdate
+	var changes []string
+	changed := func(name string) {
+		changes = append(changes, name)
+	}
+
+	if rec.Enabled != nil && strconv.FormatBool(*rec.Enabled) != current.Enabled {
+		update.Enabled = rec.Enabled
+		changed("enabled")
+	}
+	if rec.SubEnd != nil {
+		subEnd := strings.TrimSpace(*rec.SubEnd)
+		if subEnd != "" {
+			if _, err := db.ParseSubEnd(subEnd); err != nil {
+				return update, nil, fmt.Errorf("invalid sub_end %q, expected YYYY-MM-DD-HH or empty", subEnd)
+			}
+		}
+		if subEnd != current.Sub_end {
+			update.SubEndAt = &subEnd
+			changed("sub_end")
+		}
+	}
+	if rec.Renew != nil {
+		if *rec.Renew < 0 {
+			return update, nil, fmt.Errorf("renew cannot be negative")
+		}
+		if *rec.Renew != current.Renew {
+			update.Renew = rec.Renew
+			changed("renew")
+		}
+	}
+	if rec.LimIP != nil {
+		if *rec.LimIP < 0 || *rec.LimIP > 100 {
+			return update, nil, fmt.Errorf("lim_ip must be between 0 and 100")
+		}
+		if *rec.LimIP != current.Lim_ip {
+			update.LimIP = rec.LimIP
+			changed("lim_ip")
+		}
+	}
+
+	for _, field := range []struct {
+		name    string
+		value   *sizeValue
+		current int64
+		dest    **int64
+	}{
+		{"traffic_limit", rec.TrafficLimit, current.Traffic_limit, &update.TrafficLimit},
+		{"uplink_limit", rec.UplinkLimit, current.Uplink_limit, &update.UplinkLimit},
+		{"downlink_limit", rec.DownlinkLimit, current.Downlink_limit, &update.DownlinkLimit},
+		{"uplink", rec.Uplink, current.Uplink, &update.Uplink},
+		{"downlink", rec.Downlink, current.Downlink, &update.Downlink},
+	} {
+		if field.value == nil {
+			continue
+		}
+		size := int64(*field.value)
+		if size < 0 {
+			return update, nil, fmt.Errorf("%s cannot be negative", field.name)
+		}
+		if size != field.current {
+			*field.dest = &size
+			changed(field.name)
+		}
+	}
+
+	if rec.ResetPolicy == nil && rec.ResetDay != nil {
+		return update, nil, fmt.Errorf("reset_day requires reset_policy")
+	}
+	if rec.ResetPolicy != nil {
+		day := 0
+		if rec.ResetDay != nil {
+			day = *rec.ResetDay
+		}
+		day, err := db.ValidateResetPolicy(*rec.ResetPolicy, day)
+		if err != nil {
+			return update, nil, err
+		}
+		if *rec.ResetPolicy != current.Reset_policy || day != current.Reset_day {
+			update.ResetPolicy, update.ResetDay = rec.ResetPolicy, &day
+			changed("reset_policy")
+		}
+	}
+
+	if rec.SubToken != nil {
+		if !subTokenRegex.MatchString(*rec.SubToken) {
+			return update, nil, fmt.Errorf("invalid sub_token, expected 16 to 64 letters, digits, - or _")
+		}
+		if *rec.SubToken != current.Sub_token {
+			update.SubToken = rec.SubToken
+			changed("sub_token")
+		}
+	}
+
+	if rec.Notes != nil {
+		if err := db.ValidateNotes(*rec.Notes); err != nil {
+			return update, nil, err
+		}
+		if *rec.Notes != current.Notes {
+			update.Notes = rec.Notes
+			changed("notes")
+		}
+	}
+	if rec.Tags != nil {
+		tags, err := db.NormalizeTags(*rec.Tags)
+		if err != nil {
+			return update, nil, err
+		}
+		if !slices.Equal(tags, current.Tags) {
+			if tags == nil {
+				tags = []string{}
+			}
+			update.Tags = tags
+			changed("tags")
+		}
+	}
+	if rec.ContactTelegram != nil {
+		if err := db.ValidateContactTelegram(*rec.ContactTelegram); err != nil {
+			return update, nil, err
+		}
+		if *rec.ContactTelegram != current.Contact_telegram {
+			update.ContactTelegram = rec.ContactTelegram
+			changed("contact_telegram")
+		}
+	}
+	if rec.ContactEmail != nil {
+		if err := db.ValidateContactEmail(*rec.ContactEmail); err != nil {
+			return update, nil, err
+		}
+		if *rec.ContactEmail != current.Contact_email {
+			update.ContactEmail = rec.ContactEmail
+			changed("contact_email")
+		}
+	}
+	return update, changes, nil
+}
+
+// inbound returns the protocol and Shadowsocks method of an inbound of config.json that supports users.
+func (f *coreConfigFiles) inbound(tag string) (protocol, method string, err error) {
+	found := false
+	for _, inbound := range f.xray.Inbounds {
+		if inbound.Tag == tag {
+			protocol, found = inbound.Protocol, true
+			if inbound.Settings.Method != nil {
+				method = *inbound.Settings.Method
+			}
+			break
+		}
+	}
+	for _, inbound := range f.singbox.Inbounds {
+		if inbound.Tag == tag {
+			protocol, method, found = inbound.Type, inbound.Method, true
+			break
+		}
+	}
+	if !found {
+		return "", "", fmt.Errorf("inbound with tag %s not found", tag)
+	}
+	if !config.IsUserProtocol(protocol) {
+		return "", "", fmt.Errorf("inbound %s uses unsupported protocol %s", tag, protocol)
+	}
+	return protocol, method, nil
+}
+
+// credentialOwner returns the user that has a credential in an inbound of either file, or "".
+func (f *coreConfigFiles) credentialOwner(tag, protocol, credential string, cfg *config.Config) string {
+	wanted := InboundClient{Protocol: protocol, Credential: credential}.DBCredential("", cfg)
+	owner := ""
+	f.clients(func(name string, client userClient) {
+		if owner == "" && client.Tag == tag && client.inboundClient().DBCredential(name, cfg) == wanted {
+			owner = name
+		}
+	})
+	return owner
+}
+
+// addUserClient adds a client of a user to an inbound of config.json or, for a disabled user,
+// of .disabled_users, creating the inbound there from config.json if needed. The returned
+// client is valid until the next client is added.
+func (f *coreConfigFiles) addUserClient(tag, protocol, user, credential string, disabled bool) userClient {
+	result := userClient{Tag: tag, Protocol: protocol, Disabled: disabled}
+
+	if f.coreType == "xray" {
+		inbounds := &f.xray.Inbounds
+		if disabled {
+			inbounds = &f.xrayDisabled.Inbounds
+		}
+		i := slices.IndexFunc(*inbounds, func(inbound config.XrayInbound) bool { return inbound.Tag == tag })
+		if i < 0 {
+			source := f.xray.Inbounds[slices.IndexFunc(f.xray.Inbounds, func(inbound config.XrayInbound) bool { return inbound.Tag == tag })]
+			source.Settings.Clients = []config.XrayClient{}
+			*inbounds = append(*inbounds, source)
+			i = len(*inbounds) - 1
+		}
+		inbound := &(*inbounds)[i]
+		client := config.NewXrayClient(protocol, user, credential)
+		for _, c := range inbound.Settings.Clients {
+			if client.Flow == "" && c.Flow != "" {
+				client.Flow = c.Flow
+			}
+		}
+		inbound.Settings.Clients = append(inbound.Settings.Clients, client)
+		result.xrayInbound, result.xray = inbound, &inbound.Settings.Clients[len(inbound.Settings.Clients)-1]
+		return result
+	}
+
+	inbounds := &f.singbox.Inbounds
+	if disabled {
+		inbounds = &f.singboxDisabled.Inbounds
+	}
+	i := slices.IndexFunc(*inbounds, func(inbound config.SingboxInbound) bool { return inbound.Tag == tag })
+	if i < 0 {
+		source := f.singbox.Inbounds[slices.IndexFunc(f.singbox.Inbounds, func(inbound config.SingboxInbound) bool { return inbound.Tag == tag })]
+		source.Users = []config.SingboxClient{}
+		*inbounds = append(*inbounds, source)
+		i = len(*inbounds) - 1
+	}
+	inbound := &(*inbounds)[i]
+	newUser := config.NewSingboxClient(protocol, user, credential)
+	for _, u := range inbound.Users {
+		if newUser.Flow == "" && u.Flow != "" {
+			newUser.Flow = u.Flow
+		}
+	}
+	inbound.Users = append(inbound.Users, newUser)
+	result.singbox = &inbound.Users[len(inbound.Users)-1]
+	return result
+}
+
+// planUserImport validates a record and applies its inbound changes to the config files in
+// memory. It returns the plan of the remaining changes and the names of all changes.
+func planUserImport(files *coreConfigFiles, current *User, rec userImportRecord, cfg *config.Config) (userImportPlan, []string, error) {
+	plan := userImportPlan{user: rec.User, created: current == nil}
+	if current == nil {
+		current = &User{Enabled: "true", Reset_policy: db.ResetPolicyNone}
+	}
+
+	update, changes, err := rec.userUpdate(*current)
+	if err != nil {
+		return plan, nil, err
+	}
+	plan.update = update
+
+	// Resolve the credentials of all inbounds before changing anything
+	type inboundChange struct {
+		tag, protocol, credential string
+		existing                  *userClient
+	}
+	clients := files.userClients(rec.User)
+	credentialFor, _ := credentialGenerator("", cfg)
+	var inboundChanges []inboundChange
+	seen := make(map[string]bool)
+	for _, in := range rec.Inbounds {
+		if in.Tag == "" {
+			return plan, nil, fmt.Errorf("inbound tag is required")
+		}
+		if seen[in.Tag] {
+			return plan, nil, fmt.Errorf("inbound %s is listed more than once", in.Tag)
+		}
+		seen[in.Tag] = true
+		if len(in.Credential) > maxCredentialLength {
+			return plan, nil, fmt.Errorf("credential for inbound %s too long (max %d characters)", in.Tag, maxCredentialLength)
+		}
+		protocol, method, err := files.inbound(in.Tag)
+		if err != nil {
+			return plan, nil, err
+		}
+		if in.Protocol != "" && in.Protocol != protocol {
+			return plan, nil, fmt.Errorf("inbound %s uses protocol %s, not %s", in.Tag, protocol, in.Protocol)
+		}
+
+		change := inboundChange{tag: in.Tag, protocol: protocol, credential: in.Credential}
+		if i := slices.IndexFunc(clients, func(c userClient) bool { return c.Tag == in.Tag }); i >= 0 {
+			if in.Credential == "" || in.Credential == clients[i].inboundClient().Credential {
+				continue
+			}
+			change.existing = &clients[i]
+		}
+		if change.credential == "" {
+			if change.credential, err = credentialFor(protocol, method); err != nil {
+				return plan, nil, err
+			}
+		} else if err := config.ValidateCredential(protocol, method, change.credential); err != nil {
+			return plan, nil, fmt.Errorf("credential for inbound %s: %v", in.Tag, err)
+		}
+		if owner := files.credentialOwner(in.Tag, protocol, change.credential, cfg); owner != "" && owner != rec.User {
+			return plan, nil, fmt.Errorf("credential for inbound %s is already used by user %s", in.Tag, owner)
+		}
+		inboundChanges = append(inboundChanges, change)
+	}
+	if len(clients) == 0 && len(inboundChanges) == 0 {
+		return plan, nil, fmt.Errorf("inbounds are required for a user that is not in the configuration")
+	}
+
+	// New clients go where the other clients of the user are, then the user is moved if needed
+	enabled := true
+	if len(clients) > 0 {
+		enabled = slices.ContainsFunc(clients, func(c userClient) bool { return !c.Disabled })
+	} else if rec.Enabled != nil {
+		enabled = *rec.Enabled
+	}
+	if rec.Enabled != nil && *rec.Enabled != enabled {
+		plan.toggle = rec.Enabled
+		if !slices.Contains(changes, "enabled") {
+			changes = append(changes, "enabled")
+		}
+	}
+
+	// Existing clients are changed before new ones are appended, which may move the clients in memory
+	for _, change := range inboundChanges {
+		if change.existing == nil {
+			continue
+		}
+		change.existing.setCredential(change.credential)
+		changes = append(changes, "credential:"+change.tag)
+		plan.credentials = true
+		if change.existing.xray != nil && !change.existing.Disabled {
+			plan.live = append(plan.live, liveClient{inbound: *change.existing.xrayInbound, client: *change.existing.xray, replace: true})
+		}
+	}
+	for _, change := range inboundChanges {
+		if change.existing != nil {
+			continue
+		}
+		client := files.addUserClient(change.tag, change.protocol, rec.User, change.credential, !enabled)
+		changes = append(changes, "inbound:"+change.tag)
+		plan.credentials = true
+		if client.xray != nil && enabled {
+			plan.live = append(plan.live, liveClient{inbound: *client.xrayInbound, client: *client.xray})
+		}
+	}
+	return plan, changes, nil
+}
+
+// importUsers creates or updates users from import records and reports the outcome for each
+// of them. The config files are written once; with dryRun nothing is changed.
+func importUsers(manager *manager.DatabaseManager, cfg *config.Config, records []userImportRecord, dryRun bool) (UserImportReport, error) {
+	report := UserImportReport{DryRun: dryRun, Results: make([]UserImportResult, 0, len(records))}
+
+	users, _, err := queryUsers(manager, cfg, userFilter{})
+	if err != nil {
+		return report, err
+	}
+	existing := make(map[string]*User, len(users))
+	tokens := make(map[string]string, len(users))
+	for i := range users {
+		existing[users[i].User] = &users[i]
+		if users[i].Sub_token != "" {
+			tokens[users[i].Sub_token] = users[i].User
+		}
+	}
+	// The files stay locked until they are saved; enabling and disabling users below locks them again
+	unlock, err := config.LockCoreFiles(cfg)
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+	files, err := readCoreConfigFiles(cfg)
+	if err != nil {
+		return report, err
+	}
+
+	var plans []userImportPlan
+	seen := make(map[string]bool)
+	configChanged := false
+	for _, rec := range records {
+		rec.User = strings.TrimSpace(rec.User)
+		result := UserImportResult{User: rec.User}
+		var plan userImportPlan
+		var err error
+		switch {
+		case rec.User == "":
+			err = fmt.Errorf("user is required")
+		case len(rec.User) > 40:
+			err = fmt.Errorf("user too long (max 40 characters)")
+		case seen[rec.User]:
+			err = fmt.Errorf("user is listed more than once")
+		case rec.SubToken != nil && tokens[*rec.SubToken] != "" && tokens[*rec.SubToken] != rec.User:
+			err = fmt.Errorf("sub_token is already used by user %s", tokens[*rec.SubToken])
+		default:
+			plan, result.Changes, err = planUserImport(files, existing[rec.User], rec, cfg)
+		}
+		seen[rec.User] = true
+
+		switch {
+		case err != nil:
+			result.Status, result.Error = "error", err.Error()
+			report.Failed++
+		case plan.created:
+			result.Status = "created"
+			report.Created++
+		case len(result.Changes) > 0:
+			result.Status = "updated"
+			report.Updated++
+		default:
+			result.Status = "unchanged"
+			report.Unchanged++
+		}
+		if err == nil && len(result.Changes) > 0 {
+			if rec.SubToken != nil {
+				tokens[*rec.SubToken] = rec.User
+			}
+			configChanged = configChanged || plan.credentials
+			plan.result = len(report.Results)
+			plans = append(plans, plan)
+		}
+		report.Results = append(report.Results, result)
+	}
+	report.Total = len(report.Results)
+	if dryRun {
+		return report, nil
+	}
+
+	if configChanged {
+		if err := files.save(cfg); err != nil {
+			return report, err
+		}
+	}
+	unlock()
+
+	fail := func(plan userImportPlan, err error) {
+		result := &report.Results[plan.result]
+		if result.Status == "error" {
+			return
+		}
+		switch result.Status {
+		case "created":
+			report.Created--
+		case "updated":
+			report.Updated--
+		}
+		result.Status, result.Error = "error", err.Error()
+		report.Failed++
+	}
+
+	toggles := map[bool][]string{}
+	for _, plan := range plans {
+		if coreapi.LiveUpdateEnabled(cfg) {
+			for _, live := range plan.live {
+				if live.replace {
+					if err := coreapi.RemoveInboundUser(cfg, live.inbound.Tag, plan.user); err != nil {
+						cfg.Logger.Error("Failed to remove user from running core", "user", plan.user, "inboundTag", live.inbound.Tag, "error", err)
+						continue
+					}
+				}
+				if err := coreapi.AddInboundUser(cfg, live.inbound, live.client); err != nil {
+					cfg.Logger.Error("Failed to add user to running core", "user", plan.user, "inboundTag", live.inbound.Tag, "error", err)
+				}
+			}
+		}
+		if plan.credentials {
+			clients := files.userClients(plan.user)
+			inboundClients := make([]InboundClient, len(clients))
+			for i, client := range clients {
+				inboundClients[i] = client.inboundClient()
+			}
+			replaceAuthLuaUser(cfg, plan.user, plan.user, inboundClients)
+		}
+		if plan.toggle != nil {
+			toggles[*plan.toggle] = append(toggles[*plan.toggle], plan.user)
+		}
+	}
+	toggleFailed := make(map[string]error)
+	for enabled, users := range toggles {
+		failed, err := db.ToggleUsersEnabled(manager, cfg, users, enabled)
+		if err != nil {
+			for _, user := range users {
+				toggleFailed[user] = err
+			}
+			continue
+		}
+		for user, err := range failed {
+			toggleFailed[user] = err
+		}
+	}
+
+	var checks db.UserUpdate
+	for _, plan := range plans {
+		if err := toggleFailed[plan.user]; err != nil {
+			fail(plan, err)
+			continue
+		}
+
+		var err error
+		clients := files.userClients(plan.user)
+		credential := clients[0].inboundClient().DBCredential(plan.user, cfg)
+		if plan.created {
+			err = db.EnsureUserInDB(manager, cfg, plan.user, credential)
+		} else if existing[plan.user].Uuid != credential {
+			err = db.UpdateUserCredential(manager, cfg, plan.user, credential)
+		}
+		if err == nil {
+			err = db.UpdateUser(manager, cfg, plan.user, plan.update)
+		}
+		if err != nil {
+			if errors.Is(err, db.ErrUserNotFound) {
+				err = fmt.Errorf("user %s not found in database", plan.user)
+			}
+			fail(plan, err)
+			continue
+		}
+
+		// Collect the attributes that require subscriptions or traffic limits to be re-evaluated
+		if plan.update.SubEndAt != nil {
+			checks.SubEndAt = plan.update.SubEndAt
+		}
+		if plan.update.TrafficLimit != nil {
+			checks.TrafficLimit = plan.update.TrafficLimit
+		}
+		if plan.update.UplinkLimit != nil {
+			checks.UplinkLimit = plan.update.UplinkLimit
+		}
+		if plan.update.DownlinkLimit != nil {
+			checks.DownlinkLimit = plan.update.DownlinkLimit
+		}
+		if plan.update.Uplink != nil || plan.update.Downlink != nil {
+			checks.Uplink = plan.update.Uplink
+			checks.Downlink = plan.update.Downlink
+		}
+	}
+	applyUserChecks(manager, cfg, checks)
+	return report, nil
+}
+
+// ImportUsersHandler creates or updates users from a JSON array or, with format=csv or a text/csv
+// body, a CSV file in the export format. With dry_run=true nothing is changed. The response is
+// a per-user report.
+func ImportUsersHandler(manager *manager.DatabaseManager, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg.Logger.Debug("Starting ImportUsersHandler request processing")
+
+		if r.Method != http.MethodPost {
+			cfg.Logger.Warn("Invalid HTTP method", "method", r.Method)
+			http.Error(w, "Method not allowed, use POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		dryRun, ok := parseDryRun(w, r, cfg)
+		if !ok {
+			return
+		}
+		format := query.Get("format")
+		if format == "" {
+			format = "json"
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+				format = "csv"
+			}
+		}
+
+		body := http.MaxBytesReader(w, r.Body, maxImportBodySize)
+		var records []userImportRecord
+		var err error
+		switch format {
+		case "json":
+			decoder := json.NewDecoder(body)
+			decoder.DisallowUnknownFields()
+			if err = decoder.Decode(&records); err != nil {
+				err = fmt.Errorf("invalid JSON body: %v", err)
+			}
+		case "csv":
+			records, err = parseImportCSV(body)
+		default:
+			err = fmt.Errorf("invalid format, must be json or csv")
+		}
+		if err != nil {
+			cfg.Logger.Warn("Invalid import body", "format", format, "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		report, err := importUsers(manager, cfg, records, dryRun)
+		if err != nil {
+			cfg.Logger.Error("Failed to import users", "error", err)
+			http.Error(w, fmt.Sprintf("Failed to import users: %v", err), configWriteStatus(err, http.StatusInternalServerError))
+			return
+		}
+
+		writeJSON(w, cfg, http.StatusOK, report)
+		cfg.Logger.Info("API users/import: import completed", "dry_run", dryRun, "created", report.Created, "updated", report.Updated, "unchanged", report.Unchanged, "failed", report.Failed)
+	}
+}
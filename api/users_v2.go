@@ -0,0 +1,485 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db"
+	"v2ray-stat/db/manager"
+	"v2ray-stat/util"
+)
+
+// usersV2Path is the path of the RESTful user resource; a single user is addressed as usersV2Path/<user>.
+const usersV2Path = "/api/v2/users"
+
+// maxUserBodySize limits the size of a JSON request body of the user resource.
+const maxUserBodySize = 1 << 20
+
+// APIError is the JSON body of an error response of the v2 API.
+type APIError struct {
+	Error string `json:"error"`
+}
+
+// sizeValue is a data size in bytes decoded from a JSON number or a string like "100GB" or "500MiB".
+type sizeValue int64
+
+// UnmarshalJSON decodes a data size from a JSON number or string.
+func (s *sizeValue) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		var number int64
+		if err := json.Unmarshal(data, &number); err != nil {
+			return fmt.Errorf("must be bytes or a size like 500MiB, 100GB")
+		}
+		value = fmt.Sprint(number)
+	}
+	size, err := util.ParseDataSize(value)
+	if err != nil {
+		return fmt.Errorf("must be bytes or a size like 500MiB, 100GB")
+	}
+	*s = sizeValue(size)
+	return nil
+}
+
+// userV2Request is the JSON body of POST and PATCH requests to the user resource.
+// user, inbound, inbounds and credential are only accepted on creation.
+type userV2Request struct {
+	User          string     `json:"user"`
+	Inbound       string     `json:"inbound"`
+	Inbounds      []string   `json:"inbounds"` // Several inbound tags or ["all"]
+	Credential    string     `json:"credential"`
+	SubEnd        *string    `json:"sub_end"`
+	Renew         *int       `json:"renew"`
+	LimIP         *int       `json:"lim_ip"`
+	Enabled       *bool      `json:"enabled"`
+	TrafficLimit  *sizeValue `json:"traffic_limit"`
+	UplinkLimit   *sizeValue `json:"uplink_limit"`
+	DownlinkLimit *sizeValue `json:"downlink_limit"`
+	ResetPolicy   *string    `json:"reset_policy"`
+	ResetDay      *int       `json:"reset_day"`
+
+	Notes           *string   `json:"notes"`
+	Tags            *[]string `json:"tags"`
+	ContactTelegram *string   `json:"contact_telegram"`
+	ContactEmail    *string   `json:"contact_email"`
+}
+
+// userUpdate validates the attributes of the request and converts them to a database update.
+func (req userV2Request) userUpdate() (db.UserUpdate, error) {
+	update := db.UserUpdate{
+		SubEnd:  req.SubEnd,
+		Renew:   req.Renew,
+		LimIP:   req.LimIP,
+		Enabled: req.Enabled,
+	}
+
+	if req.SubEnd != nil {
+		if err := db.ValidateDateOffset(*req.SubEnd); err != nil {
+			return update, err
+		}
+	}
+	if req.Renew != nil && *req.Renew < 0 {
+		return update, fmt.Errorf("renew cannot be negative")
+	}
+	if req.LimIP != nil && (*req.LimIP < 0 || *req.LimIP > 100) {
+		return update, fmt.Errorf("lim_ip must be between 0 and 100")
+	}
+
+	for _, field := range []struct {
+		name  string
+		value *sizeValue
+		dest  **int64
+	}{
+		{"traffic_limit", req.TrafficLimit, &update.TrafficLimit},
+		{"uplink_limit", req.UplinkLimit, &update.UplinkLimit},
+		{"downlink_limit", req.DownlinkLimit, &update.DownlinkLimit},
+	} {
+		if field.value == nil {
+			continue
+		}
+		size := int64(*field.value)
+		if size < 0 {
+			return update, fmt.Errorf("%s cannot be negative", field.name)
+		}
+		*field.dest = &size
+	}
+
+	if req.ResetPolicy == nil && req.ResetDay != nil {
+		return update, fmt.Errorf("reset_day requires reset_policy")
+	}
+	if req.ResetPolicy != nil {
+		day := 0
+		if req.ResetDay != nil {
+			day = *req.ResetDay
+		}
+		day, err := db.ValidateResetPolicy(*req.ResetPolicy, day)
+		if err != nil {
+			return update, err
+		}
+		update.ResetPolicy, update.ResetDay = req.ResetPolicy, &day
+	}
+
+	if req.Notes != nil {
+		if err := db.ValidateNotes(*req.Notes); err != nil {
+			return update, err
+		}
+		update.Notes = req.Notes
+	}
+	if req.Tags != nil {
+		tags, err := db.NormalizeTags(*req.Tags)
+		if err != nil {
+			return update, err
+		}
+		update.Tags = tags
+	}
+	if req.ContactTelegram != nil {
+		if err := db.ValidateContactTelegram(*req.ContactTelegram); err != nil {
+			return update, err
+		}
+		update.ContactTelegram = req.ContactTelegram
+	}
+	if req.ContactEmail != nil {
+		if err := db.ValidateContactEmail(*req.ContactEmail); err != nil {
+			return update, err
+		}
+		update.ContactEmail = req.ContactEmail
+	}
+	return update, nil
+}
+
+// writeJSON writes v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, cfg *config.Config, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		cfg.Logger.Error("Failed to encode JSON", "error", err)
+	}
+}
+
+// writeJSONError writes an error response of the v2 API.
+func writeJSONError(w http.ResponseWriter, cfg *config.Config, status int, message string) {
+	writeJSON(w, cfg, status, APIError{Error: message})
+}
+
+// decodeUserRequest decodes a JSON request body of the user resource, rejecting unknown fields.
+func decodeUserRequest(w http.ResponseWriter, r *http.Request) (userV2Request, error) {
+	var req userV2Request
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUserBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, fmt.Errorf("invalid JSON body: %v", err)
+	}
+	return req, nil
+}
+
+// getUserV2 returns a user from the database, or nil if the user does not exist.
+func getUserV2(manager *manager.DatabaseManager, cfg *config.Config, userIdentifier string) (*User, error) {
+	users, _, err := queryUsers(manager, cfg, userFilter{User: userIdentifier})
+	if err != nil || len(users) == 0 {
+		return nil, err
+	}
+	return &users[0], nil
+}
+
+// applyUserChecks re-evaluates subscriptions and traffic limits after the corresponding attributes changed.
+func applyUserChecks(manager *manager.DatabaseManager, cfg *config.Config, update db.UserUpdate) {
+	if update.SubEnd != nil || update.SubEndAt != nil {
+		if err := db.CheckExpiredSubscriptions(manager, cfg); err != nil {
+			cfg.Logger.Error("Failed to check expired subscriptions", "error", err)
+		}
+	}
+	if update.TrafficLimit != nil || update.UplinkLimit != nil || update.DownlinkLimit != nil || update.Uplink != nil || update.Downlink != nil {
+		if err := db.CheckTrafficLimits(manager, cfg); err != nil {
+			cfg.Logger.Error("Failed to apply traffic limits", "error", err)
+		}
+	}
+}
+
+// UsersV2Handler serves the user resource: GET lists all users or returns one user, POST creates
+// a fully configured user, PATCH partially updates a user and DELETE removes it. POST to
+// usersV2Path/batch applies one action to several users. Changes require the API token; all
+// errors are returned as JSON.
+func UsersV2Handler(manager *manager.DatabaseManager, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg.Logger.Debug("Starting UsersV2Handler request processing", "method", r.Method, "path", r.URL.Path)
+
+		userIdentifier := strings.Trim(strings.TrimPrefix(r.URL.Path, usersV2Path), "/")
+		if strings.Contains(userIdentifier, "/") {
+			writeJSONError(w, cfg, http.StatusNotFound, "Not found")
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			if message, ok := verifyToken(r, cfg); !ok {
+				writeJSONError(w, cfg, http.StatusUnauthorized, message)
+				return
+			}
+		}
+
+		switch {
+		case r.Method == http.MethodGet && userIdentifier == "":
+			listUsersV2(w, r, manager, cfg)
+		case r.Method == http.MethodGet:
+			getUserV2Handler(w, r, manager, cfg, userIdentifier)
+		case r.Method == http.MethodPost && userIdentifier == "":
+			createUserV2(w, r, manager, cfg)
+		case r.Method == http.MethodPost && userIdentifier == usersBatchName:
+			batchUsersV2(w, r, manager, cfg)
+		case r.Method == http.MethodPatch && userIdentifier != "":
+			updateUserV2(w, r, manager, cfg, userIdentifier)
+		case r.Method == http.MethodDelete && userIdentifier != "":
+			deleteUserV2(w, r, manager, cfg, userIdentifier)
+		default:
+			cfg.Logger.Warn("Invalid HTTP method", "method", r.Method, "path", r.URL.Path)
+			if userIdentifier == "" {
+				w.Header().Set("Allow", "GET, POST")
+			} else {
+				w.Header().Set("Allow", "GET, PATCH, DELETE")
+			}
+			writeJSONError(w, cfg, http.StatusMethodNotAllowed, fmt.Sprintf("Method %s not allowed", r.Method))
+		}
+	}
+}
+
+// UserListV2 is a page of the user list with the total number of matching users.
+type UserListV2 struct {
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit,omitempty"`
+	Offset int    `json:"offset"`
+	Users  []User `json:"users"`
+}
+
+// listUsersV2 writes a filtered, sorted and paginated list of users.
+func listUsersV2(w http.ResponseWriter, r *http.Request, manager *manager.DatabaseManager, cfg *config.Config) {
+	filter, err := parseUserFilter(r, cfg)
+	if err != nil {
+		cfg.Logger.Warn("Invalid user list parameters", "error", err)
+		writeJSONError(w, cfg, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	users, total, err := queryUsers(manager, cfg, filter)
+	if err != nil {
+		writeJSONError(w, cfg, http.StatusInternalServerError, "Error processing data")
+		return
+	}
+	if users == nil {
+		users = []User{}
+	}
+	hideSubTokens(r, cfg, users)
+	writeJSON(w, cfg, http.StatusOK, UserListV2{Total: total, Limit: filter.Limit, Offset: filter.Offset, Users: users})
+	cfg.Logger.Info("API v2 users: list completed successfully", "users_count", len(users), "total", total)
+}
+
+// getUserV2Handler writes a single user.
+func getUserV2Handler(w http.ResponseWriter, r *http.Request, manager *manager.DatabaseManager, cfg *config.Config, userIdentifier string) {
+	user, err := getUserV2(manager, cfg, userIdentifier)
+	if err != nil {
+		writeJSONError(w, cfg, http.StatusInternalServerError, "Error processing data")
+		return
+	}
+	if user == nil {
+		writeJSONError(w, cfg, http.StatusNotFound, fmt.Sprintf("User %s not found", userIdentifier))
+		return
+	}
+	if !hasToken(r, cfg) {
+		user.Sub_token = ""
+	}
+	writeJSON(w, cfg, http.StatusOK, user)
+}
+
+// createUserV2 adds a user to the core configuration and the database with all attributes
+// from the request. If any step fails, the user is removed again.
+func createUserV2(w http.ResponseWriter, r *http.Request, manager *manager.DatabaseManager, cfg *config.Config) {
+	req, err := decodeUserRequest(w, r)
+	if err != nil {
+		cfg.Logger.Warn("Invalid request body", "error", err)
+		writeJSONError(w, cfg, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userIdentifier := req.User
+	if userIdentifier == "" {
+		writeJSONError(w, cfg, http.StatusBadRequest, "user is required")
+		return
+	}
+	if len(userIdentifier) > 40 || strings.Contains(userIdentifier, "/") {
+		writeJSONError(w, cfg, http.StatusBadRequest, "user must be at most 40 characters and must not contain '/'")
+		return
+	}
+	if len(req.Credential) > maxCredentialLength {
+		writeJSONError(w, cfg, http.StatusBadRequest, fmt.Sprintf("credential too long (max %d characters)", maxCredentialLength))
+		return
+	}
+	update, err := req.userUpdate()
+	if err != nil {
+		cfg.Logger.Warn("Invalid user attributes", "user", userIdentifier, "error", err)
+		writeJSONError(w, cfg, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	existing, err := getUserV2(manager, cfg, userIdentifier)
+	if err != nil {
+		writeJSONError(w, cfg, http.StatusInternalServerError, "Error processing data")
+		return
+	}
+	if existing != nil {
+		writeJSONError(w, cfg, http.StatusConflict, fmt.Sprintf("User %s already exists", userIdentifier))
+		return
+	}
+	// A user added to the config files is only synced to the database on the next tick
+	inConfig, err := userInConfig(userIdentifier, cfg)
+	if err != nil {
+		cfg.Logger.Error("Failed to read configuration", "user", userIdentifier, "error", err)
+		writeJSONError(w, cfg, http.StatusInternalServerError, "Error processing data")
+		return
+	}
+	if inConfig {
+		writeJSONError(w, cfg, http.StatusConflict, fmt.Sprintf("User %s already exists", userIdentifier))
+		return
+	}
+
+	if req.Inbound != "" && len(req.Inbounds) > 0 {
+		writeJSONError(w, cfg, http.StatusBadRequest, "inbound and inbounds cannot be used together")
+		return
+	}
+	inboundTags := req.Inbounds
+	if len(inboundTags) == 0 {
+		inboundTags = []string{req.Inbound}
+		if req.Inbound == "" {
+			inboundTags = []string{"vless-in"}
+		}
+	}
+
+	cfg.Logger.Debug("Adding user to configuration", "user", userIdentifier, "inboundTags", strings.Join(inboundTags, ","))
+	added, err := AddUserToInbounds(userIdentifier, req.Credential, inboundTags, cfg)
+	if err != nil {
+		cfg.Logger.Error("Failed to add user", "user", userIdentifier, "error", err)
+		writeJSONError(w, cfg, configWriteStatus(err, http.StatusBadRequest), err.Error())
+		return
+	}
+
+	rollback := func(reason error) {
+		cfg.Logger.Error("Failed to create user, rolling back", "user", userIdentifier, "error", reason)
+		for _, client := range added {
+			if err := DeleteUserFromConfig(userIdentifier, client.Tag, cfg); err != nil {
+				cfg.Logger.Error("Failed to remove user from configuration", "user", userIdentifier, "inboundTag", client.Tag, "error", err)
+			}
+		}
+		if err := db.DeleteUserFromDB(manager, cfg, userIdentifier); err != nil {
+			cfg.Logger.Error("Failed to remove user from database", "user", userIdentifier, "error", err)
+		}
+		writeJSONError(w, cfg, http.StatusInternalServerError, fmt.Sprintf("Failed to create user: %v", reason))
+	}
+
+	if err := db.EnsureUserInDB(manager, cfg, userIdentifier, added[0].DBCredential(userIdentifier, cfg)); err != nil {
+		rollback(err)
+		return
+	}
+	if req.Enabled != nil && !*req.Enabled {
+		if err := db.ToggleUserEnabled(manager, cfg, userIdentifier, false); err != nil {
+			rollback(err)
+			return
+		}
+	}
+	if err := db.UpdateUser(manager, cfg, userIdentifier, update); err != nil {
+		rollback(err)
+		return
+	}
+	applyUserChecks(manager, cfg, update)
+
+	user, err := getUserV2(manager, cfg, userIdentifier)
+	if err != nil || user == nil {
+		writeJSONError(w, cfg, http.StatusInternalServerError, "Error processing data")
+		return
+	}
+	writeJSON(w, cfg, http.StatusCreated, user)
+	cfg.Logger.Info("API v2 users: user created successfully", "user", userIdentifier, "inbounds", len(added))
+}
+
+// updateUserV2 applies the attributes present in the request to an existing user.
+func updateUserV2(w http.ResponseWriter, r *http.Request, manager *manager.DatabaseManager, cfg *config.Config, userIdentifier string) {
+	req, err := decodeUserRequest(w, r)
+	if err != nil {
+		cfg.Logger.Warn("Invalid request body", "error", err)
+		writeJSONError(w, cfg, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.User != "" || req.Inbound != "" || req.Inbounds != nil || req.Credential != "" {
+		writeJSONError(w, cfg, http.StatusBadRequest, "user, inbound, inbounds and credential cannot be changed")
+		return
+	}
+	update, err := req.userUpdate()
+	if err != nil {
+		cfg.Logger.Warn("Invalid user attributes", "user", userIdentifier, "error", err)
+		writeJSONError(w, cfg, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	existing, err := getUserV2(manager, cfg, userIdentifier)
+	if err != nil {
+		writeJSONError(w, cfg, http.StatusInternalServerError, "Error processing data")
+		return
+	}
+	if existing == nil {
+		writeJSONError(w, cfg, http.StatusNotFound, fmt.Sprintf("User %s not found", userIdentifier))
+		return
+	}
+
+	if req.Enabled != nil {
+		if err := db.ToggleUserEnabled(manager, cfg, userIdentifier, *req.Enabled); err != nil {
+			cfg.Logger.Error("Failed to toggle user status in configuration", "user", userIdentifier, "enabled", *req.Enabled, "error", err)
+			writeJSONError(w, cfg, configWriteStatus(err, http.StatusInternalServerError), fmt.Sprintf("Failed to toggle user status: %v", err))
+			return
+		}
+	}
+	if err := db.UpdateUser(manager, cfg, userIdentifier, update); err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			writeJSONError(w, cfg, http.StatusNotFound, fmt.Sprintf("User %s not found", userIdentifier))
+			return
+		}
+		writeJSONError(w, cfg, http.StatusInternalServerError, fmt.Sprintf("Failed to update user: %v", err))
+		return
+	}
+	applyUserChecks(manager, cfg, update)
+
+	user, err := getUserV2(manager, cfg, userIdentifier)
+	if err != nil || user == nil {
+		writeJSONError(w, cfg, http.StatusInternalServerError, "Error processing data")
+		return
+	}
+	writeJSON(w, cfg, http.StatusOK, user)
+	cfg.Logger.Info("API v2 users: user updated successfully", "user", userIdentifier)
+}
+
+// deleteUserV2 removes a user from the inbound given by the inbound query parameter, or from every
+// inbound if it is not set, and from the database.
+func deleteUserV2(w http.ResponseWriter, r *http.Request, manager *manager.DatabaseManager, cfg *config.Config, userIdentifier string) {
+	inboundTag := r.URL.Query().Get("inbound")
+
+	existing, err := getUserV2(manager, cfg, userIdentifier)
+	if err != nil {
+		writeJSONError(w, cfg, http.StatusInternalServerError, "Error processing data")
+		return
+	}
+	if existing == nil {
+		writeJSONError(w, cfg, http.StatusNotFound, fmt.Sprintf("User %s not found", userIdentifier))
+		return
+	}
+
+	if err := DeleteUserFromConfig(userIdentifier, inboundTag, cfg); err != nil {
+		cfg.Logger.Error("Failed to delete user", "user", userIdentifier, "error", err)
+		writeJSONError(w, cfg, configWriteStatus(err, http.StatusNotFound), err.Error())
+		return
+	}
+	if err := db.DelUserFromDB(manager, cfg); err != nil {
+		cfg.Logger.Error("Failed to remove user from database", "user", userIdentifier, "error", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	cfg.Logger.Info("API v2 users: user deleted successfully", "user", userIdentifier, "inboundTag", inboundTag)
+}
@@ -0,0 +1,299 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"v2ray-stat/config"
+)
+
+// Names of the core config files in a version.
+const (
+	ConfigFile        = "config.json"
+	DisabledUsersFile = ".disabled_users"
+	versionFile       = "version.json"
+	idFormat          = "20060102-150405.000000"
+)
+
+// ErrVersionNotFound is returned for an unknown version ID.
+var ErrVersionNotFound = errors.New("config version not found")
+
+// versionIDRegex matches version IDs, which are UTC timestamps in idFormat.
+var versionIDRegex = regexp.MustCompile(`^\d{8}-\d{6}\.\d{6}$`)
+
+// mu serializes changes to the backup directory.
+var mu sync.Mutex
+
+// Version is a saved copy of config.json and .disabled_users.
+type Version struct {
+	ID     string   `json:"id"`
+	Time   string   `json:"time"`
+	Reason string   `json:"reason"` // API call or event that changed the files
+	Files  []string `json:"files"`  // .disabled_users is missing if the file did not exist
+}
+
+// corePaths returns the paths of the core config files by their name in a version.
+func corePaths(cfg *config.Config) map[string]string {
+	return map[string]string{
+		ConfigFile:        cfg.Core.Config,
+		DisabledUsersFile: filepath.Join(cfg.Core.Dir, ".disabled_users"),
+	}
+}
+
+// readCurrent reads the current core config files; a missing .disabled_users is left out.
+func readCurrent(cfg *config.Config) (map[string][]byte, error) {
+	unlock, err := config.LockCoreFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	files := make(map[string][]byte)
+	for name, path := range corePaths(cfg) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if name == DisabledUsersFile && os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		files[name] = data
+	}
+	return files, nil
+}
+
+// sameFiles reports whether two sets of files have the same names and contents.
+func sameFiles(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, data := range a {
+		other, ok := b[name]
+		if !ok || !bytes.Equal(data, other) {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot saves the current core config files as a new version unless they equal the latest
+// version, and removes the oldest versions beyond backup.keep. It returns nil if no version was
+// saved, including when backups are disabled.
+func Snapshot(cfg *config.Config, reason string) (*Version, error) {
+	if cfg.Backup.Dir == "" {
+		return nil, nil
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	current, err := readCurrent(cfg)
+	if err != nil {
+		cfg.Logger.Error("Failed to read core config for backup", "error", err)
+		return nil, err
+	}
+	versions, err := list(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) > 0 {
+		latest, err := files(cfg, versions[0].ID)
+		if err == nil && sameFiles(current, latest) {
+			cfg.Logger.Trace("Core config unchanged since latest version", "version", versions[0].ID)
+			return nil, nil
+		}
+	}
+
+	now := time.Now().UTC()
+	version := Version{ID: now.Format(idFormat), Time: now.Format(time.RFC3339), Reason: reason}
+	if len(versions) > 0 && version.ID <= versions[0].ID {
+		// Keep IDs increasing if the clock went back or two versions share a timestamp
+		latest, _ := time.Parse(idFormat, versions[0].ID)
+		version.ID = latest.Add(time.Microsecond).Format(idFormat)
+	}
+
+	dir := filepath.Join(cfg.Backup.Dir, version.ID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		cfg.Logger.Error("Failed to create backup directory", "path", dir, "error", err)
+		return nil, fmt.Errorf("failed to create backup directory: %v", err)
+	}
+	for _, name := range []string{ConfigFile, DisabledUsersFile} {
+		data, ok := current[name]
+		if !ok {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			os.RemoveAll(dir)
+			cfg.Logger.Error("Failed to write backup", "path", dir, "file", name, "error", err)
+			return nil, fmt.Errorf("failed to write backup of %s: %v", name, err)
+		}
+		version.Files = append(version.Files, name)
+	}
+	meta, err := json.MarshalIndent(version, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, versionFile), meta, 0600)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		cfg.Logger.Error("Failed to write backup metadata", "path", dir, "error", err)
+		return nil, fmt.Errorf("failed to write backup metadata: %v", err)
+	}
+	cfg.Logger.Info("Core config version saved", "version", version.ID, "reason", reason)
+
+	versions = append([]Version{version}, versions...)
+	for _, old := range versions[min(len(versions), cfg.Backup.Keep):] {
+		if err := os.RemoveAll(filepath.Join(cfg.Backup.Dir, old.ID)); err != nil {
+			cfg.Logger.Warn("Failed to remove old config version", "version", old.ID, "error", err)
+		} else {
+			cfg.Logger.Debug("Old config version removed", "version", old.ID)
+		}
+	}
+	return &version, nil
+}
+
+// List returns the saved versions, newest first.
+func List(cfg *config.Config) ([]Version, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	return list(cfg)
+}
+
+func list(cfg *config.Config) ([]Version, error) {
+	versions := []Version{}
+	if cfg.Backup.Dir == "" {
+		return versions, nil
+	}
+	entries, err := os.ReadDir(cfg.Backup.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return versions, nil
+		}
+		cfg.Logger.Error("Failed to read backup directory", "path", cfg.Backup.Dir, "error", err)
+		return nil, fmt.Errorf("failed to read backup directory: %v", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !versionIDRegex.MatchString(entry.Name()) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(cfg.Backup.Dir, entry.Name(), versionFile))
+		if err != nil {
+			cfg.Logger.Warn("Skipping config version without metadata", "version", entry.Name(), "error", err)
+			continue
+		}
+		var version Version
+		if err := json.Unmarshal(data, &version); err != nil || version.ID != entry.Name() {
+			cfg.Logger.Warn("Skipping config version with invalid metadata", "version", entry.Name())
+			continue
+		}
+		versions = append(versions, version)
+	}
+	slices.SortFunc(versions, func(a, b Version) int {
+		if a.ID > b.ID {
+			return -1
+		}
+		if a.ID < b.ID {
+			return 1
+		}
+		return 0
+	})
+	return versions, nil
+}
+
+// Files returns the files of a version by name, or the current core config files for the ID "current".
+func Files(cfg *config.Config, id string) (map[string][]byte, error) {
+	if id == "current" {
+		return readCurrent(cfg)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return files(cfg, id)
+}
+
+func files(cfg *config.Config, id string) (map[string][]byte, error) {
+	if cfg.Backup.Dir == "" || !versionIDRegex.MatchString(id) {
+		return nil, fmt.Errorf("%w: %s", ErrVersionNotFound, id)
+	}
+	dir := filepath.Join(cfg.Backup.Dir, id)
+	data, err := os.ReadFile(filepath.Join(dir, versionFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrVersionNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to read config version %s: %v", id, err)
+	}
+	var version Version
+	if err := json.Unmarshal(data, &version); err != nil {
+		return nil, fmt.Errorf("failed to parse config version %s: %v", id, err)
+	}
+
+	result := make(map[string][]byte, len(version.Files))
+	for _, name := range version.Files {
+		if name != ConfigFile && name != DisabledUsersFile {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s of config version %s: %v", name, id, err)
+		}
+		result[name] = data
+	}
+	if _, ok := result[ConfigFile]; !ok {
+		return nil, fmt.Errorf("config version %s has no %s", id, ConfigFile)
+	}
+	return result, nil
+}
+
+// Restore writes the core config files of a version back, removing .disabled_users if the
+// version has none. The current files are saved as a version first, and the restored files
+// are saved as a new version, so a rollback can itself be rolled back.
+func Restore(cfg *config.Config, id string) error {
+	restored, err := Files(cfg, id)
+	if err != nil {
+		return err
+	}
+	for name, data := range restored {
+		if !json.Valid(data) {
+			return fmt.Errorf("%s of config version %s is not valid JSON", name, id)
+		}
+	}
+
+	if _, err := Snapshot(cfg, "before rollback to "+id); err != nil {
+		return fmt.Errorf("failed to save current config before rollback: %v", err)
+	}
+
+	unlock, err := config.LockCoreFiles(cfg)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	paths := corePaths(cfg)
+	for _, name := range []string{ConfigFile, DisabledUsersFile} {
+		data, ok := restored[name]
+		if !ok {
+			if err := os.Remove(paths[name]); err != nil && !os.IsNotExist(err) {
+				cfg.Logger.Error("Failed to remove file during rollback", "path", paths[name], "error", err)
+				return fmt.Errorf("failed to remove %s: %v", name, err)
+			}
+			continue
+		}
+		if err := config.WriteCoreFile(cfg, paths[name], data); err != nil {
+			cfg.Logger.Error("Failed to write file during rollback", "path", paths[name], "error", err)
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	unlock()
+	cfg.Logger.Info("Core config rolled back", "version", id)
+
+	if _, err := Snapshot(cfg, "rollback to "+id); err != nil {
+		cfg.Logger.Warn("Failed to save rolled back config version", "version", id, "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(values ...string) []byte {
+		return []byte(strings.Join(values, "\n") + "\n")
+	}
+	ten := lines("1", "2", "3", "4", "5", "6", "7", "8", "9", "10")
+
+	tests := []struct {
+		name     string
+		from, to []byte
+		want     string
+	}{
+		{
+			name: "equal",
+			from: ten,
+			to:   ten,
+			want: "",
+		},
+		{
+			name: "changed line",
+			from: ten,
+			to:   lines("1", "2", "3", "4", "five", "6", "7", "8", "9", "10"),
+			want: "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "added at the end",
+			from: lines("1", "2"),
+			to:   lines("1", "2", "3"),
+			want: "--- a\n+++ b\n@@ -1,2 +1,3 @@\n 1\n 2\n+3\n",
+		},
+		{
+			name: "new file",
+			from: nil,
+			to:   lines("1", "2"),
+			want: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+1\n+2\n",
+		},
+		{
+			name: "removed file",
+			from: lines("1", "2"),
+			to:   nil,
+			want: "--- a\n+++ b\n@@ -1,2 +0,0 @@\n-1\n-2\n",
+		},
+		{
+			name: "close changes share a hunk",
+			from: ten,
+			to:   lines("one", "2", "3", "4", "5", "6", "7", "eight", "9", "10"),
+			want: "--- a\n+++ b\n@@ -1,10 +1,10 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n 9\n 10\n",
+		},
+		{
+			name: "distant changes get separate hunks",
+			from: lines("1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12"),
+			to:   lines("one", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "twelve"),
+			want: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := UnifiedDiff("a", "b", tt.from, tt.to); got != tt.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"cmp"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
 	"regexp"
 	"slices"
 	"strconv"
@@ -26,6 +29,9 @@ type Config struct {
 	Telegram         TelegramConfig         `yaml:"telegram"`
 	SystemMonitoring SystemMonitoringConfig `yaml:"system_monitoring"`
 	Paths            PathsConfig            `yaml:"paths"`
+	History          HistoryConfig          `yaml:"history"`
+	Subscription     SubscriptionConfig     `yaml:"subscription"`
+	Backup           BackupConfig           `yaml:"backup"`
 	IpTtl            time.Duration          `yaml:"-"`
 	StatsColumns     StatsColumns           `yaml:"stats_columns"`
 	Logger           *logger.Logger
@@ -46,10 +52,35 @@ type V2rayStatConfig struct {
 
 // CoreConfig holds core-related settings.
 type CoreConfig struct {
-	Dir            string `yaml:"dir"`
-	Config         string `yaml:"config"`
-	AccessLog      string `yaml:"access_log"`
-	AccessLogRegex string `yaml:"access_log_regex"`
+	Dir             string        `yaml:"dir"`
+	Config          string        `yaml:"config"`
+	AccessLog       string        `yaml:"access_log"`
+	AccessLogRegex  string        `yaml:"access_log_regex"`
+	ValidateCommand string        `yaml:"validate_command"` // Checks a new config.json before it is written, {file} is its path
+	ReloadCommand   string        `yaml:"reload_command"`   // Run after config.json is written
+	ReloadDelay     int           `yaml:"reload_delay"`     // Seconds to wait for further changes before reloading
+	API             CoreAPIConfig `yaml:"api"`
+}
+
+// CoreAPIConfig holds settings for connecting to the core's gRPC API.
+type CoreAPIConfig struct {
+	Address           string           `yaml:"address"`
+	Socket            string           `yaml:"socket"`
+	Timeout           int              `yaml:"timeout"`
+	ResetOnRead       bool             `yaml:"reset_on_read"`
+	LiveUpdate        bool             `yaml:"live_update"`
+	ReconcileInterval int              `yaml:"reconcile_interval"`
+	TLS               CoreAPITLSConfig `yaml:"tls"`
+}
+
+// CoreAPITLSConfig holds TLS and mTLS settings for the core's gRPC API.
+type CoreAPITLSConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
 }
 
 // MonitorConfig holds monitoring-related settings.
@@ -65,8 +96,10 @@ type APIConfig struct {
 
 // TelegramConfig holds Telegram notification settings.
 type TelegramConfig struct {
-	ChatID   string `yaml:"chat_id"`
-	BotToken string `yaml:"bot_token"`
+	ChatID             string          `yaml:"chat_id"`
+	BotToken           string          `yaml:"bot_token"`
+	Reminders          []string        `yaml:"reminders"` // Time before sub_end to remind at, e.g. 3d, 1d, 3h
+	ReminderThresholds []time.Duration `yaml:"-"`         // Parsed reminders, longest first
 }
 
 // SystemMonitoringConfig holds system monitoring settings.
@@ -94,6 +127,28 @@ type PathsConfig struct {
 	AuthLua      string `yaml:"auth_lua"`
 }
 
+// HistoryConfig holds retention settings for the traffic history.
+type HistoryConfig struct {
+	FiveMinuteRetention int `yaml:"five_minute_retention"` // Hours to keep 5-minute buckets
+	HourlyRetention     int `yaml:"hourly_retention"`      // Days to keep hourly buckets
+	DailyRetention      int `yaml:"daily_retention"`       // Days to keep daily buckets, 0 keeps them forever
+}
+
+// SubscriptionConfig holds settings for the per-user subscription endpoint.
+type SubscriptionConfig struct {
+	Address        string   `yaml:"address"`         // Public host put into links, the request host if empty
+	Port           int      `yaml:"port"`            // Public port put into links, 0 keeps the inbound port
+	Title          string   `yaml:"title"`           // Profile title shown by clients
+	UpdateInterval int      `yaml:"update_interval"` // Hours between client updates
+	Inbounds       []string `yaml:"inbounds"`        // Inbound tags to publish, all vless/trojan inbounds if empty
+}
+
+// BackupConfig holds settings for the versions of the core config files kept on every change.
+type BackupConfig struct {
+	Dir  string `yaml:"dir"`  // Directory for the versions, empty disables backups
+	Keep int    `yaml:"keep"` // Number of versions to keep
+}
+
 // StatsColumns holds column configuration for stats display.
 type StatsColumns struct {
 	Server StatsSection `yaml:"server"`
@@ -127,6 +182,15 @@ var defaultConfig = Config{
 		Config:         "/usr/local/etc/xray/config.json",
 		AccessLog:      "/usr/local/etc/xray/access.log",
 		AccessLogRegex: `from (?:tcp|udp):([\d\.]+):\d+ accepted (?:tcp|udp):([\w\.\-]+):\d+ \[[^\]]+\] email: (\S+)`,
+		ReloadDelay:    5,
+		API: CoreAPIConfig{
+			Address:           "127.0.0.1:9953",
+			Socket:            "",
+			Timeout:           5,
+			ResetOnRead:       false,
+			LiveUpdate:        false,
+			ReconcileInterval: 300,
+		},
 	},
 	API: APIConfig{
 		APIToken: "",
@@ -135,8 +199,9 @@ var defaultConfig = Config{
 	Features: make(map[string]bool),
 	Services: []string{"xray", "fail2ban-server"},
 	Telegram: TelegramConfig{
-		ChatID:   "",
-		BotToken: "",
+		ChatID:    "",
+		BotToken:  "",
+		Reminders: []string{},
 	},
 	SystemMonitoring: SystemMonitoringConfig{
 		AverageInterval: 120,
@@ -153,6 +218,22 @@ var defaultConfig = Config{
 		F2BBannedLog: "/var/log/v2ray-stat-banned.log",
 		AuthLua:      "/etc/haproxy/.auth.lua",
 	},
+	History: HistoryConfig{
+		FiveMinuteRetention: 48,
+		HourlyRetention:     30,
+		DailyRetention:      365,
+	},
+	Subscription: SubscriptionConfig{
+		Address:        "",
+		Port:           0,
+		Title:          "v2ray-stat",
+		UpdateInterval: 12,
+		Inbounds:       []string{},
+	},
+	Backup: BackupConfig{
+		Dir:  "/usr/local/etc/v2ray-stat/backups",
+		Keep: 50,
+	},
 	StatsColumns: StatsColumns{
 		Server: StatsSection{Sort: "source ASC", Columns: []string{}},
 		Client: StatsSection{Sort: "user ASC", Columns: []string{}},
@@ -206,6 +287,10 @@ func LoadConfig(configFile string) (Config, error) {
 		}
 	}
 
+	if err := validateCoreAPI(&cfg); err != nil {
+		return cfg, err
+	}
+
 	if cfg.SystemMonitoring.AverageInterval < 10 {
 		cfg.Logger.Warn("Invalid system_monitoring.average_interval, using default", "value", cfg.SystemMonitoring.AverageInterval, "default", defaultConfig.SystemMonitoring.AverageInterval)
 		cfg.SystemMonitoring.AverageInterval = defaultConfig.SystemMonitoring.AverageInterval
@@ -231,6 +316,31 @@ func LoadConfig(configFile string) (Config, error) {
 		cfg.V2rayStat.Monitor.OnlineRateThreshold = defaultConfig.V2rayStat.Monitor.OnlineRateThreshold
 	}
 
+	if cfg.History.FiveMinuteRetention < 1 {
+		cfg.Logger.Warn("Invalid history.five_minute_retention, using default", "value", cfg.History.FiveMinuteRetention, "default", defaultConfig.History.FiveMinuteRetention)
+		cfg.History.FiveMinuteRetention = defaultConfig.History.FiveMinuteRetention
+	}
+
+	if cfg.History.HourlyRetention < 1 {
+		cfg.Logger.Warn("Invalid history.hourly_retention, using default", "value", cfg.History.HourlyRetention, "default", defaultConfig.History.HourlyRetention)
+		cfg.History.HourlyRetention = defaultConfig.History.HourlyRetention
+	}
+
+	if cfg.History.DailyRetention < 0 {
+		cfg.Logger.Warn("Invalid history.daily_retention, using default", "value", cfg.History.DailyRetention, "default", defaultConfig.History.DailyRetention)
+		cfg.History.DailyRetention = defaultConfig.History.DailyRetention
+	}
+
+	if cfg.Core.ReloadDelay < 0 {
+		cfg.Logger.Warn("Invalid core.reload_delay, using default", "value", cfg.Core.ReloadDelay, "default", defaultConfig.Core.ReloadDelay)
+		cfg.Core.ReloadDelay = defaultConfig.Core.ReloadDelay
+	}
+
+	if cfg.Backup.Keep < 1 {
+		cfg.Logger.Warn("Invalid backup.keep, using default", "value", cfg.Backup.Keep, "default", defaultConfig.Backup.Keep)
+		cfg.Backup.Keep = defaultConfig.Backup.Keep
+	}
+
 	if cfg.Timezone != "" {
 		if _, err := time.LoadLocation(cfg.Timezone); err != nil {
 			cfg.Logger.Warn("Invalid timezone value, using default", "timezone", cfg.Timezone)
@@ -238,6 +348,31 @@ func LoadConfig(configFile string) (Config, error) {
 		}
 	}
 
+	if cfg.Subscription.Port < 0 || cfg.Subscription.Port > 65535 {
+		cfg.Logger.Warn("Invalid subscription.port, using inbound ports", "value", cfg.Subscription.Port)
+		cfg.Subscription.Port = 0
+	}
+	if cfg.Subscription.UpdateInterval < 1 {
+		cfg.Logger.Warn("Invalid subscription.update_interval, using default", "value", cfg.Subscription.UpdateInterval, "default", defaultConfig.Subscription.UpdateInterval)
+		cfg.Subscription.UpdateInterval = defaultConfig.Subscription.UpdateInterval
+	}
+	if strings.TrimSpace(cfg.Subscription.Title) == "" {
+		cfg.Subscription.Title = defaultConfig.Subscription.Title
+	}
+
+	cfg.Telegram.ReminderThresholds = nil
+	for _, reminder := range cfg.Telegram.Reminders {
+		threshold, err := ParseDaysHours(reminder)
+		if err != nil {
+			cfg.Logger.Warn("Invalid telegram.reminders value, ignoring", "value", reminder, "error", err)
+			continue
+		}
+		if !slices.Contains(cfg.Telegram.ReminderThresholds, threshold) {
+			cfg.Telegram.ReminderThresholds = append(cfg.Telegram.ReminderThresholds, threshold)
+		}
+	}
+	slices.SortFunc(cfg.Telegram.ReminderThresholds, func(a, b time.Duration) int { return cmp.Compare(b, a) })
+
 	// Ensure Features map is initialized
 	if cfg.Features == nil {
 		cfg.Features = make(map[string]bool)
@@ -311,6 +446,76 @@ func LoadConfig(configFile string) (Config, error) {
 	return cfg, nil
 }
 
+// daysHoursRegex matches durations like 3d, 12h or 1d12h.
+var daysHoursRegex = regexp.MustCompile(`^(?:(\d+)d)?(?:(\d+)h)?$`)
+
+// ParseDaysHours parses a positive duration in days and hours, such as 3d, 12h or 1d12h.
+func ParseDaysHours(value string) (time.Duration, error) {
+	m := daysHoursRegex.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil || (m[1] == "" && m[2] == "") {
+		return 0, fmt.Errorf("expected days and/or hours, e.g. 3d, 12h or 1d12h")
+	}
+	days, _ := strconv.Atoi(m[1])
+	hours, _ := strconv.Atoi(m[2])
+	duration := time.Duration(days)*24*time.Hour + time.Duration(hours)*time.Hour
+	if duration <= 0 {
+		return 0, fmt.Errorf("duration must be positive")
+	}
+	return duration, nil
+}
+
+// validateCoreAPI checks the core.api settings, falling back to defaults for invalid connection values.
+func validateCoreAPI(cfg *Config) error {
+	api := &cfg.Core.API
+
+	if api.Socket != "" && !filepath.IsAbs(api.Socket) {
+		cfg.Logger.Warn("Invalid core.api.socket, must be an absolute path, ignoring", "socket", api.Socket)
+		api.Socket = ""
+	}
+
+	if api.Socket == "" {
+		host, port, err := net.SplitHostPort(api.Address)
+		portNum, portErr := strconv.Atoi(port)
+		if err != nil || host == "" || portErr != nil || portNum < 1 || portNum > 65535 {
+			cfg.Logger.Warn("Invalid core.api.address, using default", "address", api.Address, "default", defaultConfig.Core.API.Address)
+			api.Address = defaultConfig.Core.API.Address
+		}
+	}
+
+	if api.Timeout < 1 {
+		cfg.Logger.Warn("Invalid core.api.timeout, using default", "value", api.Timeout, "default", defaultConfig.Core.API.Timeout)
+		api.Timeout = defaultConfig.Core.API.Timeout
+	}
+
+	if api.ReconcileInterval < 0 {
+		cfg.Logger.Warn("Invalid core.api.reconcile_interval, using default", "value", api.ReconcileInterval, "default", defaultConfig.Core.API.ReconcileInterval)
+		api.ReconcileInterval = defaultConfig.Core.API.ReconcileInterval
+	}
+	if api.LiveUpdate && cfg.V2rayStat.Type != "xray" {
+		cfg.Logger.Warn("core.api.live_update is only supported for xray, disabling", "type", cfg.V2rayStat.Type)
+		api.LiveUpdate = false
+	}
+
+	if !api.TLS.Enabled {
+		return nil
+	}
+	if (api.TLS.CertFile == "") != (api.TLS.KeyFile == "") {
+		return fmt.Errorf("core.api.tls: cert_file and key_file must be set together")
+	}
+	for _, file := range []string{api.TLS.CAFile, api.TLS.CertFile, api.TLS.KeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("core.api.tls: cannot access %s: %v", file, err)
+		}
+	}
+	if api.TLS.InsecureSkipVerify {
+		cfg.Logger.Warn("core.api.tls.insecure_skip_verify is enabled, the core certificate will not be verified")
+	}
+	return nil
+}
+
 func contains(slice []string, item string) bool {
 	return slices.Contains(slice, item)
 }
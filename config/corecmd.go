@@ -0,0 +1,87 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCoreConfigRejected is returned when core.validate_command fails for a new config.json.
+var ErrCoreConfigRejected = errors.New("core rejected the new config")
+
+// Timeouts of the commands run for the core.
+const (
+	validateTimeout = 30 * time.Second
+	reloadTimeout   = 60 * time.Second
+)
+
+// maxCommandOutput limits the command output kept for errors and logs.
+const maxCommandOutput = 2000
+
+var (
+	reloadMu    sync.Mutex // guards reloadTimer
+	reloadTimer *time.Timer
+	reloadRunMu sync.Mutex // keeps reloads from overlapping
+)
+
+// runCoreCommand runs a command with sh -c and returns its combined output.
+func runCoreCommand(command string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, "sh", "-c", command).CombinedOutput()
+	text := strings.TrimSpace(string(output))
+	if len(text) > maxCommandOutput {
+		text = text[len(text)-maxCommandOutput:]
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return text, fmt.Errorf("timed out after %s", timeout)
+	}
+	return text, err
+}
+
+// validateCoreConfig runs core.validate_command for a candidate config.json, with {file}
+// replaced by its path.
+func validateCoreConfig(cfg *Config, path string) error {
+	command := strings.ReplaceAll(cfg.Core.ValidateCommand, "{file}", "'"+strings.ReplaceAll(path, "'", `'\''`)+"'")
+	cfg.Logger.Debug("Validating core config", "command", command)
+	output, err := runCoreCommand(command, validateTimeout)
+	if err != nil {
+		cfg.Logger.Warn("Core config rejected by validate command", "error", err, "output", output)
+		if output == "" {
+			return fmt.Errorf("%w: %v", ErrCoreConfigRejected, err)
+		}
+		return fmt.Errorf("%w: %v: %s", ErrCoreConfigRejected, err, output)
+	}
+	cfg.Logger.Trace("Core config validated", "output", output)
+	return nil
+}
+
+// ScheduleCoreReload runs core.reload_command core.reload_delay seconds after the last call,
+// so a series of changes, like a bulk import, reloads the core once.
+func ScheduleCoreReload(cfg *Config) {
+	if cfg.Core.ReloadCommand == "" {
+		return
+	}
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	delay := time.Duration(cfg.Core.ReloadDelay) * time.Second
+	if reloadTimer != nil && reloadTimer.Stop() {
+		cfg.Logger.Trace("Core reload postponed", "delay", delay)
+	}
+	reloadTimer = time.AfterFunc(delay, func() {
+		reloadRunMu.Lock()
+		defer reloadRunMu.Unlock()
+		cfg.Logger.Debug("Reloading core", "command", cfg.Core.ReloadCommand)
+		output, err := runCoreCommand(cfg.Core.ReloadCommand, reloadTimeout)
+		if err != nil {
+			cfg.Logger.Error("Failed to reload core", "command", cfg.Core.ReloadCommand, "error", err, "output", output)
+			return
+		}
+		cfg.Logger.Info("Core reloaded", "command", cfg.Core.ReloadCommand)
+	})
+}
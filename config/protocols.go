@@ -0,0 +1,120 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// IsUserProtocol reports whether an inbound protocol (Xray) or type (Singbox) has per-user credentials managed by v2ray-stat.
+func IsUserProtocol(protocol string) bool {
+	switch protocol {
+	case "vless", "vmess", "trojan", "shadowsocks", "hysteria2", "tuic":
+		return true
+	}
+	return false
+}
+
+// IsShadowsocks2022 reports whether a Shadowsocks method is a 2022 edition method with base64 keys.
+func IsShadowsocks2022(method string) bool {
+	return strings.HasPrefix(method, "2022-")
+}
+
+// Shadowsocks2022KeySize returns the size in bytes of the keys of a Shadowsocks 2022 method.
+func Shadowsocks2022KeySize(method string) int {
+	if strings.Contains(method, "aes-128") {
+		return 16
+	}
+	return 32
+}
+
+// ValidateCredential checks that a credential given for a user fits the inbound protocol and
+// Shadowsocks method: a UUID for vless and vmess, uuid or uuid:password for tuic and a base64 key
+// of the method's size for Shadowsocks 2022. Passwords of other protocols are not restricted.
+func ValidateCredential(protocol, method, credential string) error {
+	switch protocol {
+	case "vless", "vmess":
+		if _, err := uuid.Parse(credential); err != nil {
+			return fmt.Errorf("%s credential must be a UUID", protocol)
+		}
+	case "tuic":
+		id, _, _ := strings.Cut(credential, ":")
+		if _, err := uuid.Parse(id); err != nil {
+			return fmt.Errorf("tuic credential must be a UUID or uuid:password")
+		}
+	case "shadowsocks":
+		if !IsShadowsocks2022(method) {
+			return nil
+		}
+		key, err := base64.StdEncoding.DecodeString(credential)
+		if err != nil || len(key) != Shadowsocks2022KeySize(method) {
+			return fmt.Errorf("%s credential must be a base64 key of %d bytes", method, Shadowsocks2022KeySize(method))
+		}
+	}
+	return nil
+}
+
+// Credential returns the client's credential for the given Xray protocol.
+func (c XrayClient) Credential(protocol string) string {
+	switch protocol {
+	case "trojan", "shadowsocks":
+		return c.Password
+	default:
+		return c.ID
+	}
+}
+
+// NewXrayClient creates an Xray client with the credential stored in the field used by the protocol.
+func NewXrayClient(protocol, email, credential string) XrayClient {
+	client := XrayClient{Email: email}
+	client.SetCredential(protocol, credential)
+	return client
+}
+
+// SetCredential stores the credential in the field used by the Xray protocol, keeping the other fields.
+func (c *XrayClient) SetCredential(protocol, credential string) {
+	switch protocol {
+	case "trojan", "shadowsocks":
+		c.Password = credential
+	default:
+		c.ID = credential
+	}
+}
+
+// Credential returns the user's credential for the given Singbox inbound type.
+// For tuic the UUID identifies the user; the password is kept alongside it.
+func (u SingboxClient) Credential(inboundType string) string {
+	switch inboundType {
+	case "trojan", "shadowsocks", "hysteria2":
+		return u.Password
+	default:
+		return u.UUID
+	}
+}
+
+// NewSingboxClient creates a Singbox user with the credential stored in the fields used by the inbound type.
+// For tuic the credential has the form uuid:password; without a password the UUID is used for both.
+func NewSingboxClient(inboundType, name, credential string) SingboxClient {
+	user := SingboxClient{Name: name}
+	user.SetCredential(inboundType, credential)
+	return user
+}
+
+// SetCredential stores the credential in the fields used by the Singbox inbound type, keeping the other fields.
+func (u *SingboxClient) SetCredential(inboundType, credential string) {
+	switch inboundType {
+	case "trojan", "shadowsocks", "hysteria2":
+		u.Password = credential
+	case "tuic":
+		id, password, found := strings.Cut(credential, ":")
+		if !found {
+			password = id
+		}
+		u.UUID = id
+		u.Password = password
+	default:
+		u.UUID = credential
+	}
+}
@@ -0,0 +1,34 @@
+package config
+
+import "testing"
+
+func TestValidateCredential(t *testing.T) {
+	tests := []struct {
+		protocol   string
+		method     string
+		credential string
+		valid      bool
+	}{
+		{"vless", "", "123e4567-e89b-12d3-a456-426614174000", true},
+		{"vless", "", "not-a-uuid", false},
+		{"vmess", "", "123e4567-e89b-12d3-a456-426614174000", true},
+		{"vmess", "", "password", false},
+		{"tuic", "", "123e4567-e89b-12d3-a456-426614174000", true},
+		{"tuic", "", "123e4567-e89b-12d3-a456-426614174000:secret", true},
+		{"tuic", "", "user:secret", false},
+		{"trojan", "", "any password", true},
+		{"hysteria2", "", "any password", true},
+		{"shadowsocks", "aes-256-gcm", "any password", true},
+		{"shadowsocks", "2022-blake3-aes-128-gcm", "AAAAAAAAAAAAAAAAAAAAAA==", true},
+		{"shadowsocks", "2022-blake3-aes-128-gcm", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", false},
+		{"shadowsocks", "2022-blake3-aes-256-gcm", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", true},
+		{"shadowsocks", "2022-blake3-chacha20-poly1305", "AAAAAAAAAAAAAAAAAAAAAA==", false},
+		{"shadowsocks", "2022-blake3-aes-256-gcm", "123e4567-e89b-12d3-a456-426614174000", false},
+	}
+	for _, tt := range tests {
+		err := ValidateCredential(tt.protocol, tt.method, tt.credential)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateCredential(%q, %q, %q) = %v, want valid %v", tt.protocol, tt.method, tt.credential, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// rawFields keeps the members of a decoded JSON object, so that members unknown to the typed
+// struct, the member order and the exact encoding of untouched values survive a round trip.
+type rawFields struct {
+	keys     []string
+	values   map[string]json.RawMessage
+	snapshot map[string]json.RawMessage
+}
+
+// decode records the members of data and a snapshot of the known members as they encode right after decoding.
+func (r *rawFields) decode(data []byte, known any) error {
+	if string(bytes.TrimSpace(data)) == "null" {
+		return nil
+	}
+	keys, values, err := objectMembers(data)
+	if err != nil {
+		return err
+	}
+	encoded, err := marshalNoEscape(known)
+	if err != nil {
+		return err
+	}
+	_, snapshot, err := objectMembers(encoded)
+	if err != nil {
+		return err
+	}
+	r.keys, r.values, r.snapshot = keys, values, snapshot
+	return nil
+}
+
+// encode merges the encoding of the known members with the recorded members.
+// Recorded members keep their order; known members that did not change keep their original encoding,
+// even when the struct omits them as empty, known members cleared since decoding are dropped,
+// and new known members are appended.
+func (r rawFields) encode(known any) ([]byte, error) {
+	encoded, err := marshalNoEscape(known)
+	if err != nil {
+		return nil, err
+	}
+	if r.values == nil {
+		return encoded, nil
+	}
+	knownKeys, knownValues, err := objectMembers(encoded)
+	if err != nil {
+		return nil, err
+	}
+	names := jsonFieldNames(reflect.TypeOf(known))
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	writeMember := func(key string, value json.RawMessage) {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		name, _ := marshalNoEscape(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+
+	for _, key := range r.keys {
+		if !names[key] {
+			writeMember(key, r.values[key])
+			continue
+		}
+		value, ok := knownValues[key]
+		if !ok {
+			// Omitted both now and right after decoding, so the member still has its decoded value
+			if _, cleared := r.snapshot[key]; !cleared {
+				writeMember(key, r.values[key])
+			}
+			continue
+		}
+		if bytes.Equal(value, r.snapshot[key]) {
+			value = r.values[key]
+		}
+		writeMember(key, value)
+	}
+	for _, key := range knownKeys {
+		if _, ok := r.values[key]; ok || isZeroJSON(knownValues[key]) {
+			continue
+		}
+		writeMember(key, knownValues[key])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// objectMembers returns the member names of a JSON object in order together with their raw values.
+func objectMembers(data []byte) ([]string, map[string]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, nil, fmt.Errorf("expected JSON object")
+	}
+
+	var keys []string
+	values := make(map[string]json.RawMessage)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("expected object key")
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		if _, exists := values[key]; !exists {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+	return keys, values, nil
+}
+
+var fieldNamesCache sync.Map
+
+// jsonFieldNames returns the JSON member names handled by a struct type.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	if cached, ok := fieldNamesCache.Load(t); ok {
+		return cached.(map[string]bool)
+	}
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names[name] = true
+	}
+	fieldNamesCache.Store(t, names)
+	return names
+}
+
+// isZeroJSON reports whether a raw JSON value is null or an empty/zero literal.
+func isZeroJSON(value json.RawMessage) bool {
+	switch string(value) {
+	case "null", `""`, "0", "false", "[]", "{}":
+		return true
+	}
+	return false
+}
+
+// marshalNoEscape encodes v as compact JSON without escaping HTML characters.
+func marshalNoEscape(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// MarshalCoreConfig encodes a core config file (config.json or .disabled_users) with two-space indentation,
+// keeping members unknown to v2ray-stat and their order as they were read.
+func MarshalCoreConfig(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
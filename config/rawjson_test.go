@@ -0,0 +1,117 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRawFieldsRoundTrip(t *testing.T) {
+	const inbound = `{"protocol":"vless","x-note":{"b":1,"a":[1.0,2]},"tag":"in-vless","port":443,"listen":"",` +
+		`"settings":{"clients":[{"id":"123e4567-e89b-12d3-a456-426614174000","email":"alice","flow":"xtls-rprx-vision","x-limit":5}],"decryption":"none"},` +
+		`"sniffing":{"enabled":true,"destOverride":["http","tls"],"routeOnly":false}}`
+
+	tests := []struct {
+		name   string
+		input  string
+		target any
+		modify func(target any)
+		want   string
+	}{
+		{
+			name:   "unchanged",
+			input:  inbound,
+			target: &XrayInbound{},
+			modify: func(target any) {},
+			want:   inbound,
+		},
+		{
+			name:   "unchanged empty members",
+			input:  `{"email":"alice","flow":"","level":0,"x-limit":5}`,
+			target: &XrayClient{},
+			modify: func(target any) {},
+			want:   `{"email":"alice","flow":"","level":0,"x-limit":5}`,
+		},
+		{
+			name:   "unchanged empty top-level members",
+			input:  `{"remarks":"","log":{},"transport":{},"inbounds":[],"x-extra":1}`,
+			target: &ConfigXray{},
+			modify: func(target any) {},
+			want:   `{"remarks":"","log":{},"transport":{},"inbounds":[],"x-extra":1}`,
+		},
+		{
+			name:   "changed known member",
+			input:  `{"tag":"in","x-extra":"keep","port":443,"settings":{"clients":[]}}`,
+			target: &XrayInbound{},
+			modify: func(target any) { target.(*XrayInbound).Port = json.RawMessage("8443") },
+			want:   `{"tag":"in","x-extra":"keep","port":8443,"settings":{"clients":[]}}`,
+		},
+		{
+			name:   "port range",
+			input:  `{"tag":"in","port":"1000-2000","settings":{"clients":[]}}`,
+			target: &XrayInbound{},
+			modify: func(target any) {
+				settings := &target.(*XrayInbound).Settings
+				settings.Clients = append(settings.Clients, XrayClient{Email: "bob"})
+			},
+			want: `{"tag":"in","port":"1000-2000","settings":{"clients":[{"email":"bob"}]}}`,
+		},
+		{
+			name:   "cleared omitempty member",
+			input:  `{"email":"alice","flow":"xtls-rprx-vision","x-limit":5}`,
+			target: &XrayClient{},
+			modify: func(target any) { target.(*XrayClient).Flow = "" },
+			want:   `{"email":"alice","x-limit":5}`,
+		},
+		{
+			name:   "new known member",
+			input:  `{"email":"alice","x-limit":5}`,
+			target: &XrayClient{},
+			modify: func(target any) { target.(*XrayClient).Level = 1 },
+			want:   `{"email":"alice","x-limit":5,"level":1}`,
+		},
+		{
+			name:   "added client",
+			input:  `{"clients":[{"email":"alice","x-limit":5}],"x-extra":null}`,
+			target: &XraySettings{},
+			modify: func(target any) {
+				settings := target.(*XraySettings)
+				settings.Clients = append(settings.Clients, XrayClient{Email: "bob", ID: "id-bob"})
+			},
+			want: `{"clients":[{"email":"alice","x-limit":5},{"email":"bob","id":"id-bob"}],"x-extra":null}`,
+		},
+	}
+	for _, tt := range tests {
+		if err := json.Unmarshal([]byte(tt.input), tt.target); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		tt.modify(tt.target)
+		got, err := json.Marshal(tt.target)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestXrayInboundPortNumber(t *testing.T) {
+	tests := []struct {
+		port string
+		want int
+	}{
+		{`443`, 443},
+		{`"443"`, 443},
+		{`"1000-2000"`, 1000},
+		{`"1000,2000-2100"`, 1000},
+		{`"env:PORT"`, 0},
+		{`null`, 0},
+		{``, 0},
+	}
+	for _, tt := range tests {
+		inbound := XrayInbound{Port: json.RawMessage(tt.port)}
+		if got := inbound.PortNumber(); got != tt.want {
+			t.Errorf("PortNumber(%s) = %d, want %d", tt.port, got, tt.want)
+		}
+	}
+}
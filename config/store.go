@@ -0,0 +1,173 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// CoreLockFile is the advisory lock file in core.dir held while config.json and .disabled_users
+// are changed. External tools editing the files should take an exclusive flock(2) on it too.
+const CoreLockFile = ".v2ray-stat.lock"
+
+// coreLockTimeout is how long to wait for another process to release the lock file.
+const coreLockTimeout = 30 * time.Second
+
+// coreFilesMu serializes changes to the core config files within v2ray-stat; the lock file
+// does the same for other processes.
+var coreFilesMu sync.Mutex
+
+// LockCoreFiles takes the lock of config.json and .disabled_users. It must be held for the whole
+// read-modify-write of the files and is not reentrant. The returned function releases the lock
+// and may be called more than once.
+func LockCoreFiles(cfg *Config) (func(), error) {
+	coreFilesMu.Lock()
+
+	path := filepath.Join(cfg.Core.Dir, CoreLockFile)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		coreFilesMu.Unlock()
+		cfg.Logger.Error("Failed to open core config lock file", "path", path, "error", err)
+		return nil, fmt.Errorf("failed to open lock file %s: %v", path, err)
+	}
+
+	deadline := time.Now().Add(coreLockTimeout)
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) || time.Now().After(deadline) {
+			file.Close()
+			coreFilesMu.Unlock()
+			cfg.Logger.Error("Failed to lock core config files", "path", path, "error", err)
+			return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+		cfg.Logger.Trace("Core config files locked by another process, waiting", "path", path)
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+			file.Close()
+			coreFilesMu.Unlock()
+		})
+	}, nil
+}
+
+// WriteCoreFile writes config.json or .disabled_users, which must be locked with LockCoreFiles.
+// A new config.json is checked with core.validate_command before it replaces the file, and
+// core.reload_command is scheduled once it has.
+func WriteCoreFile(cfg *Config, path string, data []byte) error {
+	if path != cfg.Core.Config {
+		return writeFileAtomic(path, data, 0644, nil)
+	}
+	var check func(string) error
+	if cfg.Core.ValidateCommand != "" {
+		check = func(candidate string) error { return validateCoreConfig(cfg, candidate) }
+	}
+	if err := writeFileAtomic(path, data, 0644, check); err != nil {
+		return err
+	}
+	ScheduleCoreReload(cfg)
+	return nil
+}
+
+// WriteCoreFiles writes config.json and .disabled_users, which must be locked with LockCoreFiles.
+// Empty disabled data removes .disabled_users. config.json is written first with WriteCoreFile;
+// if .disabled_users cannot be written after it, the previous config.json is restored, so a user
+// moved between the files is not lost.
+func WriteCoreFiles(cfg *Config, mainData, disabledData []byte) error {
+	previous, err := os.ReadFile(cfg.Core.Config)
+	if err != nil {
+		return fmt.Errorf("failed to read config.json: %v", err)
+	}
+	if err := WriteCoreFile(cfg, cfg.Core.Config, mainData); err != nil {
+		return err
+	}
+
+	disabledPath := filepath.Join(cfg.Core.Dir, ".disabled_users")
+	if len(disabledData) > 0 {
+		err = writeFileAtomic(disabledPath, disabledData, 0644, nil)
+	} else if err = os.Remove(disabledPath); os.IsNotExist(err) {
+		err = nil
+	}
+	if err == nil {
+		return nil
+	}
+
+	cfg.Logger.Error("Failed to write .disabled_users, restoring config.json", "path", disabledPath, "error", err)
+	if restoreErr := writeFileAtomic(cfg.Core.Config, previous, 0644, nil); restoreErr != nil {
+		cfg.Logger.Error("Failed to restore config.json", "path", cfg.Core.Config, "error", restoreErr)
+	} else {
+		ScheduleCoreReload(cfg)
+	}
+	return fmt.Errorf("failed to write .disabled_users: %v", err)
+}
+
+// writeFileAtomic replaces a file with data so that readers see either the old or the new
+// contents: the data is written to a temporary file in the same directory, synced and renamed
+// over the file. If check is set, it is called with the temporary file and an error leaves the
+// file unchanged. The mode and owner of an existing file are kept; a symlink is followed.
+func writeFileAtomic(path string, data []byte, perm os.FileMode, check func(string) error) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	uid, gid := -1, -1
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(stat.Uid), int(stat.Gid)
+		}
+	}
+
+	dir := filepath.Dir(path)
+	// Keep the extension, which cores use to detect the config format
+	tmp, err := os.CreateTemp(dir, ".tmp-*-"+filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temporary file: %v", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set mode of temporary file: %v", err)
+	}
+	if uid >= 0 {
+		// Only possible as root; the file is still written if the owner cannot be kept
+		tmp.Chown(uid, gid)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %v", err)
+	}
+	if check != nil {
+		if err := check(tmpPath); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", path, err)
+	}
+
+	// Persist the rename itself
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"v2ray-stat/logger"
+)
+
+func TestWriteCoreFiles(t *testing.T) {
+	log, err := logger.NewLogger("error", "inclusive", "", io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		disabled     []byte
+		failDisabled bool
+		wantConfig   string
+		wantDisabled string
+		wantErr      bool
+	}{
+		{"both files", []byte("disabled new"), false, "config new", "disabled new", false},
+		{"no disabled users", nil, false, "config new", "", false},
+		{"failed second write", []byte("disabled new"), true, "config old", "", true},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		cfg := &Config{Logger: log}
+		cfg.Core.Dir = dir
+		cfg.Core.Config = filepath.Join(dir, "config.json")
+		disabledPath := filepath.Join(dir, ".disabled_users")
+		if err := os.WriteFile(cfg.Core.Config, []byte("config old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if tt.failDisabled {
+			// A non-empty directory cannot be replaced by the new file
+			if err := os.MkdirAll(filepath.Join(disabledPath, "busy"), 0755); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := os.WriteFile(disabledPath, []byte("disabled old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := WriteCoreFiles(cfg, []byte("config new"), tt.disabled)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: WriteCoreFiles() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if data, _ := os.ReadFile(cfg.Core.Config); string(data) != tt.wantConfig {
+			t.Errorf("%s: config.json = %q, want %q", tt.name, data, tt.wantConfig)
+		}
+		if tt.failDisabled {
+			continue
+		}
+		data, err := os.ReadFile(disabledPath)
+		if tt.wantDisabled == "" && !os.IsNotExist(err) {
+			t.Errorf("%s: .disabled_users still exists: %v", tt.name, err)
+		} else if tt.wantDisabled != "" && string(data) != tt.wantDisabled {
+			t.Errorf("%s: .disabled_users = %q, want %q", tt.name, data, tt.wantDisabled)
+		}
+	}
+}
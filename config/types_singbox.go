@@ -1,13 +1,20 @@
 package config
 
+import "encoding/json"
+
 type DisabledUsersConfigSingbox struct {
 	Inbounds []SingboxInbound `json:"inbounds"`
+
+	raw rawFields
 }
 
 type SingboxClient struct {
 	Name     string `json:"name"`
 	UUID     string `json:"uuid,omitempty"`
 	Password string `json:"password,omitempty"`
+	Flow     string `json:"flow,omitempty"`
+
+	raw rawFields
 }
 
 type SingboxInbound struct {
@@ -18,6 +25,27 @@ type SingboxInbound struct {
 	Users      []SingboxClient `json:"users"`
 	Transport  map[string]any  `json:"transport,omitempty"`
 	Multiplex  map[string]any  `json:"multiplex,omitempty"`
+	TLS        map[string]any  `json:"tls,omitempty"`
+
+	// Shadowsocks
+	Method   string `json:"method,omitempty"`
+	Password string `json:"password,omitempty"`
+	Network  string `json:"network,omitempty"`
+
+	// Hysteria2
+	UpMbps                int            `json:"up_mbps,omitempty"`
+	DownMbps              int            `json:"down_mbps,omitempty"`
+	Obfs                  map[string]any `json:"obfs,omitempty"`
+	IgnoreClientBandwidth bool           `json:"ignore_client_bandwidth,omitempty"`
+	Masquerade            any            `json:"masquerade,omitempty"`
+
+	// TUIC
+	CongestionControl string `json:"congestion_control,omitempty"`
+	AuthTimeout       string `json:"auth_timeout,omitempty"`
+	ZeroRTTHandshake  bool   `json:"zero_rtt_handshake,omitempty"`
+	Heartbeat         string `json:"heartbeat,omitempty"`
+
+	raw rawFields
 }
 
 type ConfigSingbox struct {
@@ -27,4 +55,58 @@ type ConfigSingbox struct {
 	Outbounds    []map[string]any `json:"outbounds"`
 	Route        map[string]any   `json:"route"`
 	Experimental map[string]any   `json:"experimental,omitempty"`
+
+	raw rawFields
+}
+
+func (v *DisabledUsersConfigSingbox) UnmarshalJSON(data []byte) error {
+	type plain DisabledUsersConfigSingbox
+	if err := json.Unmarshal(data, (*plain)(v)); err != nil {
+		return err
+	}
+	return v.raw.decode(data, plain(*v))
+}
+
+func (v DisabledUsersConfigSingbox) MarshalJSON() ([]byte, error) {
+	type plain DisabledUsersConfigSingbox
+	return v.raw.encode(plain(v))
+}
+
+func (v *SingboxClient) UnmarshalJSON(data []byte) error {
+	type plain SingboxClient
+	if err := json.Unmarshal(data, (*plain)(v)); err != nil {
+		return err
+	}
+	return v.raw.decode(data, plain(*v))
+}
+
+func (v SingboxClient) MarshalJSON() ([]byte, error) {
+	type plain SingboxClient
+	return v.raw.encode(plain(v))
+}
+
+func (v *SingboxInbound) UnmarshalJSON(data []byte) error {
+	type plain SingboxInbound
+	if err := json.Unmarshal(data, (*plain)(v)); err != nil {
+		return err
+	}
+	return v.raw.decode(data, plain(*v))
+}
+
+func (v SingboxInbound) MarshalJSON() ([]byte, error) {
+	type plain SingboxInbound
+	return v.raw.encode(plain(v))
+}
+
+func (v *ConfigSingbox) UnmarshalJSON(data []byte) error {
+	type plain ConfigSingbox
+	if err := json.Unmarshal(data, (*plain)(v)); err != nil {
+		return err
+	}
+	return v.raw.decode(data, plain(*v))
+}
+
+func (v ConfigSingbox) MarshalJSON() ([]byte, error) {
+	type plain ConfigSingbox
+	return v.raw.encode(plain(v))
 }
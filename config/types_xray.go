@@ -1,30 +1,50 @@
 package config
 
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
 type DisabledUsersConfigXray struct {
 	Inbounds []XrayInbound `json:"inbounds"`
+
+	raw rawFields
 }
 
 type XrayClient struct {
 	Email    string `json:"email"`
 	ID       string `json:"id,omitempty"`
 	Password string `json:"password,omitempty"`
+	Method   string `json:"method,omitempty"`
+	Flow     string `json:"flow,omitempty"`
+	Level    uint32 `json:"level,omitempty"`
+
+	raw rawFields
 }
 
 type XraySettings struct {
 	Clients    []XrayClient `json:"clients"`
 	Decryption *string      `json:"decryption,omitempty"`
 	Address    *string      `json:"address,omitempty"`
+	Method     *string      `json:"method,omitempty"`
+	Password   *string      `json:"password,omitempty"`
+	Network    *string      `json:"network,omitempty"`
+
+	raw rawFields
 }
 
 type XrayInbound struct {
-	Tag            string         `json:"tag"`
-	Settings       XraySettings   `json:"settings"`
-	Port           int            `json:"port"`
-	Protocol       string         `json:"protocol"`
-	Listen         string         `json:"listen"`
-	StreamSettings map[string]any `json:"streamSettings,omitempty"`
-	Sniffing       map[string]any `json:"sniffing,omitempty"`
-	Allocate       map[string]any `json:"allocate,omitempty"`
+	Tag            string          `json:"tag"`
+	Settings       XraySettings    `json:"settings"`
+	Port           json.RawMessage `json:"port"` // A number or a string with a port range or list
+	Protocol       string          `json:"protocol"`
+	Listen         string          `json:"listen"`
+	StreamSettings map[string]any  `json:"streamSettings,omitempty"`
+	Sniffing       map[string]any  `json:"sniffing,omitempty"`
+	Allocate       map[string]any  `json:"allocate,omitempty"`
+
+	raw rawFields
 }
 
 type ConfigXray struct {
@@ -42,4 +62,91 @@ type ConfigXray struct {
 	Transport        map[string]any   `json:"transport,omitempty"`
 	Observatory      map[string]any   `json:"observatory,omitempty"`
 	BurstObservatory map[string]any   `json:"burstObservatory,omitempty"`
+
+	raw rawFields
+}
+
+func (v *DisabledUsersConfigXray) UnmarshalJSON(data []byte) error {
+	type plain DisabledUsersConfigXray
+	if err := json.Unmarshal(data, (*plain)(v)); err != nil {
+		return err
+	}
+	return v.raw.decode(data, plain(*v))
+}
+
+func (v DisabledUsersConfigXray) MarshalJSON() ([]byte, error) {
+	type plain DisabledUsersConfigXray
+	return v.raw.encode(plain(v))
+}
+
+func (v *XrayClient) UnmarshalJSON(data []byte) error {
+	type plain XrayClient
+	if err := json.Unmarshal(data, (*plain)(v)); err != nil {
+		return err
+	}
+	return v.raw.decode(data, plain(*v))
+}
+
+func (v XrayClient) MarshalJSON() ([]byte, error) {
+	type plain XrayClient
+	return v.raw.encode(plain(v))
+}
+
+func (v *XraySettings) UnmarshalJSON(data []byte) error {
+	type plain XraySettings
+	if err := json.Unmarshal(data, (*plain)(v)); err != nil {
+		return err
+	}
+	return v.raw.decode(data, plain(*v))
+}
+
+func (v XraySettings) MarshalJSON() ([]byte, error) {
+	type plain XraySettings
+	return v.raw.encode(plain(v))
+}
+
+func (v *XrayInbound) UnmarshalJSON(data []byte) error {
+	type plain XrayInbound
+	if err := json.Unmarshal(data, (*plain)(v)); err != nil {
+		return err
+	}
+	return v.raw.decode(data, plain(*v))
+}
+
+func (v XrayInbound) MarshalJSON() ([]byte, error) {
+	type plain XrayInbound
+	return v.raw.encode(plain(v))
+}
+
+// PortNumber returns the port of the inbound, or the first port if it listens on a range or list
+// such as "1000-2000". It returns 0 if the port is missing or invalid.
+func (v XrayInbound) PortNumber() int {
+	var port int
+	if err := json.Unmarshal(v.Port, &port); err == nil {
+		return port
+	}
+	var ports string
+	if err := json.Unmarshal(v.Port, &ports); err != nil {
+		return 0
+	}
+	first, _, _ := strings.Cut(ports, ",")
+	first, _, _ = strings.Cut(first, "-")
+	port, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil {
+		return 0
+	}
+	return port
+}
+
+func (v *ConfigXray) UnmarshalJSON(data []byte) error {
+	type plain ConfigXray
+	if err := json.Unmarshal(data, (*plain)(v)); err != nil {
+		return err
+	}
+	return v.raw.decode(data, plain(*v))
+}
+
+func (v ConfigXray) MarshalJSON() ([]byte, error) {
+	type plain ConfigXray
+	return v.raw.encode(plain(v))
 }
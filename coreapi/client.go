@@ -0,0 +1,172 @@
+package coreapi
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"v2ray-stat/config"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Target returns the gRPC target of the core API: a unix socket if configured, otherwise a TCP address.
+func Target(cfg *config.Config) string {
+	if cfg.Core.API.Socket != "" {
+		return "unix://" + cfg.Core.API.Socket
+	}
+	return cfg.Core.API.Address
+}
+
+// transportCredentials builds transport credentials for the core API from core.api.tls.
+func transportCredentials(cfg *config.Config) (credentials.TransportCredentials, error) {
+	tlsCfg := cfg.Core.API.TLS
+	if !tlsCfg.Enabled {
+		return insecure.NewCredentials(), nil
+	}
+
+	tc := &tls.Config{
+		ServerName:         tlsCfg.ServerName,
+		InsecureSkipVerify: tlsCfg.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if tlsCfg.CAFile != "" {
+		caPEM, err := os.ReadFile(tlsCfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("failed to parse CA file %s", tlsCfg.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+
+	if tlsCfg.CertFile != "" && tlsCfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCfg.CertFile, tlsCfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	return credentials.NewTLS(tc), nil
+}
+
+// newConn creates a gRPC client connection to the core API.
+func newConn(cfg *config.Config) (*grpc.ClientConn, error) {
+	creds, err := transportCredentials(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.NewClient(Target(cfg),
+		grpc.WithTransportCredentials(creds),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff: backoff.Config{
+				BaseDelay:  time.Second,
+				Multiplier: 1.6,
+				Jitter:     0.2,
+				MaxDelay:   time.Minute,
+			},
+			MinConnectTimeout: time.Duration(cfg.Core.API.Timeout) * time.Second,
+		}),
+	)
+}
+
+// Context returns a context bounded by core.api.timeout.
+func Context(cfg *config.Config) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Duration(cfg.Core.API.Timeout)*time.Second)
+}
+
+// client keeps a single long-lived connection to the core's gRPC API. The connection is never
+// replaced while in use: grpc reconnects it with backoff when the core becomes unavailable.
+type client struct {
+	mu          sync.Mutex
+	conn        *grpc.ClientConn
+	failures    int
+	nextAttempt time.Time
+}
+
+var shared client
+
+const (
+	backoffBase = time.Second
+	backoffMax  = time.Minute
+)
+
+// Conn returns the shared connection to the core's gRPC API, creating it if needed
+// unless a backoff after a failed creation is in effect.
+func Conn(cfg *config.Config) (*grpc.ClientConn, error) {
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+
+	if shared.conn != nil {
+		return shared.conn, nil
+	}
+	if wait := time.Until(shared.nextAttempt); wait > 0 {
+		return nil, fmt.Errorf("connection to gRPC server postponed for %s", wait.Round(time.Second))
+	}
+
+	cfg.Logger.Debug("Connecting to gRPC server", "target", Target(cfg), "tls", cfg.Core.API.TLS.Enabled)
+	conn, err := newConn(cfg)
+	if err != nil {
+		shared.backoff(cfg)
+		return nil, err
+	}
+	shared.failures = 0
+	shared.conn = conn
+	return conn, nil
+}
+
+// Fail records an unavailable error of a request. The connection is kept open, since other
+// requests may still be using it and grpc reconnects it by itself.
+func Fail(cfg *config.Config, err error) {
+	if code := status.Code(err); code != codes.Unavailable && code != codes.DeadlineExceeded {
+		return
+	}
+
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+
+	shared.failures++
+	cfg.Logger.Warn("gRPC server unavailable, waiting for reconnect", "failures", shared.failures, "error", err)
+}
+
+// Succeed resets the failure count after a successful request.
+func Succeed() {
+	shared.mu.Lock()
+	shared.failures = 0
+	shared.mu.Unlock()
+}
+
+// backoff schedules the next attempt to create the connection with exponential delay. Caller must hold c.mu.
+func (c *client) backoff(cfg *config.Config) {
+	delay := backoffBase << min(c.failures, 6)
+	if delay > backoffMax {
+		delay = backoffMax
+	}
+	c.failures++
+	c.nextAttempt = time.Now().Add(delay)
+	cfg.Logger.Warn("Failed to create gRPC connection, retry scheduled", "failures", c.failures, "delay", delay)
+}
+
+// Close closes the shared connection to the core's gRPC API.
+func Close() {
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+
+	if shared.conn != nil {
+		shared.conn.Close()
+		shared.conn = nil
+	}
+}
@@ -0,0 +1,144 @@
+package coreapi
+
+import (
+	"fmt"
+	"strings"
+
+	"v2ray-stat/config"
+
+	"github.com/xtls/xray-core/app/proxyman/command"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/proxy/shadowsocks"
+	"github.com/xtls/xray-core/proxy/shadowsocks_2022"
+	"github.com/xtls/xray-core/proxy/trojan"
+	"github.com/xtls/xray-core/proxy/vless"
+	"github.com/xtls/xray-core/proxy/vmess"
+	"google.golang.org/protobuf/proto"
+)
+
+// shadowsocksCipher maps an Xray Shadowsocks method name to its cipher type.
+func shadowsocksCipher(method string) shadowsocks.CipherType {
+	switch strings.ToLower(method) {
+	case "aes-128-gcm":
+		return shadowsocks.CipherType_AES_128_GCM
+	case "aes-256-gcm":
+		return shadowsocks.CipherType_AES_256_GCM
+	case "chacha20-poly1305", "chacha20-ietf-poly1305":
+		return shadowsocks.CipherType_CHACHA20_POLY1305
+	case "xchacha20-poly1305", "xchacha20-ietf-poly1305":
+		return shadowsocks.CipherType_XCHACHA20_POLY1305
+	case "none", "plain":
+		return shadowsocks.CipherType_NONE
+	}
+	return shadowsocks.CipherType_UNKNOWN
+}
+
+// inboundAccount builds the Xray account message of a client for the inbound's protocol.
+func inboundAccount(inbound config.XrayInbound, client config.XrayClient) (proto.Message, error) {
+	switch inbound.Protocol {
+	case "vless":
+		encryption := "none"
+		if inbound.Settings.Decryption != nil && *inbound.Settings.Decryption != "" {
+			encryption = *inbound.Settings.Decryption
+		}
+		return &vless.Account{Id: client.ID, Flow: client.Flow, Encryption: encryption}, nil
+	case "vmess":
+		return &vmess.Account{
+			Id:               client.ID,
+			SecuritySettings: &protocol.SecurityConfig{Type: protocol.SecurityType_AUTO},
+		}, nil
+	case "trojan":
+		return &trojan.Account{Password: client.Password}, nil
+	case "shadowsocks":
+		method := client.Method
+		if inbound.Settings.Method != nil && *inbound.Settings.Method != "" {
+			method = *inbound.Settings.Method
+		}
+		if config.IsShadowsocks2022(method) {
+			return &shadowsocks_2022.Account{Key: client.Password}, nil
+		}
+		cipher := shadowsocksCipher(method)
+		if cipher == shadowsocks.CipherType_UNKNOWN {
+			return nil, fmt.Errorf("unsupported shadowsocks method: %s", method)
+		}
+		return &shadowsocks.Account{Password: client.Password, CipherType: cipher}, nil
+	}
+	return nil, fmt.Errorf("unsupported protocol: %s", inbound.Protocol)
+}
+
+// alterInbound sends an add or remove user operation to the running Xray inbound.
+func alterInbound(cfg *config.Config, tag string, operation proto.Message) error {
+	conn, err := Conn(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to gRPC server: %w", err)
+	}
+
+	ctx, cancel := Context(cfg)
+	defer cancel()
+
+	client := command.NewHandlerServiceClient(conn)
+	if _, err := client.AlterInbound(ctx, &command.AlterInboundRequest{
+		Tag:       tag,
+		Operation: serial.ToTypedMessage(operation),
+	}); err != nil {
+		Fail(cfg, err)
+		return err
+	}
+	Succeed()
+	return nil
+}
+
+// AddInboundUser adds a client to the running Xray inbound.
+func AddInboundUser(cfg *config.Config, inbound config.XrayInbound, client config.XrayClient) error {
+	account, err := inboundAccount(inbound, client)
+	if err != nil {
+		return err
+	}
+
+	cfg.Logger.Debug("Adding user to live inbound", "user", client.Email, "tag", inbound.Tag)
+	if err := alterInbound(cfg, inbound.Tag, &command.AddUserOperation{
+		User: &protocol.User{
+			Level:   client.Level,
+			Email:   client.Email,
+			Account: serial.ToTypedMessage(account),
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to add user %s to live inbound %s: %w", client.Email, inbound.Tag, err)
+	}
+	return nil
+}
+
+// RemoveInboundUser removes a client from the running Xray inbound.
+func RemoveInboundUser(cfg *config.Config, tag, email string) error {
+	cfg.Logger.Debug("Removing user from live inbound", "user", email, "tag", tag)
+	if err := alterInbound(cfg, tag, &command.RemoveUserOperation{Email: email}); err != nil {
+		return fmt.Errorf("failed to remove user %s from live inbound %s: %w", email, tag, err)
+	}
+	return nil
+}
+
+// InboundUsers returns the emails of the users of the running Xray inbound.
+func InboundUsers(cfg *config.Config, tag string) ([]string, error) {
+	conn, err := Conn(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to gRPC server: %w", err)
+	}
+
+	ctx, cancel := Context(cfg)
+	defer cancel()
+
+	client := command.NewHandlerServiceClient(conn)
+	resp, err := client.GetInboundUsers(ctx, &command.GetInboundUserRequest{Tag: tag})
+	if err != nil {
+		Fail(cfg, err)
+		return nil, fmt.Errorf("failed to get users of live inbound %s: %w", tag, err)
+	}
+	Succeed()
+
+	emails := make([]string, 0, len(resp.GetUsers()))
+	for _, user := range resp.GetUsers() {
+		emails = append(emails, user.GetEmail())
+	}
+	return emails, nil
+}
@@ -0,0 +1,109 @@
+package coreapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"v2ray-stat/config"
+)
+
+// LiveUpdateEnabled reports whether user changes are pushed to the running core.
+func LiveUpdateEnabled(cfg *config.Config) bool {
+	return cfg.Core.API.LiveUpdate && cfg.V2rayStat.Type == "xray"
+}
+
+// ReconcileInboundUsers makes the users of the running Xray inbounds match config.json:
+// users missing from the core are added and users not present in the file are removed.
+func ReconcileInboundUsers(cfg *config.Config) error {
+	cfg.Logger.Debug("Reconciling live inbound users with config", "path", cfg.Core.Config)
+	data, err := os.ReadFile(cfg.Core.Config)
+	if err != nil {
+		return fmt.Errorf("failed to read config.json: %v", err)
+	}
+	var cfgXray config.ConfigXray
+	if err := json.Unmarshal(data, &cfgXray); err != nil {
+		return fmt.Errorf("failed to parse JSON: %v", err)
+	}
+
+	added, removed := 0, 0
+	for _, inbound := range cfgXray.Inbounds {
+		if inbound.Tag == "" || !config.IsUserProtocol(inbound.Protocol) {
+			continue
+		}
+
+		live, err := InboundUsers(cfg, inbound.Tag)
+		if err != nil {
+			cfg.Logger.Warn("Failed to get live inbound users", "tag", inbound.Tag, "error", err)
+			continue
+		}
+		liveUsers := make(map[string]bool, len(live))
+		for _, email := range live {
+			liveUsers[email] = true
+		}
+
+		fileUsers := make(map[string]bool, len(inbound.Settings.Clients))
+		for _, client := range inbound.Settings.Clients {
+			fileUsers[client.Email] = true
+			if liveUsers[client.Email] {
+				continue
+			}
+			if err := AddInboundUser(cfg, inbound, client); err != nil {
+				cfg.Logger.Error("Failed to add missing live user", "user", client.Email, "tag", inbound.Tag, "error", err)
+				continue
+			}
+			added++
+		}
+
+		for _, email := range live {
+			if fileUsers[email] {
+				continue
+			}
+			if err := RemoveInboundUser(cfg, inbound.Tag, email); err != nil {
+				cfg.Logger.Error("Failed to remove extra live user", "user", email, "tag", inbound.Tag, "error", err)
+				continue
+			}
+			removed++
+		}
+	}
+
+	if added > 0 || removed > 0 {
+		cfg.Logger.Info("Live inbound users reconciled", "added", added, "removed", removed)
+	} else {
+		cfg.Logger.Debug("Live inbound users match config")
+	}
+	return nil
+}
+
+// MonitorReconcile reconciles live inbound users at startup and then every core.api.reconcile_interval seconds.
+func MonitorReconcile(ctx context.Context, cfg *config.Config, wg *sync.WaitGroup) {
+	cfg.Logger.Debug("Starting live inbound users reconciliation")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		if err := ReconcileInboundUsers(cfg); err != nil {
+			cfg.Logger.Error("Failed to reconcile live inbound users", "error", err)
+		}
+		if cfg.Core.API.ReconcileInterval == 0 {
+			return
+		}
+
+		ticker := time.NewTicker(time.Duration(cfg.Core.API.ReconcileInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := ReconcileInboundUsers(cfg); err != nil {
+					cfg.Logger.Error("Failed to reconcile live inbound users", "error", err)
+				}
+			case <-ctx.Done():
+				cfg.Logger.Debug("Stopped live inbound users reconciliation")
+				return
+			}
+		}
+	}()
+}
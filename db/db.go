@@ -13,35 +13,39 @@ import (
 	"sync"
 	"time"
 
+	"v2ray-stat/backup"
 	"v2ray-stat/config"
+	"v2ray-stat/coreapi"
 	"v2ray-stat/db/manager"
 	"v2ray-stat/telegram"
 )
 
-var (
-	notifiedMutex      sync.Mutex
-	notifiedUsers      = make(map[string]bool)
-	renewNotifiedUsers = make(map[string]bool)
-)
-
 // dateOffsetRegex matches formats like +2d1h, -3d, +1h, etc.
 var dateOffsetRegex = regexp.MustCompile(`^([+-]?)(\d*)d?(\d*)h?$|^0$`)
 
-// extractUsersXrayServer retrieves Xray users from config files.
-func extractUsersXrayServer(cfg *config.Config) []config.XrayClient {
+// extractUsersXrayServer retrieves Xray users from config files. Both files are read under the
+// lock of the core config files, so a user moved between them is never missed.
+func extractUsersXrayServer(cfg *config.Config) ([]config.XrayClient, error) {
 	cfg.Logger.Debug("Extracting Xray users from config")
+	unlock, err := config.LockCoreFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	clientMap := make(map[string]config.XrayClient)
 
 	extractClients := func(inbounds []config.XrayInbound) {
 		for _, inbound := range inbounds {
-			if inbound.Protocol == "vless" || inbound.Protocol == "trojan" {
+			if config.IsUserProtocol(inbound.Protocol) {
 				for _, client := range inbound.Settings.Clients {
-					if client.ID == "" {
-						cfg.Logger.Warn("Skipping client with empty ID", "email", client.Email, "protocol", inbound.Protocol)
+					credential := client.Credential(inbound.Protocol)
+					if credential == "" {
+						cfg.Logger.Warn("Skipping client with empty credential", "email", client.Email, "protocol", inbound.Protocol)
 						continue
 					}
 					cfg.Logger.Trace("Processing client", "email", client.Email, "protocol", inbound.Protocol)
-					clientMap[client.Email] = client
+					clientMap[client.Email] = config.XrayClient{Email: client.Email, ID: credential}
 				}
 			} else {
 				cfg.Logger.Debug("Skipping inbound with unsupported protocol", "protocol", inbound.Protocol)
@@ -52,15 +56,15 @@ func extractUsersXrayServer(cfg *config.Config) []config.XrayClient {
 	data, err := os.ReadFile(cfg.Core.Config)
 	if err != nil {
 		cfg.Logger.Error("Failed to read config.json", "path", cfg.Core.Config, "error", err)
-	} else {
-		var cfgXray config.ConfigXray
-		if err := json.Unmarshal(data, &cfgXray); err != nil {
-			cfg.Logger.Error("Failed to parse JSON from config.json", "error", err)
-		} else {
-			cfg.Logger.Debug("Processing Xray inbounds from config.json")
-			extractClients(cfgXray.Inbounds)
-		}
+		return nil, fmt.Errorf("failed to read config.json: %v", err)
 	}
+	var cfgXray config.ConfigXray
+	if err := json.Unmarshal(data, &cfgXray); err != nil {
+		cfg.Logger.Error("Failed to parse JSON from config.json", "error", err)
+		return nil, fmt.Errorf("failed to parse JSON from config.json: %v", err)
+	}
+	cfg.Logger.Debug("Processing Xray inbounds from config.json")
+	extractClients(cfgXray.Inbounds)
 
 	disabledUsersPath := filepath.Join(cfg.Core.Dir, ".disabled_users")
 	disabledData, err := os.ReadFile(disabledUsersPath)
@@ -69,15 +73,16 @@ func extractUsersXrayServer(cfg *config.Config) []config.XrayClient {
 			var disabledCfg config.DisabledUsersConfigXray
 			if err := json.Unmarshal(disabledData, &disabledCfg); err != nil {
 				cfg.Logger.Error("Failed to parse JSON from .disabled_users", "path", disabledUsersPath, "error", err)
-			} else {
-				cfg.Logger.Debug("Processing Xray inbounds from .disabled_users")
-				extractClients(disabledCfg.Inbounds)
+				return nil, fmt.Errorf("failed to parse JSON from .disabled_users: %v", err)
 			}
+			cfg.Logger.Debug("Processing Xray inbounds from .disabled_users")
+			extractClients(disabledCfg.Inbounds)
 		} else {
 			cfg.Logger.Warn("Empty .disabled_users file", "path", disabledUsersPath)
 		}
 	} else if !os.IsNotExist(err) {
 		cfg.Logger.Error("Failed to read .disabled_users", "path", disabledUsersPath, "error", err)
+		return nil, fmt.Errorf("failed to read .disabled_users: %v", err)
 	}
 
 	var clients []config.XrayClient
@@ -85,32 +90,32 @@ func extractUsersXrayServer(cfg *config.Config) []config.XrayClient {
 		clients = append(clients, client)
 	}
 	cfg.Logger.Debug("Extracted Xray users", "count", len(clients))
-	return clients
+	return clients, nil
 }
 
-// extractUsersSingboxServer retrieves Singbox users from config files.
-func extractUsersSingboxServer(cfg *config.Config) []config.XrayClient {
+// extractUsersSingboxServer retrieves Singbox users from config files, read under the lock of
+// the core config files.
+func extractUsersSingboxServer(cfg *config.Config) ([]config.XrayClient, error) {
 	cfg.Logger.Debug("Extracting Singbox users from config")
+	unlock, err := config.LockCoreFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	clientMap := make(map[string]config.XrayClient)
 
 	extractClients := func(inbounds []config.SingboxInbound) {
 		for _, inbound := range inbounds {
-			if inbound.Type == "vless" || inbound.Type == "trojan" {
+			if config.IsUserProtocol(inbound.Type) {
 				for _, user := range inbound.Users {
-					if (inbound.Type == "vless" && user.UUID == "") || (inbound.Type == "trojan" && user.Password == "") {
-						cfg.Logger.Warn("Skipping Singbox user with empty ID", "name", user.Name, "type", inbound.Type)
+					credential := user.Credential(inbound.Type)
+					if credential == "" {
+						cfg.Logger.Warn("Skipping Singbox user with empty credential", "name", user.Name, "type", inbound.Type)
 						continue
 					}
 					cfg.Logger.Trace("Processing Singbox user", "name", user.Name, "type", inbound.Type)
-					client := config.XrayClient{Email: user.Name}
-					switch inbound.Type {
-					case "vless":
-						client.ID = user.UUID
-					case "trojan":
-						client.ID = user.Password
-						client.Password = user.UUID
-					}
-					clientMap[user.Name] = client
+					clientMap[user.Name] = config.XrayClient{Email: user.Name, ID: credential}
 				}
 			} else {
 				cfg.Logger.Debug("Skipping inbound with unsupported type", "type", inbound.Type)
@@ -121,15 +126,15 @@ func extractUsersSingboxServer(cfg *config.Config) []config.XrayClient {
 	data, err := os.ReadFile(cfg.Core.Config)
 	if err != nil {
 		cfg.Logger.Error("Failed to read config.json for Singbox", "path", cfg.Core.Config, "error", err)
-	} else {
-		var cfgSingbox config.ConfigSingbox
-		if err := json.Unmarshal(data, &cfgSingbox); err != nil {
-			cfg.Logger.Error("Failed to parse JSON for Singbox", "error", err)
-		} else {
-			cfg.Logger.Debug("Processing Singbox inbounds from config.json")
-			extractClients(cfgSingbox.Inbounds)
-		}
+		return nil, fmt.Errorf("failed to read config.json: %v", err)
 	}
+	var cfgSingbox config.ConfigSingbox
+	if err := json.Unmarshal(data, &cfgSingbox); err != nil {
+		cfg.Logger.Error("Failed to parse JSON for Singbox", "error", err)
+		return nil, fmt.Errorf("failed to parse JSON from config.json: %v", err)
+	}
+	cfg.Logger.Debug("Processing Singbox inbounds from config.json")
+	extractClients(cfgSingbox.Inbounds)
 
 	disabledUsersPath := filepath.Join(cfg.Core.Dir, ".disabled_users")
 	disabledData, err := os.ReadFile(disabledUsersPath)
@@ -138,15 +143,16 @@ func extractUsersSingboxServer(cfg *config.Config) []config.XrayClient {
 			var disabledCfg config.DisabledUsersConfigSingbox
 			if err := json.Unmarshal(disabledData, &disabledCfg); err != nil {
 				cfg.Logger.Error("Failed to parse JSON from .disabled_users", "path", disabledUsersPath, "error", err)
-			} else {
-				cfg.Logger.Debug("Processing Singbox inbounds from .disabled_users")
-				extractClients(disabledCfg.Inbounds)
+				return nil, fmt.Errorf("failed to parse JSON from .disabled_users: %v", err)
 			}
+			cfg.Logger.Debug("Processing Singbox inbounds from .disabled_users")
+			extractClients(disabledCfg.Inbounds)
 		} else {
 			cfg.Logger.Warn("Empty .disabled_users file", "path", disabledUsersPath)
 		}
 	} else if !os.IsNotExist(err) {
 		cfg.Logger.Error("Failed to read .disabled_users", "path", disabledUsersPath, "error", err)
+		return nil, fmt.Errorf("failed to read .disabled_users: %v", err)
 	}
 
 	var clients []config.XrayClient
@@ -154,18 +160,33 @@ func extractUsersSingboxServer(cfg *config.Config) []config.XrayClient {
 		clients = append(clients, client)
 	}
 	cfg.Logger.Info("Extracted Singbox users", "count", len(clients))
-	return clients
+	return clients, nil
+}
+
+// userSyncMu keeps the config-to-database user sync away from changes that update a user in the
+// core config files and in the database in separate steps.
+var userSyncMu sync.Mutex
+
+// LockUserSync blocks AddUserToDB and DelUserFromDB until the returned function is called.
+func LockUserSync() func() {
+	userSyncMu.Lock()
+	return userSyncMu.Unlock
 }
 
 // AddUserToDB adds users to the clients_stats database table.
 func AddUserToDB(manager *manager.DatabaseManager, cfg *config.Config) error {
 	cfg.Logger.Debug("Starting to add users to database", "type", cfg.V2rayStat.Type)
+	defer LockUserSync()()
 	var clients []config.XrayClient
+	var err error
 	switch cfg.V2rayStat.Type {
 	case "xray":
-		clients = extractUsersXrayServer(cfg)
+		clients, err = extractUsersXrayServer(cfg)
 	case "singbox":
-		clients = extractUsersSingboxServer(cfg)
+		clients, err = extractUsersSingboxServer(cfg)
+	}
+	if err != nil {
+		return err
 	}
 
 	if len(clients) == 0 {
@@ -177,7 +198,7 @@ func AddUserToDB(manager *manager.DatabaseManager, cfg *config.Config) error {
 	var addedUsers []string
 	currentTime := time.Now().Format("2006-01-02-15")
 
-	err := manager.ExecuteHighPriority(func(db *sql.DB) error {
+	err = manager.ExecuteHighPriority(func(db *sql.DB) error {
 		tx, err := db.Begin()
 		if err != nil {
 			cfg.Logger.Error("Failed to start transaction", "error", err)
@@ -186,7 +207,7 @@ func AddUserToDB(manager *manager.DatabaseManager, cfg *config.Config) error {
 		defer tx.Rollback()
 
 		cfg.Logger.Debug("Preparing insert statement for users")
-		stmt, err := tx.Prepare("INSERT OR IGNORE INTO clients_stats(user, uuid, rate, enabled, created) VALUES (?, ?, ?, ?, ?)")
+		stmt, err := tx.Prepare("INSERT OR IGNORE INTO clients_stats(user, uuid, rate, enabled, created, sub_token) VALUES (?, ?, ?, ?, ?, lower(hex(randomblob(16))))")
 		if err != nil {
 			cfg.Logger.Error("Failed to prepare insert statement", "error", err)
 			return fmt.Errorf("failed to prepare statement: %v", err)
@@ -237,17 +258,22 @@ func AddUserToDB(manager *manager.DatabaseManager, cfg *config.Config) error {
 // DelUserFromDB removes users from the database if they are not in the config.
 func DelUserFromDB(manager *manager.DatabaseManager, cfg *config.Config) error {
 	cfg.Logger.Debug("Starting to remove users from database", "type", cfg.V2rayStat.Type)
+	defer LockUserSync()()
 	var clients []config.XrayClient
+	var err error
 	switch cfg.V2rayStat.Type {
 	case "xray":
-		clients = extractUsersXrayServer(cfg)
+		clients, err = extractUsersXrayServer(cfg)
 	case "singbox":
-		clients = extractUsersSingboxServer(cfg)
+		clients, err = extractUsersSingboxServer(cfg)
+	}
+	if err != nil {
+		return err
 	}
 
 	cfg.Logger.Debug("Found users in config", "count", len(clients))
 	var usersDB []string
-	err := manager.ExecuteLowPriority(func(db *sql.DB) error {
+	err = manager.ExecuteLowPriority(func(db *sql.DB) error {
 		cfg.Logger.Debug("Reading users from database")
 		rows, err := db.Query("SELECT user FROM clients_stats")
 		if err != nil {
@@ -483,10 +509,16 @@ func UpdateEnabledInDB(manager *manager.DatabaseManager, cfg *config.Config, use
 	return nil
 }
 
+// ParseSubEnd parses the sub_end value of clients_stats, which is stored as 2006-01-02-15 in
+// local time.
+func ParseSubEnd(subEnd string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02-15", subEnd, time.Local)
+}
+
 // formatDate formats the subscription end date with timezone.
 func formatDate(subEnd string, cfg *config.Config) string {
 	cfg.Logger.Debug("Formatting date", "subEnd", subEnd)
-	t, err := time.ParseInLocation("2006-01-02-15", subEnd, time.Local)
+	t, err := ParseSubEnd(subEnd)
 	if err != nil {
 		cfg.Logger.Error("Failed to parse date", "subEnd", subEnd, "error", err)
 		return subEnd
@@ -614,11 +646,12 @@ func AdjustDateOffset(manager *manager.DatabaseManager, cfg *config.Config, user
 
 // Subscription represents subscription data from clients_stats table.
 type Subscription struct {
-	User    string
-	SubEnd  string
-	UUID    string
-	Enabled string
-	Renew   int
+	User           string
+	SubEnd         string
+	UUID           string
+	Enabled        string
+	Renew          int
+	DisabledReason string
 }
 
 // CheckExpiredSubscriptions checks for expired subscriptions and updates user statuses.
@@ -627,7 +660,7 @@ func CheckExpiredSubscriptions(manager *manager.DatabaseManager, cfg *config.Con
 	var subscriptions []Subscription
 	err := manager.ExecuteLowPriority(func(db *sql.DB) error {
 		cfg.Logger.Debug("Reading subscriptions from clients_stats")
-		rows, err := db.Query("SELECT user, sub_end, uuid, enabled, renew FROM clients_stats WHERE sub_end IS NOT NULL")
+		rows, err := db.Query("SELECT user, sub_end, uuid, enabled, renew, disabled_reason FROM clients_stats WHERE sub_end IS NOT NULL")
 		if err != nil {
 			cfg.Logger.Error("Failed to query database", "error", err)
 			return fmt.Errorf("failed to query database: %v", err)
@@ -636,7 +669,7 @@ func CheckExpiredSubscriptions(manager *manager.DatabaseManager, cfg *config.Con
 
 		for rows.Next() {
 			var s Subscription
-			if err := rows.Scan(&s.User, &s.SubEnd, &s.UUID, &s.Enabled, &s.Renew); err != nil {
+			if err := rows.Scan(&s.User, &s.SubEnd, &s.UUID, &s.Enabled, &s.Renew, &s.DisabledReason); err != nil {
 				cfg.Logger.Error("Failed to scan row", "error", err)
 				continue
 			}
@@ -664,10 +697,17 @@ func CheckExpiredSubscriptions(manager *manager.DatabaseManager, cfg *config.Con
 		cfg.Logger.Warn("Telegram notifications not configured")
 	}
 
+	notifiedUsers, err := LoadNotificationState(manager, cfg, NotificationKindExpired)
+	if err != nil {
+		return err
+	}
+
+	// Users are toggled after the loop so that the config files are rewritten once
+	var enableUsers, disableUsers []string
 	for _, s := range subscriptions {
 		if s.SubEnd != "" {
 			cfg.Logger.Trace("Processing subscription", "user", s.User, "sub_end", s.SubEnd)
-			subEnd, err := time.Parse("2006-01-02-15", s.SubEnd)
+			subEnd, err := ParseSubEnd(s.SubEnd)
 			if err != nil {
 				cfg.Logger.Error("Failed to parse date", "user", s.User, "sub_end", s.SubEnd, "error", err)
 				continue
@@ -675,7 +715,7 @@ func CheckExpiredSubscriptions(manager *manager.DatabaseManager, cfg *config.Con
 
 			if subEnd.Before(time.Now()) {
 				cfg.Logger.Debug("Subscription expired", "user", s.User, "sub_end", s.SubEnd)
-				if canSendNotifications && !notifiedUsers[s.User] {
+				if canSendNotifications && notifiedUsers[s.User] == "" {
 					formattedDate := formatDate(s.SubEnd, cfg)
 					message := fmt.Sprintf(
 						"❌ Subscription expired\n\n"+
@@ -684,9 +724,9 @@ func CheckExpiredSubscriptions(manager *manager.DatabaseManager, cfg *config.Con
 						s.User, formattedDate)
 					cfg.Logger.Trace("Sending expiration notification", "user", s.User)
 					if err := telegram.SendNotification(cfg, message); err == nil {
-						notifiedMutex.Lock()
-						notifiedUsers[s.User] = true
-						notifiedMutex.Unlock()
+						if err := SetNotificationState(manager, cfg, NotificationKindExpired, s.User, s.SubEnd); err != nil {
+							cfg.Logger.Error("Failed to store expiration notification state", "user", s.User, "error", err)
+						}
 						cfg.Logger.Info("Expiration notification sent", "user", s.User)
 					} else {
 						cfg.Logger.Error("Failed to send notification", "user", s.User, "error", err)
@@ -704,7 +744,6 @@ func CheckExpiredSubscriptions(manager *manager.DatabaseManager, cfg *config.Con
 					cfg.Logger.Info("Subscription auto-renewed", "user", s.User, "renew_days", s.Renew)
 
 					if canSendNotifications {
-						notifiedMutex.Lock()
 						message := fmt.Sprintf(
 							"✅ Subscription renewed\n\n"+
 								"Client:   *%s*\n"+
@@ -712,74 +751,77 @@ func CheckExpiredSubscriptions(manager *manager.DatabaseManager, cfg *config.Con
 							s.User, s.Renew)
 						cfg.Logger.Warn("Sending renewal notification", "user", s.User, "message", message)
 						if err := telegram.SendNotification(cfg, message); err == nil {
-							renewNotifiedUsers[s.User] = true
+							if err := SetNotificationState(manager, cfg, NotificationKindRenewed, s.User, s.SubEnd); err != nil {
+								cfg.Logger.Error("Failed to store renewal notification state", "user", s.User, "error", err)
+							}
 							cfg.Logger.Info("Renewal notification sent", "user", s.User)
 						} else {
 							cfg.Logger.Error("Failed to send renewal notification", "user", s.User, "error", err)
 						}
-						notifiedMutex.Unlock()
 					}
 
-					notifiedMutex.Lock()
-					notifiedUsers[s.User] = false
-					renewNotifiedUsers[s.User] = false
-					notifiedMutex.Unlock()
-
-					if s.Enabled == "false" {
-						cfg.Logger.Warn("Enabling user", "user", s.User)
-						err = ToggleUserEnabled(manager, cfg, s.User, true)
-						if err != nil {
-							cfg.Logger.Error("Failed to enable user", "user", s.User, "error", err)
-							continue
-						}
-						err = UpdateEnabledInDB(manager, cfg, s.User, true)
-						if err != nil {
-							cfg.Logger.Error("Failed to update enabled status", "user", s.User, "error", err)
-							continue
-						}
-						cfg.Logger.Warn("User enabled after renewal", "user", s.User)
+					if _, err := ClearNotificationState(manager, cfg, NotificationKindExpired, s.User); err != nil {
+						cfg.Logger.Error("Failed to reset expiration notification state", "user", s.User, "error", err)
+					}
+
+					if s.Enabled == "false" && s.DisabledReason != DisabledReasonTrafficLimit {
+						cfg.Logger.Warn("Enabling user after renewal", "user", s.User)
+						enableUsers = append(enableUsers, s.User)
 					}
 				} else {
 					cfg.Logger.Warn("No auto-renewal for user, renew value is not set or zero", "user", s.User, "renew", s.Renew)
 					if s.Enabled == "true" {
 						cfg.Logger.Warn("Disabling user", "user", s.User)
-						err = ToggleUserEnabled(manager, cfg, s.User, false)
-						if err != nil {
-							cfg.Logger.Error("Failed to disable user", "user", s.User, "error", err)
-							continue
-						}
-						err = UpdateEnabledInDB(manager, cfg, s.User, false)
-						if err != nil {
-							cfg.Logger.Error("Failed to update enabled status", "user", s.User, "error", err)
-							continue
-						}
-						cfg.Logger.Info("User disabled", "user", s.User)
+						disableUsers = append(disableUsers, s.User)
 					}
 				}
 			} else {
 				cfg.Logger.Debug("Subscription is active", "user", s.User, "sub_end", s.SubEnd, "renew", s.Renew)
-				if s.Enabled == "false" {
-					cfg.Logger.Debug("Enabling user with active subscription", "user", s.User)
-					err = ToggleUserEnabled(manager, cfg, s.User, true)
-					if err != nil {
-						cfg.Logger.Error("Failed to enable user", "user", s.User, "error", err)
-						continue
+				// The subscription was extended, so the next expiration is notified again
+				if notifiedUsers[s.User] != "" {
+					if _, err := ClearNotificationState(manager, cfg, NotificationKindExpired, s.User); err != nil {
+						cfg.Logger.Error("Failed to reset expiration notification state", "user", s.User, "error", err)
 					}
-					err = UpdateEnabledInDB(manager, cfg, s.User, true)
-					if err != nil {
-						cfg.Logger.Error("Failed to update enabled status", "user", s.User, "error", err)
-						continue
-					}
-					cfg.Logger.Info("Subscription active, user enabled", "user", s.User, "sub_end", s.SubEnd)
+				}
+				if s.Enabled == "false" && s.DisabledReason != DisabledReasonTrafficLimit {
+					cfg.Logger.Debug("Enabling user with active subscription", "user", s.User, "sub_end", s.SubEnd)
+					enableUsers = append(enableUsers, s.User)
 				}
 			}
 		}
 	}
 
+	toggleSubscriptionUsers(manager, cfg, enableUsers, true)
+	toggleSubscriptionUsers(manager, cfg, disableUsers, false)
+
 	cfg.Logger.Debug("Finished checking expired subscriptions")
 	return nil
 }
 
+// toggleSubscriptionUsers sets the enabled status of users in the config files and the database.
+func toggleSubscriptionUsers(manager *manager.DatabaseManager, cfg *config.Config, users []string, enabled bool) {
+	if len(users) == 0 {
+		return
+	}
+	failed, err := ToggleUsersEnabled(manager, cfg, users, enabled)
+	if err != nil {
+		cfg.Logger.Error("Failed to toggle users", "count", len(users), "enabled", enabled, "error", err)
+		return
+	}
+	backup.Snapshot(cfg, "subscription check")
+	for _, user := range users {
+		if err := failed[user]; err != nil {
+			cfg.Logger.Error("Failed to toggle user", "user", user, "enabled", enabled, "error", err)
+			continue
+		}
+		if err := UpdateEnabledInDB(manager, cfg, user, enabled); err != nil {
+			cfg.Logger.Error("Failed to update enabled status", "user", user, "error", err)
+			continue
+		}
+		cfg.Logger.Info("User enabled status updated by subscription", "user", user, "enabled", enabled)
+	}
+}
+
 // CleanInvalidTrafficTags removes non-existent tags from traffic_stats table.
 func CleanInvalidTrafficTags(manager *manager.DatabaseManager, cfg *config.Config) error {
 	cfg.Logger.Debug("Starting to clean invalid traffic tags")
@@ -924,7 +966,24 @@ func CleanInvalidTrafficTags(manager *manager.DatabaseManager, cfg *config.Confi
 
 // ToggleUserEnabled toggles the enabled status of a user in the config files.
 func ToggleUserEnabled(manager *manager.DatabaseManager, cfg *config.Config, userIdentifier string, enabled bool) error {
-	cfg.Logger.Debug("Toggling user enabled status", "user", userIdentifier, "enabled", enabled)
+	failed, err := ToggleUsersEnabled(manager, cfg, []string{userIdentifier}, enabled)
+	if err != nil {
+		return err
+	}
+	return failed[userIdentifier]
+}
+
+// ToggleUsersEnabled toggles the enabled status of several users, reading and writing the config
+// files once. It returns the errors of users that could not be toggled by user name; the error
+// is only set if the config files could not be read or written.
+func ToggleUsersEnabled(manager *manager.DatabaseManager, cfg *config.Config, users []string, enabled bool) (map[string]error, error) {
+	cfg.Logger.Debug("Toggling users enabled status", "count", len(users), "enabled", enabled)
+	unlock, err := config.LockCoreFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	mainConfigPath := cfg.Core.Config
 	disabledUsersPath := filepath.Join(cfg.Core.Dir, ".disabled_users")
 
@@ -932,19 +991,19 @@ func ToggleUserEnabled(manager *manager.DatabaseManager, cfg *config.Config, use
 	if enabled {
 		status = "enabled"
 	}
-	cfg.Logger.Trace("User status", "user", userIdentifier, "status", status)
+	failed := make(map[string]error)
 
 	switch cfg.V2rayStat.Type {
 	case "xray":
 		mainConfigData, err := os.ReadFile(mainConfigPath)
 		if err != nil {
 			cfg.Logger.Error("Failed to read Xray main config", "path", mainConfigPath, "error", err)
-			return fmt.Errorf("error reading Xray main config: %v", err)
+			return nil, fmt.Errorf("error reading Xray main config: %v", err)
 		}
 		var mainConfig config.ConfigXray
 		if err := json.Unmarshal(mainConfigData, &mainConfig); err != nil {
 			cfg.Logger.Error("Failed to parse Xray main config", "error", err)
-			return fmt.Errorf("error parsing Xray main config: %v", err)
+			return nil, fmt.Errorf("error parsing Xray main config: %v", err)
 		}
 
 		var disabledConfig config.DisabledUsersConfigXray
@@ -955,7 +1014,7 @@ func ToggleUserEnabled(manager *manager.DatabaseManager, cfg *config.Config, use
 				disabledConfig = config.DisabledUsersConfigXray{Inbounds: []config.XrayInbound{}}
 			} else {
 				cfg.Logger.Error("Failed to read Xray disabled users file", "path", disabledUsersPath, "error", err)
-				return fmt.Errorf("error reading Xray disabled users file: %v", err)
+				return nil, fmt.Errorf("error reading Xray disabled users file: %v", err)
 			}
 		} else if len(disabledConfigData) == 0 {
 			cfg.Logger.Warn("Empty disabled users file", "path", disabledUsersPath)
@@ -963,120 +1022,134 @@ func ToggleUserEnabled(manager *manager.DatabaseManager, cfg *config.Config, use
 		} else {
 			if err := json.Unmarshal(disabledConfigData, &disabledConfig); err != nil {
 				cfg.Logger.Error("Failed to parse Xray disabled users file", "error", err)
-				return fmt.Errorf("error parsing Xray disabled users file: %v", err)
+				return nil, fmt.Errorf("error parsing Xray disabled users file: %v", err)
 			}
 		}
 
-		sourceInbounds := mainConfig.Inbounds
-		targetInbounds := disabledConfig.Inbounds
-		if enabled {
-			sourceInbounds = disabledConfig.Inbounds
-			targetInbounds = mainConfig.Inbounds
-		}
+		movedUsers := make(map[string]map[string]config.XrayClient)
+		for _, userIdentifier := range users {
+			cfg.Logger.Trace("User status", "user", userIdentifier, "status", status)
+			sourceInbounds := mainConfig.Inbounds
+			targetInbounds := disabledConfig.Inbounds
+			if enabled {
+				sourceInbounds = disabledConfig.Inbounds
+				targetInbounds = mainConfig.Inbounds
+			}
 
-		userMap := make(map[string]config.XrayClient)
-		found := false
-		for i, inbound := range sourceInbounds {
-			if inbound.Protocol == "vless" || inbound.Protocol == "trojan" {
-				newClients := make([]config.XrayClient, 0, len(inbound.Settings.Clients))
-				clientMap := make(map[string]bool)
-				for _, client := range inbound.Settings.Clients {
-					cfg.Logger.Trace("Processing client in inbound", "tag", inbound.Tag, "email", client.Email)
-					if client.Email == userIdentifier {
-						if !clientMap[client.Email] {
-							userMap[inbound.Tag] = client
-							clientMap[client.Email] = true
-							found = true
-						}
-					} else {
-						if !clientMap[client.Email] {
-							newClients = append(newClients, client)
-							clientMap[client.Email] = true
+			if _, found := findUserXray(sourceInbounds, userIdentifier); !found {
+				cfg.Logger.Error("User not found in inbounds with supported protocols", "user", userIdentifier)
+				failed[userIdentifier] = fmt.Errorf("user %s not found in inbounds with supported protocols", userIdentifier)
+				continue
+			}
+			if tag, found := findUserXray(targetInbounds, userIdentifier); found {
+				cfg.Logger.Error("User already exists in target Xray config", "user", userIdentifier, "tag", tag)
+				failed[userIdentifier] = fmt.Errorf("user %s already exists in target Xray config with tag %s", userIdentifier, tag)
+				continue
+			}
+
+			userMap := make(map[string]config.XrayClient)
+			for i, inbound := range sourceInbounds {
+				if config.IsUserProtocol(inbound.Protocol) {
+					newClients := make([]config.XrayClient, 0, len(inbound.Settings.Clients))
+					clientMap := make(map[string]bool)
+					for _, client := range inbound.Settings.Clients {
+						cfg.Logger.Trace("Processing client in inbound", "tag", inbound.Tag, "email", client.Email)
+						if client.Email == userIdentifier {
+							if !clientMap[client.Email] {
+								userMap[inbound.Tag] = client
+								clientMap[client.Email] = true
+							}
+						} else {
+							if !clientMap[client.Email] {
+								newClients = append(newClients, client)
+								clientMap[client.Email] = true
+							}
 						}
 					}
+					sourceInbounds[i].Settings.Clients = newClients
 				}
-				sourceInbounds[i].Settings.Clients = newClients
 			}
-		}
-
-		if !found {
-			cfg.Logger.Error("User not found in inbounds with vless or trojan protocols", "user", userIdentifier)
-			return fmt.Errorf("user %s not found in inbounds with vless or trojan protocols", userIdentifier)
-		}
 
-		for _, inbound := range targetInbounds {
-			if inbound.Protocol == "vless" || inbound.Protocol == "trojan" {
-				for _, client := range inbound.Settings.Clients {
-					if client.Email == userIdentifier {
-						cfg.Logger.Error("User already exists in target Xray config", "user", userIdentifier, "tag", inbound.Tag)
-						return fmt.Errorf("user %s already exists in target Xray config with tag %s", userIdentifier, inbound.Tag)
+			for i, inbound := range targetInbounds {
+				if config.IsUserProtocol(inbound.Protocol) {
+					if client, exists := userMap[inbound.Tag]; exists {
+						clientMap := make(map[string]bool)
+						newClients := make([]config.XrayClient, 0, len(inbound.Settings.Clients)+1)
+						for _, c := range inbound.Settings.Clients {
+							if !clientMap[c.Email] {
+								newClients = append(newClients, c)
+								clientMap[c.Email] = true
+							}
+						}
+						if !clientMap[userIdentifier] {
+							newClients = append(newClients, client)
+							cfg.Logger.Debug("User set to status in inbound", "user", userIdentifier, "status", status, "tag", inbound.Tag)
+						}
+						targetInbounds[i].Settings.Clients = newClients
 					}
 				}
 			}
-		}
 
-		for i, inbound := range targetInbounds {
-			if inbound.Protocol == "vless" || inbound.Protocol == "trojan" {
-				if client, exists := userMap[inbound.Tag]; exists {
-					clientMap := make(map[string]bool)
-					newClients := make([]config.XrayClient, 0, len(inbound.Settings.Clients)+1)
-					for _, c := range inbound.Settings.Clients {
-						if !clientMap[c.Email] {
-							newClients = append(newClients, c)
-							clientMap[c.Email] = true
-						}
+			for _, mainInbound := range mainConfig.Inbounds {
+				if config.IsUserProtocol(mainInbound.Protocol) && !hasInboundXray(targetInbounds, mainInbound.Tag) {
+					if client, exists := userMap[mainInbound.Tag]; exists {
+						newInbound := mainInbound
+						newInbound.Settings.Clients = []config.XrayClient{client}
+						targetInbounds = append(targetInbounds, newInbound)
+						cfg.Logger.Info("Created new inbound for user", "tag", newInbound.Tag, "user", userIdentifier)
 					}
-					if !clientMap[userIdentifier] {
-						newClients = append(newClients, client)
-						cfg.Logger.Debug("User set to status in inbound", "user", userIdentifier, "status", status, "tag", inbound.Tag)
-					}
-					targetInbounds[i].Settings.Clients = newClients
 				}
 			}
-		}
 
-		for _, mainInbound := range mainConfig.Inbounds {
-			if (mainInbound.Protocol == "vless" || mainInbound.Protocol == "trojan") && !hasInboundXray(targetInbounds, mainInbound.Tag) {
-				if client, exists := userMap[mainInbound.Tag]; exists {
-					newInbound := mainInbound
-					newInbound.Settings.Clients = []config.XrayClient{client}
-					targetInbounds = append(targetInbounds, newInbound)
-					cfg.Logger.Info("Created new inbound for user", "tag", newInbound.Tag, "user", userIdentifier)
-				}
+			if enabled {
+				mainConfig.Inbounds = targetInbounds
+				disabledConfig.Inbounds = sourceInbounds
+			} else {
+				mainConfig.Inbounds = sourceInbounds
+				disabledConfig.Inbounds = targetInbounds
 			}
+			movedUsers[userIdentifier] = userMap
 		}
-
-		if enabled {
-			mainConfig.Inbounds = targetInbounds
-			disabledConfig.Inbounds = sourceInbounds
-		} else {
-			mainConfig.Inbounds = sourceInbounds
-			disabledConfig.Inbounds = targetInbounds
+		if len(movedUsers) == 0 {
+			return failed, nil
 		}
 
-		mainConfigData, err = json.MarshalIndent(mainConfig, "", "  ")
+		mainConfigData, err = config.MarshalCoreConfig(mainConfig)
 		if err != nil {
 			cfg.Logger.Error("Failed to serialize Xray main config", "error", err)
-			return fmt.Errorf("error serializing Xray main config: %v", err)
+			return nil, fmt.Errorf("error serializing Xray main config: %v", err)
 		}
-		if err := os.WriteFile(mainConfigPath, mainConfigData, 0644); err != nil {
-			cfg.Logger.Error("Failed to write Xray main config", "path", mainConfigPath, "error", err)
-			return fmt.Errorf("error writing Xray main config: %v", err)
-		}
-
+		disabledConfigData = nil
 		if len(disabledConfig.Inbounds) > 0 {
-			disabledConfigData, err = json.MarshalIndent(disabledConfig, "", "  ")
+			disabledConfigData, err = config.MarshalCoreConfig(disabledConfig)
 			if err != nil {
 				cfg.Logger.Error("Failed to serialize Xray disabled users file", "error", err)
-				return fmt.Errorf("error serializing Xray disabled users file: %v", err)
+				return nil, fmt.Errorf("error serializing Xray disabled users file: %v", err)
 			}
-			if err := os.WriteFile(disabledUsersPath, disabledConfigData, 0644); err != nil {
-				cfg.Logger.Error("Failed to write Xray disabled users file", "path", disabledUsersPath, "error", err)
-				return fmt.Errorf("error writing Xray disabled users file: %v", err)
-			}
-		} else {
-			if err := os.Remove(disabledUsersPath); err != nil && !os.IsNotExist(err) {
-				cfg.Logger.Error("Failed to remove empty .disabled_users for Xray", "error", err)
+		}
+		if err := config.WriteCoreFiles(cfg, mainConfigData, disabledConfigData); err != nil {
+			cfg.Logger.Error("Failed to write Xray config files", "error", err)
+			return nil, fmt.Errorf("error writing Xray config files: %w", err)
+		}
+
+		if coreapi.LiveUpdateEnabled(cfg) {
+			for userIdentifier, userMap := range movedUsers {
+				for tag, client := range userMap {
+					var err error
+					if enabled {
+						for _, inbound := range mainConfig.Inbounds {
+							if inbound.Tag == tag {
+								err = coreapi.AddInboundUser(cfg, inbound, client)
+								break
+							}
+						}
+					} else {
+						err = coreapi.RemoveInboundUser(cfg, tag, userIdentifier)
+					}
+					if err != nil {
+						cfg.Logger.Error("Failed to apply user status to running core", "user", userIdentifier, "tag", tag, "enabled", enabled, "error", err)
+					}
+				}
 			}
 		}
 
@@ -1084,12 +1157,12 @@ func ToggleUserEnabled(manager *manager.DatabaseManager, cfg *config.Config, use
 		mainConfigData, err := os.ReadFile(mainConfigPath)
 		if err != nil {
 			cfg.Logger.Error("Failed to read Singbox main config", "path", mainConfigPath, "error", err)
-			return fmt.Errorf("error reading Singbox main config: %v", err)
+			return nil, fmt.Errorf("error reading Singbox main config: %v", err)
 		}
 		var mainConfig config.ConfigSingbox
 		if err := json.Unmarshal(mainConfigData, &mainConfig); err != nil {
 			cfg.Logger.Error("Failed to parse Singbox main config", "error", err)
-			return fmt.Errorf("error parsing Singbox main config: %v", err)
+			return nil, fmt.Errorf("error parsing Singbox main config: %v", err)
 		}
 
 		var disabledConfig config.DisabledUsersConfigSingbox
@@ -1100,7 +1173,7 @@ func ToggleUserEnabled(manager *manager.DatabaseManager, cfg *config.Config, use
 				disabledConfig = config.DisabledUsersConfigSingbox{Inbounds: []config.SingboxInbound{}}
 			} else {
 				cfg.Logger.Error("Failed to read Singbox disabled users file", "path", disabledUsersPath, "error", err)
-				return fmt.Errorf("error reading Singbox disabled users file: %v", err)
+				return nil, fmt.Errorf("error reading Singbox disabled users file: %v", err)
 			}
 		} else if len(disabledConfigData) == 0 {
 			cfg.Logger.Warn("Empty disabled users file", "path", disabledUsersPath)
@@ -1108,126 +1181,147 @@ func ToggleUserEnabled(manager *manager.DatabaseManager, cfg *config.Config, use
 		} else {
 			if err := json.Unmarshal(disabledConfigData, &disabledConfig); err != nil {
 				cfg.Logger.Error("Failed to parse Singbox disabled users file", "error", err)
-				return fmt.Errorf("error parsing Singbox disabled users file: %v", err)
+				return nil, fmt.Errorf("error parsing Singbox disabled users file: %v", err)
 			}
 		}
 
-		sourceInbounds := mainConfig.Inbounds
-		targetInbounds := disabledConfig.Inbounds
-		if enabled {
-			sourceInbounds = disabledConfig.Inbounds
-			targetInbounds = mainConfig.Inbounds
-		}
+		movedCount := 0
+		for _, userIdentifier := range users {
+			cfg.Logger.Trace("User status", "user", userIdentifier, "status", status)
+			sourceInbounds := mainConfig.Inbounds
+			targetInbounds := disabledConfig.Inbounds
+			if enabled {
+				sourceInbounds = disabledConfig.Inbounds
+				targetInbounds = mainConfig.Inbounds
+			}
 
-		userMap := make(map[string]config.SingboxClient)
-		found := false
-		for i, inbound := range sourceInbounds {
-			if inbound.Type == "vless" || inbound.Type == "trojan" {
-				newUsers := make([]config.SingboxClient, 0, len(inbound.Users))
-				userNameMap := make(map[string]bool)
-				for _, user := range inbound.Users {
-					cfg.Logger.Trace("Processing user in inbound", "tag", inbound.Tag, "name", user.Name)
-					if user.Name == userIdentifier {
-						if !userNameMap[user.Name] {
-							userMap[inbound.Tag] = user
-							userNameMap[user.Name] = true
-							found = true
-						}
-					} else {
-						if !userNameMap[user.Name] {
-							newUsers = append(newUsers, user)
-							userNameMap[user.Name] = true
+			if _, found := findUserSingbox(sourceInbounds, userIdentifier); !found {
+				cfg.Logger.Error("User not found in inbounds with supported protocols for Singbox", "user", userIdentifier)
+				failed[userIdentifier] = fmt.Errorf("user %s not found in inbounds with supported protocols for Singbox", userIdentifier)
+				continue
+			}
+			if tag, found := findUserSingbox(targetInbounds, userIdentifier); found {
+				cfg.Logger.Error("User already exists in target Singbox config", "user", userIdentifier, "tag", tag)
+				failed[userIdentifier] = fmt.Errorf("user %s already exists in target Singbox config with tag %s", userIdentifier, tag)
+				continue
+			}
+
+			userMap := make(map[string]config.SingboxClient)
+			for i, inbound := range sourceInbounds {
+				if config.IsUserProtocol(inbound.Type) {
+					newUsers := make([]config.SingboxClient, 0, len(inbound.Users))
+					userNameMap := make(map[string]bool)
+					for _, user := range inbound.Users {
+						cfg.Logger.Trace("Processing user in inbound", "tag", inbound.Tag, "name", user.Name)
+						if user.Name == userIdentifier {
+							if !userNameMap[user.Name] {
+								userMap[inbound.Tag] = user
+								userNameMap[user.Name] = true
+							}
+						} else {
+							if !userNameMap[user.Name] {
+								newUsers = append(newUsers, user)
+								userNameMap[user.Name] = true
+							}
 						}
 					}
+					sourceInbounds[i].Users = newUsers
 				}
-				sourceInbounds[i].Users = newUsers
 			}
-		}
-
-		if !found {
-			cfg.Logger.Error("User not found in inbounds with vless or trojan protocols for Singbox", "user", userIdentifier)
-			return fmt.Errorf("user %s not found in inbounds with vless or trojan protocols for Singbox", userIdentifier)
-		}
 
-		for _, inbound := range targetInbounds {
-			if inbound.Type == "vless" || inbound.Type == "trojan" {
-				for _, user := range inbound.Users {
-					if user.Name == userIdentifier {
-						cfg.Logger.Error("User already exists in target Singbox config", "user", userIdentifier, "tag", inbound.Tag)
-						return fmt.Errorf("user %s already exists in target Singbox config with tag %s", userIdentifier, inbound.Tag)
+			for i, inbound := range targetInbounds {
+				if config.IsUserProtocol(inbound.Type) {
+					if user, exists := userMap[inbound.Tag]; exists {
+						userNameMap := make(map[string]bool)
+						newUsers := make([]config.SingboxClient, 0, len(inbound.Users)+1)
+						for _, u := range inbound.Users {
+							if !userNameMap[u.Name] {
+								newUsers = append(newUsers, u)
+								userNameMap[u.Name] = true
+							}
+						}
+						if !userNameMap[userIdentifier] {
+							newUsers = append(newUsers, user)
+							cfg.Logger.Debug("User set to status in inbound", "user", userIdentifier, "status", status, "tag", inbound.Tag)
+						}
+						targetInbounds[i].Users = newUsers
 					}
 				}
 			}
-		}
 
-		for i, inbound := range targetInbounds {
-			if inbound.Type == "vless" || inbound.Type == "trojan" {
-				if user, exists := userMap[inbound.Tag]; exists {
-					userNameMap := make(map[string]bool)
-					newUsers := make([]config.SingboxClient, 0, len(inbound.Users)+1)
-					for _, u := range inbound.Users {
-						if !userNameMap[u.Name] {
-							newUsers = append(newUsers, u)
-							userNameMap[u.Name] = true
-						}
+			for _, mainInbound := range mainConfig.Inbounds {
+				if config.IsUserProtocol(mainInbound.Type) && !hasInboundSingbox(targetInbounds, mainInbound.Tag) {
+					if user, exists := userMap[mainInbound.Tag]; exists {
+						newInbound := mainInbound
+						newInbound.Users = []config.SingboxClient{user}
+						targetInbounds = append(targetInbounds, newInbound)
+						cfg.Logger.Info("Created new inbound for user", "tag", newInbound.Tag, "user", userIdentifier)
 					}
-					if !userNameMap[userIdentifier] {
-						newUsers = append(newUsers, user)
-						cfg.Logger.Debug("User set to status in inbound", "user", userIdentifier, "status", status, "tag", inbound.Tag)
-					}
-					targetInbounds[i].Users = newUsers
 				}
 			}
-		}
 
-		for _, mainInbound := range mainConfig.Inbounds {
-			if (mainInbound.Type == "vless" || mainInbound.Type == "trojan") && !hasInboundSingbox(targetInbounds, mainInbound.Tag) {
-				if user, exists := userMap[mainInbound.Tag]; exists {
-					newInbound := mainInbound
-					newInbound.Users = []config.SingboxClient{user}
-					targetInbounds = append(targetInbounds, newInbound)
-					cfg.Logger.Info("Created new inbound for user", "tag", newInbound.Tag, "user", userIdentifier)
-				}
+			if enabled {
+				mainConfig.Inbounds = targetInbounds
+				disabledConfig.Inbounds = sourceInbounds
+			} else {
+				mainConfig.Inbounds = sourceInbounds
+				disabledConfig.Inbounds = targetInbounds
 			}
+			movedCount++
 		}
-
-		if enabled {
-			mainConfig.Inbounds = targetInbounds
-			disabledConfig.Inbounds = sourceInbounds
-		} else {
-			mainConfig.Inbounds = sourceInbounds
-			disabledConfig.Inbounds = targetInbounds
+		if movedCount == 0 {
+			return failed, nil
 		}
 
-		mainConfigData, err = json.MarshalIndent(mainConfig, "", "  ")
+		mainConfigData, err = config.MarshalCoreConfig(mainConfig)
 		if err != nil {
 			cfg.Logger.Error("Failed to serialize Singbox main config", "error", err)
-			return fmt.Errorf("error serializing Singbox main config: %v", err)
-		}
-		if err := os.WriteFile(mainConfigPath, mainConfigData, 0644); err != nil {
-			cfg.Logger.Error("Failed to write Singbox main config", "path", mainConfigPath, "error", err)
-			return fmt.Errorf("error writing Singbox main config: %v", err)
+			return nil, fmt.Errorf("error serializing Singbox main config: %v", err)
 		}
-
+		disabledConfigData = nil
 		if len(disabledConfig.Inbounds) > 0 {
-			disabledConfigData, err = json.MarshalIndent(disabledConfig, "", "  ")
+			disabledConfigData, err = config.MarshalCoreConfig(disabledConfig)
 			if err != nil {
 				cfg.Logger.Error("Failed to serialize Singbox disabled users file", "error", err)
-				return fmt.Errorf("error serializing Singbox disabled users file: %v", err)
-			}
-			if err := os.WriteFile(disabledUsersPath, disabledConfigData, 0644); err != nil {
-				cfg.Logger.Error("Failed to write Singbox disabled users file", "path", disabledUsersPath, "error", err)
-				return fmt.Errorf("error writing Singbox disabled users file: %v", err)
+				return nil, fmt.Errorf("error serializing Singbox disabled users file: %v", err)
 			}
-		} else {
-			if err := os.Remove(disabledUsersPath); err != nil && !os.IsNotExist(err) {
-				cfg.Logger.Error("Failed to remove empty .disabled_users for Singbox", "error", err)
+		}
+		if err := config.WriteCoreFiles(cfg, mainConfigData, disabledConfigData); err != nil {
+			cfg.Logger.Error("Failed to write Singbox config files", "error", err)
+			return nil, fmt.Errorf("error writing Singbox config files: %w", err)
+		}
+	}
+
+	cfg.Logger.Debug("Users enabled status toggled", "count", len(users)-len(failed), "failed", len(failed), "enabled", enabled)
+	return failed, nil
+}
+
+// findUserXray returns the tag of the first inbound with a supported protocol that contains the user.
+func findUserXray(inbounds []config.XrayInbound, userIdentifier string) (string, bool) {
+	for _, inbound := range inbounds {
+		if config.IsUserProtocol(inbound.Protocol) {
+			for _, client := range inbound.Settings.Clients {
+				if client.Email == userIdentifier {
+					return inbound.Tag, true
+				}
 			}
 		}
 	}
+	return "", false
+}
 
-	cfg.Logger.Debug("User enabled status toggled", "user", userIdentifier, "enabled", enabled)
-	return nil
+// findUserSingbox returns the tag of the first inbound with a supported protocol that contains the user.
+func findUserSingbox(inbounds []config.SingboxInbound, userIdentifier string) (string, bool) {
+	for _, inbound := range inbounds {
+		if config.IsUserProtocol(inbound.Type) {
+			for _, user := range inbound.Users {
+				if user.Name == userIdentifier {
+					return inbound.Tag, true
+				}
+			}
+		}
+	}
+	return "", false
 }
 
 // hasInboundXray checks if an inbound with the given tag exists for Xray.
@@ -1306,6 +1400,10 @@ func OpenAndInitDB(dbPath string, dbType string, cfg *config.Config) (*sql.DB, e
 	}
 	if tableCount > 0 {
 		cfg.Logger.Debug("Tables already exist", "dbType", dbType)
+		if err := migrateDB(db, dbType, cfg); err != nil {
+			db.Close()
+			return nil, err
+		}
 		return db, nil
 	}
 
@@ -1370,10 +1468,140 @@ func OpenAndInitDB(dbPath string, dbType string, cfg *config.Config) (*sql.DB, e
 		return nil, fmt.Errorf("failed to execute SQL script for %s database: %v", dbType, err)
 	}
 
+	if err := migrateDB(db, dbType, cfg); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	cfg.Logger.Info("Database initialized", "dbType", dbType)
 	return db, nil
 }
 
+// migrateDB adds tables and columns introduced after the initial schema to an existing database.
+func migrateDB(db *sql.DB, dbType string, cfg *config.Config) error {
+	sqlStmt := `
+        CREATE TABLE IF NOT EXISTS core_events (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            event TEXT NOT NULL,
+            details TEXT DEFAULT '',
+            created TEXT NOT NULL
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_core_events_created ON core_events(created);
+
+        CREATE TABLE IF NOT EXISTS traffic_history (
+            kind TEXT NOT NULL,
+            name TEXT NOT NULL,
+            resolution INTEGER NOT NULL,
+            bucket INTEGER NOT NULL,
+            uplink INTEGER DEFAULT 0,
+            downlink INTEGER DEFAULT 0,
+            PRIMARY KEY (kind, name, resolution, bucket)
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_traffic_history_resolution_bucket ON traffic_history(resolution, bucket);
+
+        CREATE TABLE IF NOT EXISTS traffic_resets (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user TEXT NOT NULL,
+            policy TEXT NOT NULL,
+            uplink INTEGER DEFAULT 0,
+            downlink INTEGER DEFAULT 0,
+            period_start TEXT NOT NULL,
+            period_end TEXT NOT NULL
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_traffic_resets_user ON traffic_resets(user);
+
+        CREATE TABLE IF NOT EXISTS notification_state (
+            kind TEXT NOT NULL,
+            key TEXT NOT NULL,
+            value TEXT DEFAULT '',
+            updated TEXT NOT NULL,
+            PRIMARY KEY (kind, key)
+        );
+
+        CREATE TABLE IF NOT EXISTS reminders_sent (
+            user TEXT NOT NULL,
+            sub_end TEXT NOT NULL,
+            threshold INTEGER NOT NULL,
+            sent TEXT NOT NULL,
+            PRIMARY KEY (user, sub_end, threshold)
+        );
+    `
+	cfg.Logger.Debug("Executing SQL migration script", "dbType", dbType)
+	if _, err := db.Exec(sqlStmt); err != nil {
+		cfg.Logger.Error("Failed to execute SQL migration script", "dbType", dbType, "error", err)
+		return fmt.Errorf("failed to migrate %s database: %v", dbType, err)
+	}
+
+	columns := []struct{ table, name, definition string }{
+		{"clients_stats", "traffic_limit", "INTEGER DEFAULT 0"},
+		{"clients_stats", "uplink_limit", "INTEGER DEFAULT 0"},
+		{"clients_stats", "downlink_limit", "INTEGER DEFAULT 0"},
+		{"clients_stats", "disabled_reason", "TEXT DEFAULT ''"},
+		{"clients_stats", "reset_policy", "TEXT DEFAULT 'none'"},
+		{"clients_stats", "reset_day", "INTEGER DEFAULT 0"},
+		{"clients_stats", "last_reset", "TEXT DEFAULT ''"},
+		{"clients_stats", "sub_token", "TEXT DEFAULT ''"},
+		{"clients_stats", "notes", "TEXT DEFAULT ''"},
+		{"clients_stats", "tags", "TEXT DEFAULT ''"},
+		{"clients_stats", "contact_telegram", "TEXT DEFAULT ''"},
+		{"clients_stats", "contact_email", "TEXT DEFAULT ''"},
+	}
+	for _, col := range columns {
+		if err := addColumnIfMissing(db, col.table, col.name, col.definition); err != nil {
+			cfg.Logger.Error("Failed to add column", "dbType", dbType, "table", col.table, "column", col.name, "error", err)
+			return fmt.Errorf("failed to migrate %s database: %v", dbType, err)
+		}
+	}
+
+	// Users created before subscriptions get a random token
+	if _, err := db.Exec(`
+        UPDATE clients_stats SET sub_token = lower(hex(randomblob(16))) WHERE sub_token IS NULL OR sub_token = '';
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_clients_stats_sub_token ON clients_stats(sub_token);
+    `); err != nil {
+		cfg.Logger.Error("Failed to initialize subscription tokens", "dbType", dbType, "error", err)
+		return fmt.Errorf("failed to migrate %s database: %v", dbType, err)
+	}
+	return nil
+}
+
+// addColumnIfMissing adds a column to a table unless it already exists.
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to read table info for %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return fmt.Errorf("failed to scan table info for %s: %v", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating table info for %s: %v", table, err)
+	}
+	rows.Close()
+
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s to %s: %v", column, table, err)
+	}
+	return nil
+}
+
 // InitDatabase initializes in-memory and file databases.
 func InitDatabase(cfg *config.Config) (memDB, fileDB *sql.DB, err error) {
 	// Initialize in-memory database
@@ -1450,6 +1678,9 @@ func MonitorSubscriptionsAndSync(ctx context.Context, manager *manager.DatabaseM
 				if err := CleanInvalidTrafficTags(manager, cfg); err != nil {
 					cfg.Logger.Error("Failed to clean invalid tags", "error", err)
 				}
+				if err := CheckSubscriptionReminders(manager, cfg); err != nil {
+					cfg.Logger.Error("Failed to check subscription reminders", "error", err)
+				}
 				if err := CheckExpiredSubscriptions(manager, cfg); err != nil {
 					cfg.Logger.Error("Failed to check subscriptions", "error", err)
 				}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db/manager"
+)
+
+// Core event types stored in the core_events table.
+const (
+	EventCoreRestarted = "core_restarted"
+)
+
+// CoreEvent represents an event detected while polling the core.
+type CoreEvent struct {
+	ID      int64  `json:"id"`
+	Event   string `json:"event"`
+	Details string `json:"details"`
+	Created string `json:"created"`
+}
+
+// AddCoreEvent records an event in the core_events table.
+func AddCoreEvent(manager *manager.DatabaseManager, cfg *config.Config, event, details string) error {
+	created := time.Now().Format("2006-01-02 15:04:05")
+	cfg.Logger.Debug("Recording core event", "event", event, "details", details)
+
+	err := manager.ExecuteHighPriority(func(db *sql.DB) error {
+		_, err := db.Exec("INSERT INTO core_events (event, details, created) VALUES (?, ?, ?)", event, details, created)
+		return err
+	})
+	if err != nil {
+		cfg.Logger.Error("Failed to record core event", "event", event, "error", err)
+		return fmt.Errorf("failed to record core event: %v", err)
+	}
+
+	cfg.Logger.Info("Core event recorded", "event", event, "created", created)
+	return nil
+}
+
+// GetCoreEvents returns the most recent core events, newest first.
+func GetCoreEvents(manager *manager.DatabaseManager, cfg *config.Config, event string, limit int) ([]CoreEvent, error) {
+	events := []CoreEvent{}
+	err := manager.ExecuteLowPriority(func(db *sql.DB) error {
+		query := "SELECT id, event, details, created FROM core_events"
+		var args []any
+		if event != "" {
+			query += " WHERE event = ?"
+			args = append(args, event)
+		}
+		query += " ORDER BY id DESC LIMIT ?"
+		args = append(args, limit)
+
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to query core_events: %v", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var e CoreEvent
+			if err := rows.Scan(&e.ID, &e.Event, &e.Details, &e.Created); err != nil {
+				return fmt.Errorf("failed to scan core event: %v", err)
+			}
+			events = append(events, e)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		cfg.Logger.Error("Failed to get core events", "error", err)
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,265 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db/manager"
+)
+
+// History kinds stored in the traffic_history table.
+const (
+	HistoryKindUser   = "user"
+	HistoryKindSource = "source"
+)
+
+// History bucket resolutions in seconds.
+const (
+	HistoryResolution5m = 300
+	HistoryResolution1h = 3600
+	HistoryResolution1d = 86400
+)
+
+// TrafficDelta holds uplink and downlink increments collected for one user or source.
+type TrafficDelta struct {
+	Name     string
+	Uplink   int64
+	Downlink int64
+}
+
+// HistoryPoint represents aggregated traffic for one step of a history query.
+type HistoryPoint struct {
+	Time      string `json:"time"`
+	Timestamp int64  `json:"timestamp"`
+	Uplink    int64  `json:"uplink"`
+	Downlink  int64  `json:"downlink"`
+}
+
+// HistoryLocation returns the timezone used to align hourly and daily buckets.
+func HistoryLocation(cfg *config.Config) *time.Location {
+	if cfg.Timezone != "" {
+		if loc, err := time.LoadLocation(cfg.Timezone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// bucketStart returns the start of the step containing a unix timestamp in the history timezone.
+// Steps of whole days start at midnight, counted from the Unix epoch date for steps of several
+// days; shorter steps are counted from the midnight of the day. Buckets are computed per timestamp,
+// so they follow DST changes and never cross midnight.
+func bucketStart(ts, step int64, loc *time.Location) int64 {
+	t := time.Unix(ts, 0).In(loc)
+	year, month, day := t.Date()
+	if step%HistoryResolution1d == 0 {
+		days := step / HistoryResolution1d
+		epochDay := time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix() / HistoryResolution1d
+		return time.Date(year, month, day-int((epochDay%days+days)%days), 0, 0, 0, 0, loc).Unix()
+	}
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, loc).Unix()
+	elapsed := ts - midnight
+	return midnight + elapsed - elapsed%step
+}
+
+// historyKey identifies a bucket of a user or source while aggregating history rows.
+type historyKey struct {
+	kind, name string
+	bucket     int64
+}
+
+// AddTrafficHistory records traffic deltas into 5-minute history buckets.
+func AddTrafficHistory(manager *manager.DatabaseManager, cfg *config.Config, kind string, deltas []TrafficDelta, ts time.Time) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+	bucket := ts.Unix() - ts.Unix()%HistoryResolution5m
+
+	err := manager.ExecuteHighPriority(func(db *sql.DB) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		stmt, err := tx.Prepare(`
+			INSERT INTO traffic_history (kind, name, resolution, bucket, uplink, downlink)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(kind, name, resolution, bucket) DO UPDATE SET
+				uplink = uplink + excluded.uplink,
+				downlink = downlink + excluded.downlink`)
+		if err != nil {
+			return fmt.Errorf("failed to prepare statement: %v", err)
+		}
+		defer stmt.Close()
+
+		for _, delta := range deltas {
+			cfg.Logger.Trace("Recording traffic history", "kind", kind, "name", delta.Name, "bucket", bucket, "uplink", delta.Uplink, "downlink", delta.Downlink)
+			if _, err := stmt.Exec(kind, delta.Name, HistoryResolution5m, bucket, delta.Uplink, delta.Downlink); err != nil {
+				return fmt.Errorf("failed to insert history for %s: %v", delta.Name, err)
+			}
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		cfg.Logger.Error("Failed to record traffic history", "kind", kind, "error", err)
+		return err
+	}
+	return nil
+}
+
+// CompactTrafficHistory rolls up expired 5-minute buckets into hourly ones and expired hourly buckets into daily ones,
+// then removes daily buckets older than the daily retention.
+func CompactTrafficHistory(manager *manager.DatabaseManager, cfg *config.Config) error {
+	now := time.Now()
+	loc := HistoryLocation(cfg)
+	cutoff5m := bucketStart(now.Add(-time.Duration(cfg.History.FiveMinuteRetention)*time.Hour).Unix(), HistoryResolution1h, loc)
+	cutoff1h := bucketStart(now.AddDate(0, 0, -cfg.History.HourlyRetention).Unix(), HistoryResolution1d, loc)
+
+	cfg.Logger.Debug("Compacting traffic history", "cutoff_5m", cutoff5m, "cutoff_1h", cutoff1h)
+
+	err := manager.ExecuteLowPriority(func(db *sql.DB) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		rollups := []struct {
+			from, to int64
+			cutoff   int64
+		}{
+			{HistoryResolution5m, HistoryResolution1h, cutoff5m},
+			{HistoryResolution1h, HistoryResolution1d, cutoff1h},
+		}
+		for _, r := range rollups {
+			rows, err := tx.Query("SELECT kind, name, bucket, uplink, downlink FROM traffic_history WHERE resolution = ? AND bucket < ?", r.from, r.cutoff)
+			if err != nil {
+				return fmt.Errorf("failed to read %ds buckets: %v", r.from, err)
+			}
+			var keys []historyKey
+			sums := make(map[historyKey]*TrafficDelta)
+			for rows.Next() {
+				var key historyKey
+				var uplink, downlink int64
+				if err := rows.Scan(&key.kind, &key.name, &key.bucket, &uplink, &downlink); err != nil {
+					rows.Close()
+					return fmt.Errorf("failed to scan %ds bucket: %v", r.from, err)
+				}
+				key.bucket = bucketStart(key.bucket, r.to, loc)
+				sum, exists := sums[key]
+				if !exists {
+					sum = &TrafficDelta{Name: key.name}
+					sums[key] = sum
+					keys = append(keys, key)
+				}
+				sum.Uplink += uplink
+				sum.Downlink += downlink
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return fmt.Errorf("failed to read %ds buckets: %v", r.from, err)
+			}
+
+			for _, key := range keys {
+				sum := sums[key]
+				if _, err := tx.Exec(`
+					INSERT INTO traffic_history (kind, name, resolution, bucket, uplink, downlink)
+					VALUES (?, ?, ?, ?, ?, ?)
+					ON CONFLICT(kind, name, resolution, bucket) DO UPDATE SET
+						uplink = uplink + excluded.uplink,
+						downlink = downlink + excluded.downlink`,
+					key.kind, key.name, r.to, key.bucket, sum.Uplink, sum.Downlink); err != nil {
+					return fmt.Errorf("failed to roll up %ds buckets: %v", r.from, err)
+				}
+			}
+
+			if _, err := tx.Exec("DELETE FROM traffic_history WHERE resolution = ? AND bucket < ?", r.from, r.cutoff); err != nil {
+				return fmt.Errorf("failed to delete %ds buckets: %v", r.from, err)
+			}
+			cfg.Logger.Debug("Rolled up traffic history", "from", r.from, "to", r.to, "rows", len(keys))
+		}
+
+		if cfg.History.DailyRetention > 0 {
+			cutoff1d := now.AddDate(0, 0, -cfg.History.DailyRetention).Unix()
+			if _, err := tx.Exec("DELETE FROM traffic_history WHERE resolution = ? AND bucket < ?", HistoryResolution1d, cutoff1d); err != nil {
+				return fmt.Errorf("failed to delete expired daily buckets: %v", err)
+			}
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		cfg.Logger.Error("Failed to compact traffic history", "error", err)
+		return err
+	}
+
+	cfg.Logger.Debug("Traffic history compacted")
+	return nil
+}
+
+// GetTrafficHistory returns traffic of a user or source between from and to, aggregated into steps of the given size in seconds.
+func GetTrafficHistory(manager *manager.DatabaseManager, cfg *config.Config, kind, name string, from, to time.Time, step int64) ([]HistoryPoint, error) {
+	loc := HistoryLocation(cfg)
+	points := []HistoryPoint{}
+
+	err := manager.ExecuteLowPriority(func(db *sql.DB) error {
+		rows, err := db.Query(`
+			SELECT bucket, uplink, downlink
+			FROM traffic_history
+			WHERE kind = ? AND name = ? AND bucket >= ? AND bucket < ?
+			ORDER BY bucket`,
+			kind, name, from.Unix(), to.Unix())
+		if err != nil {
+			return fmt.Errorf("failed to query traffic_history: %v", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var bucket, uplink, downlink int64
+			if err := rows.Scan(&bucket, &uplink, &downlink); err != nil {
+				return fmt.Errorf("failed to scan history row: %v", err)
+			}
+			// Rows are ordered by bucket, so a step only continues the last point
+			start := bucketStart(bucket, step, loc)
+			if len(points) == 0 || points[len(points)-1].Timestamp != start {
+				points = append(points, HistoryPoint{Time: time.Unix(start, 0).In(loc).Format(time.RFC3339), Timestamp: start})
+			}
+			points[len(points)-1].Uplink += uplink
+			points[len(points)-1].Downlink += downlink
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		cfg.Logger.Error("Failed to get traffic history", "kind", kind, "name", name, "error", err)
+		return nil, err
+	}
+	return points, nil
+}
+
+// MonitorTrafficHistory periodically compacts the traffic history tables.
+func MonitorTrafficHistory(ctx context.Context, manager *manager.DatabaseManager, cfg *config.Config, wg *sync.WaitGroup) {
+	cfg.Logger.Debug("Starting traffic history compaction")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+
+		CompactTrafficHistory(manager, cfg)
+		for {
+			select {
+			case <-ticker.C:
+				CompactTrafficHistory(manager, cfg)
+			case <-ctx.Done():
+				cfg.Logger.Debug("Stopped traffic history compaction")
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketStart(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("timezone data not available: %v", err)
+	}
+	at := func(value string) int64 {
+		ts, err := time.ParseInLocation("2006-01-02 15:04", value, berlin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts.Unix()
+	}
+	utc := func(value string) int64 {
+		ts, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts.Unix()
+	}
+
+	tests := []struct {
+		name string
+		ts   int64
+		step int64
+		want int64
+	}{
+		{"5 minutes", at("2025-06-10 13:47"), HistoryResolution5m, at("2025-06-10 13:45")},
+		{"hour", at("2025-06-10 13:47"), HistoryResolution1h, at("2025-06-10 13:00")},
+		{"day in summer", at("2025-06-10 00:30"), HistoryResolution1d, at("2025-06-10 00:00")},
+		{"day in winter", at("2025-01-10 23:59"), HistoryResolution1d, at("2025-01-10 00:00")},
+		// 2025-03-30 has 23 hours and 2025-10-26 has 25 hours in Berlin
+		{"day before spring DST change", utc("2025-03-29T23:30:00Z"), HistoryResolution1d, at("2025-03-30 00:00")},
+		{"day after spring DST change", utc("2025-03-30T21:59:00Z"), HistoryResolution1d, at("2025-03-30 00:00")},
+		{"next day after spring DST change", utc("2025-03-30T22:00:00Z"), HistoryResolution1d, at("2025-03-31 00:00")},
+		{"day after autumn DST change", utc("2025-10-26T22:59:00Z"), HistoryResolution1d, at("2025-10-26 00:00")},
+		{"next day after autumn DST change", utc("2025-10-26T23:00:00Z"), HistoryResolution1d, at("2025-10-27 00:00")},
+		{"repeated hour, first", utc("2025-10-26T00:30:00Z"), HistoryResolution1h, utc("2025-10-26T00:00:00Z")},
+		{"repeated hour, second", utc("2025-10-26T01:30:00Z"), HistoryResolution1h, utc("2025-10-26T01:00:00Z")},
+		{"hour after spring DST change", utc("2025-03-30T01:30:00Z"), HistoryResolution1h, utc("2025-03-30T01:00:00Z")},
+		// Steps of several days are counted from 1970-01-01, 2025-06-11 is day 20250
+		{"two days", at("2025-06-11 12:00"), 2 * HistoryResolution1d, at("2025-06-11 00:00")},
+		{"two days, second day", at("2025-06-12 23:00"), 2 * HistoryResolution1d, at("2025-06-11 00:00")},
+		{"two days, next step", at("2025-06-13 00:00"), 2 * HistoryResolution1d, at("2025-06-13 00:00")},
+		{"6 hours", at("2025-06-10 13:47"), 6 * HistoryResolution1h, at("2025-06-10 12:00")},
+	}
+	for _, tt := range tests {
+		if got := bucketStart(tt.ts, tt.step, berlin); got != tt.want {
+			t.Errorf("%s: bucketStart(%d, %d) = %s, want %s", tt.name, tt.ts, tt.step,
+				time.Unix(got, 0).In(berlin), time.Unix(tt.want, 0).In(berlin))
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db/manager"
+)
+
+// Kinds of notification state stored in notification_state.
+const (
+	NotificationKindExpired = "expired" // "Subscription expired" sent, keyed by user
+	NotificationKindRenewed = "renewed" // "Subscription renewed" sent, keyed by user
+	NotificationKindService = "service" // Last seen service status (running/stopped), keyed by service
+	NotificationKindAlert   = "alert"   // Active resource alert (exceeded), keyed by resource
+)
+
+// NotificationState represents a stored notification or alert state.
+type NotificationState struct {
+	Kind    string `json:"kind"`
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Updated string `json:"updated"`
+}
+
+// ValidNotificationKind reports whether kind is a known notification state kind.
+func ValidNotificationKind(kind string) bool {
+	switch kind {
+	case NotificationKindExpired, NotificationKindRenewed, NotificationKindService, NotificationKindAlert:
+		return true
+	}
+	return false
+}
+
+// GetNotificationStates returns stored notification states, optionally filtered by kind and key.
+func GetNotificationStates(manager *manager.DatabaseManager, cfg *config.Config, kind, key string) ([]NotificationState, error) {
+	states := []NotificationState{}
+	err := manager.ExecuteLowPriority(func(db *sql.DB) error {
+		query := "SELECT kind, key, value, updated FROM notification_state WHERE 1=1"
+		var args []any
+		if kind != "" {
+			query += " AND kind = ?"
+			args = append(args, kind)
+		}
+		if key != "" {
+			query += " AND key = ?"
+			args = append(args, key)
+		}
+		query += " ORDER BY kind, key"
+
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to query notification_state: %v", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var s NotificationState
+			if err := rows.Scan(&s.Kind, &s.Key, &s.Value, &s.Updated); err != nil {
+				return fmt.Errorf("failed to scan notification_state row: %v", err)
+			}
+			states = append(states, s)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		cfg.Logger.Error("Failed to get notification states", "kind", kind, "key", key, "error", err)
+		return nil, err
+	}
+	return states, nil
+}
+
+// LoadNotificationState returns the stored values of a notification state kind keyed by key.
+func LoadNotificationState(manager *manager.DatabaseManager, cfg *config.Config, kind string) (map[string]string, error) {
+	states, err := GetNotificationStates(manager, cfg, kind, "")
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(states))
+	for _, s := range states {
+		values[s.Key] = s.Value
+	}
+	return values, nil
+}
+
+// SetNotificationState stores a notification state value.
+func SetNotificationState(manager *manager.DatabaseManager, cfg *config.Config, kind, key, value string) error {
+	cfg.Logger.Trace("Storing notification state", "kind", kind, "key", key, "value", value)
+	err := manager.ExecuteHighPriority(func(db *sql.DB) error {
+		_, err := db.Exec(`
+			INSERT INTO notification_state(kind, key, value, updated) VALUES (?, ?, ?, ?)
+			ON CONFLICT(kind, key) DO UPDATE SET value = excluded.value, updated = excluded.updated`,
+			kind, key, value, time.Now().Format("2006-01-02 15:04:05"))
+		return err
+	})
+	if err != nil {
+		cfg.Logger.Error("Failed to store notification state", "kind", kind, "key", key, "error", err)
+		return fmt.Errorf("failed to store notification state: %v", err)
+	}
+	return nil
+}
+
+// ClearNotificationState deletes stored notification states, optionally filtered by kind and key,
+// and returns the number of deleted entries.
+func ClearNotificationState(manager *manager.DatabaseManager, cfg *config.Config, kind, key string) (int64, error) {
+	var deleted int64
+	err := manager.ExecuteHighPriority(func(db *sql.DB) error {
+		query := "DELETE FROM notification_state WHERE 1=1"
+		var args []any
+		if kind != "" {
+			query += " AND kind = ?"
+			args = append(args, kind)
+		}
+		if key != "" {
+			query += " AND key = ?"
+			args = append(args, key)
+		}
+		result, err := db.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		deleted, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		cfg.Logger.Error("Failed to clear notification state", "kind", kind, "key", key, "error", err)
+		return 0, fmt.Errorf("failed to clear notification state: %v", err)
+	}
+	return deleted, nil
+}
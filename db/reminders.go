@@ -0,0 +1,163 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db/manager"
+	"v2ray-stat/telegram"
+)
+
+// reminderCandidate holds a user whose subscription ends in the future.
+type reminderCandidate struct {
+	User   string
+	SubEnd string
+	Renew  int
+	Sent   map[int]bool
+}
+
+// formatRemaining formats the time left before a subscription ends in days and hours.
+func formatRemaining(d time.Duration) string {
+	hours := int(d.Round(time.Hour) / time.Hour)
+	if hours < 1 {
+		return "less than 1h"
+	}
+	if hours < 24 {
+		return fmt.Sprintf("%dh", hours)
+	}
+	if hours%24 == 0 {
+		return fmt.Sprintf("%dd", hours/24)
+	}
+	return fmt.Sprintf("%dd %dh", hours/24, hours%24)
+}
+
+// reachedThresholds returns the reminder thresholds in hours that remaining has reached.
+// Thresholds are sorted longest first, so the last reached one is the most urgent.
+func reachedThresholds(thresholds []time.Duration, remaining time.Duration) []int {
+	var reached []int
+	for _, threshold := range thresholds {
+		if remaining <= threshold {
+			reached = append(reached, int(threshold/time.Hour))
+		}
+	}
+	return reached
+}
+
+// CheckSubscriptionReminders sends a Telegram reminder for each user whose subscription ends within
+// one of the telegram.reminders thresholds. Sent reminders are recorded in reminders_sent per user,
+// sub_end and threshold, so each reminder is sent once and a new sub_end starts a new series.
+func CheckSubscriptionReminders(manager *manager.DatabaseManager, cfg *config.Config) error {
+	thresholds := cfg.Telegram.ReminderThresholds
+	if len(thresholds) == 0 {
+		return nil
+	}
+	if cfg.Telegram.BotToken == "" || cfg.Telegram.ChatID == "" {
+		cfg.Logger.Debug("Telegram notifications not configured, skipping reminders")
+		return nil
+	}
+
+	cfg.Logger.Debug("Starting to check subscription reminders")
+	var candidates []reminderCandidate
+	err := manager.ExecuteLowPriority(func(db *sql.DB) error {
+		if _, err := db.Exec(`
+			DELETE FROM reminders_sent WHERE NOT EXISTS (
+				SELECT 1 FROM clients_stats c WHERE c.user = reminders_sent.user AND c.sub_end = reminders_sent.sub_end
+			)`); err != nil {
+			return fmt.Errorf("failed to clean reminders_sent: %v", err)
+		}
+
+		rows, err := db.Query(`
+			SELECT c.user, c.sub_end, c.renew, COALESCE(r.threshold, 0)
+			FROM clients_stats c
+			LEFT JOIN reminders_sent r ON r.user = c.user AND r.sub_end = c.sub_end
+			WHERE c.sub_end != '' AND c.enabled = 'true'`)
+		if err != nil {
+			return fmt.Errorf("failed to query subscriptions: %v", err)
+		}
+		defer rows.Close()
+
+		index := make(map[string]int)
+		for rows.Next() {
+			var (
+				c         reminderCandidate
+				threshold int
+			)
+			if err := rows.Scan(&c.User, &c.SubEnd, &c.Renew, &threshold); err != nil {
+				return fmt.Errorf("failed to scan row: %v", err)
+			}
+			i, ok := index[c.User]
+			if !ok {
+				c.Sent = make(map[int]bool)
+				candidates = append(candidates, c)
+				i = len(candidates) - 1
+				index[c.User] = i
+			}
+			if threshold > 0 {
+				candidates[i].Sent[threshold] = true
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		cfg.Logger.Error("Failed to read subscriptions for reminders", "error", err)
+		return err
+	}
+
+	now := time.Now()
+	sent := 0
+	for _, c := range candidates {
+		subEnd, err := ParseSubEnd(c.SubEnd)
+		if err != nil {
+			cfg.Logger.Error("Failed to parse date", "user", c.User, "sub_end", c.SubEnd, "error", err)
+			continue
+		}
+		remaining := subEnd.Sub(now)
+		if remaining <= 0 {
+			continue
+		}
+
+		reached := reachedThresholds(thresholds, remaining)
+		if len(reached) == 0 || c.Sent[reached[len(reached)-1]] {
+			continue
+		}
+
+		renewal := "disabled, the user will be disabled unless the subscription is renewed"
+		if c.Renew > 0 {
+			renewal = fmt.Sprintf("enabled, the subscription will be extended for %d days", c.Renew)
+		}
+		message := fmt.Sprintf(
+			"⏳ Subscription expires soon\n\n"+
+				"Client:   *%s*\n"+
+				"End date:   *%s*\n"+
+				"Time left:   *%s*\n"+
+				"Auto-renewal:   *%s*",
+			c.User, formatDate(c.SubEnd, cfg), formatRemaining(remaining), renewal)
+		if err := telegram.SendNotification(cfg, message); err != nil {
+			cfg.Logger.Error("Failed to send subscription reminder", "user", c.User, "error", err)
+			continue
+		}
+
+		// Skipped longer thresholds are recorded too, so they are not sent after a shorter one
+		sentAt := now.Format("2006-01-02-15")
+		err = manager.ExecuteLowPriority(func(db *sql.DB) error {
+			for _, threshold := range reached {
+				if _, err := db.Exec("INSERT OR IGNORE INTO reminders_sent(user, sub_end, threshold, sent) VALUES (?, ?, ?, ?)",
+					c.User, c.SubEnd, threshold, sentAt); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			cfg.Logger.Error("Failed to record subscription reminder", "user", c.User, "error", err)
+			continue
+		}
+		cfg.Logger.Info("Subscription reminder sent", "user", c.User, "sub_end", c.SubEnd, "threshold_hours", reached[len(reached)-1])
+		sent++
+	}
+
+	cfg.Logger.Debug("Finished checking subscription reminders", "sent", sent)
+	return nil
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReachedThresholds(t *testing.T) {
+	thresholds := []time.Duration{72 * time.Hour, 24 * time.Hour, time.Hour}
+	tests := []struct {
+		remaining time.Duration
+		want      []int
+	}{
+		{100 * time.Hour, nil},
+		{72*time.Hour + time.Minute, nil},
+		{72 * time.Hour, []int{72}},
+		{48 * time.Hour, []int{72}},
+		{24 * time.Hour, []int{72, 24}},
+		{90 * time.Minute, []int{72, 24}},
+		{time.Hour, []int{72, 24, 1}},
+		{time.Minute, []int{72, 24, 1}},
+	}
+	for _, tt := range tests {
+		if got := reachedThresholds(thresholds, tt.remaining); !slices.Equal(got, tt.want) {
+			t.Errorf("reachedThresholds(%s) = %v, want %v", tt.remaining, got, tt.want)
+		}
+	}
+}
+
+func TestFormatRemaining(t *testing.T) {
+	tests := []struct {
+		remaining time.Duration
+		want      string
+	}{
+		{20 * time.Minute, "less than 1h"},
+		{40 * time.Minute, "1h"},
+		{23 * time.Hour, "23h"},
+		{23*time.Hour + 40*time.Minute, "1d"},
+		{72 * time.Hour, "3d"},
+		{50 * time.Hour, "2d 2h"},
+	}
+	for _, tt := range tests {
+		if got := formatRemaining(tt.remaining); got != tt.want {
+			t.Errorf("formatRemaining(%s) = %q, want %q", tt.remaining, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db/manager"
+)
+
+// SubscriptionUser holds the data served in a user's subscription.
+type SubscriptionUser struct {
+	User         string
+	Enabled      string
+	SubEnd       string
+	Uplink       int64
+	Downlink     int64
+	TrafficLimit int64
+}
+
+// GetUserBySubToken returns the user owning a subscription token, or nil if the token is unknown.
+func GetUserBySubToken(manager *manager.DatabaseManager, cfg *config.Config, token string) (*SubscriptionUser, error) {
+	var user *SubscriptionUser
+	err := manager.ExecuteLowPriority(func(db *sql.DB) error {
+		var u SubscriptionUser
+		err := db.QueryRow(`
+			SELECT user, COALESCE(enabled, 'true'), COALESCE(sub_end, ''), uplink, downlink, traffic_limit
+			FROM clients_stats WHERE sub_token = ?`, token).
+			Scan(&u.User, &u.Enabled, &u.SubEnd, &u.Uplink, &u.Downlink, &u.TrafficLimit)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		user = &u
+		return nil
+	})
+	if err != nil {
+		cfg.Logger.Error("Failed to get user by subscription token", "error", err)
+		return nil, fmt.Errorf("failed to get user by subscription token: %v", err)
+	}
+	return user, nil
+}
+
+// RotateSubToken replaces the subscription token of a user with a new random one, so that the
+// old subscription link stops working, and returns the new token.
+func RotateSubToken(manager *manager.DatabaseManager, cfg *config.Config, user string) (string, error) {
+	var token string
+	err := manager.ExecuteHighPriority(func(db *sql.DB) error {
+		result, err := db.Exec("UPDATE clients_stats SET sub_token = lower(hex(randomblob(16))) WHERE user = ?", user)
+		if err != nil {
+			return fmt.Errorf("failed to update subscription token: %v", err)
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return fmt.Errorf("%w: %s", ErrUserNotFound, user)
+		}
+		return db.QueryRow("SELECT sub_token FROM clients_stats WHERE user = ?", user).Scan(&token)
+	})
+	if err != nil {
+		cfg.Logger.Error("Failed to rotate subscription token", "user", user, "error", err)
+		return "", err
+	}
+	cfg.Logger.Info("Subscription token rotated", "user", user)
+	return token, nil
+}
@@ -0,0 +1,211 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"v2ray-stat/backup"
+	"v2ray-stat/config"
+	"v2ray-stat/db/manager"
+	"v2ray-stat/telegram"
+	"v2ray-stat/util"
+)
+
+// DisabledReasonTrafficLimit marks users disabled automatically for exceeding their traffic limit.
+const DisabledReasonTrafficLimit = "traffic_limit"
+
+// TrafficLimit holds the traffic limits of a user in bytes; zero means unlimited.
+type TrafficLimit struct {
+	Total    int64
+	Uplink   int64
+	Downlink int64
+}
+
+// trafficUsage holds the usage and limits of a user read from clients_stats.
+type trafficUsage struct {
+	User           string
+	Enabled        string
+	SubEnd         string
+	Uplink         int64
+	Downlink       int64
+	Limit          TrafficLimit
+	DisabledReason string
+}
+
+// exceeded returns a description of the exceeded limit, or an empty string if usage is within limits.
+func (u trafficUsage) exceeded() string {
+	switch {
+	case u.Limit.Total > 0 && u.Uplink+u.Downlink >= u.Limit.Total:
+		return fmt.Sprintf("total %s / %s", util.FormatData(float64(u.Uplink+u.Downlink), "byte"), util.FormatData(float64(u.Limit.Total), "byte"))
+	case u.Limit.Uplink > 0 && u.Uplink >= u.Limit.Uplink:
+		return fmt.Sprintf("uplink %s / %s", util.FormatData(float64(u.Uplink), "byte"), util.FormatData(float64(u.Limit.Uplink), "byte"))
+	case u.Limit.Downlink > 0 && u.Downlink >= u.Limit.Downlink:
+		return fmt.Sprintf("downlink %s / %s", util.FormatData(float64(u.Downlink), "byte"), util.FormatData(float64(u.Limit.Downlink), "byte"))
+	}
+	return ""
+}
+
+// subscriptionExpired reports whether the user's subscription end date has passed.
+func (u trafficUsage) subscriptionExpired() bool {
+	if u.SubEnd == "" {
+		return false
+	}
+	subEnd, err := ParseSubEnd(u.SubEnd)
+	return err == nil && subEnd.Before(time.Now())
+}
+
+// UpdateTrafficLimit sets the traffic limits of a user.
+func UpdateTrafficLimit(manager *manager.DatabaseManager, cfg *config.Config, user string, limit TrafficLimit) error {
+	cfg.Logger.Debug("Updating traffic limit", "user", user, "traffic_limit", limit.Total, "uplink_limit", limit.Uplink, "downlink_limit", limit.Downlink)
+	err := manager.ExecuteHighPriority(func(db *sql.DB) error {
+		result, err := db.Exec("UPDATE clients_stats SET traffic_limit = ?, uplink_limit = ?, downlink_limit = ? WHERE user = ?",
+			limit.Total, limit.Uplink, limit.Downlink, user)
+		if err != nil {
+			return fmt.Errorf("failed to update traffic limit for user %s: %v", user, err)
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get affected rows for user %s: %v", user, err)
+		}
+		if rowsAffected == 0 {
+			return fmt.Errorf("user %s not found", user)
+		}
+		return nil
+	})
+	if err != nil {
+		cfg.Logger.Error("Error in UpdateTrafficLimit", "user", user, "error", err)
+		return err
+	}
+
+	cfg.Logger.Info("Traffic limit updated", "user", user)
+	return nil
+}
+
+// SetDisabledReason records why a user was disabled; an empty reason clears it.
+func SetDisabledReason(manager *manager.DatabaseManager, cfg *config.Config, user, reason string) error {
+	err := manager.ExecuteHighPriority(func(db *sql.DB) error {
+		_, err := db.Exec("UPDATE clients_stats SET disabled_reason = ? WHERE user = ?", reason, user)
+		return err
+	})
+	if err != nil {
+		cfg.Logger.Error("Failed to update disabled reason", "user", user, "reason", reason, "error", err)
+		return fmt.Errorf("failed to update disabled reason for user %s: %v", user, err)
+	}
+	return nil
+}
+
+// setUsersEnabled enables or disables users in the config files and the database, rewriting the
+// config files once. It returns the users whose status was changed.
+func setUsersEnabled(manager *manager.DatabaseManager, cfg *config.Config, users []string, enabled bool, reason string) []string {
+	if len(users) == 0 {
+		return nil
+	}
+	failed, err := ToggleUsersEnabled(manager, cfg, users, enabled)
+	if err != nil {
+		cfg.Logger.Error("Failed to toggle users", "count", len(users), "enabled", enabled, "error", err)
+		return nil
+	}
+	backup.Snapshot(cfg, "traffic limit check")
+
+	var updated []string
+	for _, user := range users {
+		if err := failed[user]; err != nil {
+			cfg.Logger.Error("Failed to toggle user", "user", user, "enabled", enabled, "error", err)
+			continue
+		}
+		if err := UpdateEnabledInDB(manager, cfg, user, enabled); err != nil {
+			cfg.Logger.Error("Failed to update enabled status", "user", user, "error", err)
+			continue
+		}
+		if err := SetDisabledReason(manager, cfg, user, reason); err != nil {
+			cfg.Logger.Error("Failed to update disabled reason", "user", user, "error", err)
+			continue
+		}
+		updated = append(updated, user)
+	}
+	return updated
+}
+
+// CheckTrafficLimits disables users whose traffic exceeds their limit and re-enables
+// users disabled for that reason once their usage is back within the limit.
+func CheckTrafficLimits(manager *manager.DatabaseManager, cfg *config.Config) error {
+	cfg.Logger.Debug("Checking traffic limits")
+	var usages []trafficUsage
+	err := manager.ExecuteLowPriority(func(db *sql.DB) error {
+		rows, err := db.Query(`
+			SELECT user, enabled, sub_end, uplink, downlink, traffic_limit, uplink_limit, downlink_limit, disabled_reason
+			FROM clients_stats
+			WHERE traffic_limit > 0 OR uplink_limit > 0 OR downlink_limit > 0 OR disabled_reason = ?`, DisabledReasonTrafficLimit)
+		if err != nil {
+			return fmt.Errorf("failed to query traffic limits: %v", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var u trafficUsage
+			var enabled, subEnd, reason sql.NullString
+			if err := rows.Scan(&u.User, &enabled, &subEnd, &u.Uplink, &u.Downlink, &u.Limit.Total, &u.Limit.Uplink, &u.Limit.Downlink, &reason); err != nil {
+				return fmt.Errorf("failed to scan traffic limit row: %v", err)
+			}
+			u.Enabled, u.SubEnd, u.DisabledReason = enabled.String, subEnd.String, reason.String
+			usages = append(usages, u)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		cfg.Logger.Error("Error in CheckTrafficLimits", "error", err)
+		return err
+	}
+
+	// Users are toggled after the loop so that the config files are rewritten once per tick
+	var enableUsers, disableUsers []string
+	exceededBy := make(map[string]string)
+	for _, u := range usages {
+		exceeded := u.exceeded()
+		switch {
+		case exceeded != "" && u.Enabled == "true":
+			cfg.Logger.Warn("Traffic limit exceeded, disabling user", "user", u.User, "limit", exceeded)
+			disableUsers = append(disableUsers, u.User)
+			exceededBy[u.User] = exceeded
+
+		case exceeded == "" && u.Enabled == "false" && u.DisabledReason == DisabledReasonTrafficLimit && !u.subscriptionExpired():
+			cfg.Logger.Info("Usage is within traffic limit, enabling user", "user", u.User)
+			enableUsers = append(enableUsers, u.User)
+		}
+	}
+
+	for _, user := range setUsersEnabled(manager, cfg, disableUsers, false, DisabledReasonTrafficLimit) {
+		cfg.Logger.Info("User disabled by traffic limit", "user", user)
+
+		if cfg.Telegram.BotToken != "" && cfg.Telegram.ChatID != "" {
+			message := fmt.Sprintf(
+				"🚫 Traffic limit exceeded\n\n"+
+					"Client:   *%s*\n"+
+					"Usage:   *%s*",
+				user, exceededBy[user])
+			if err := telegram.SendNotification(cfg, message); err != nil {
+				cfg.Logger.Error("Failed to send traffic limit notification", "user", user, "error", err)
+			}
+		}
+	}
+	for _, user := range setUsersEnabled(manager, cfg, enableUsers, true, "") {
+		cfg.Logger.Info("User enabled, usage within traffic limit", "user", user)
+	}
+	return nil
+}
+
+// EnsureUserInDB inserts a user into clients_stats unless it already exists.
+func EnsureUserInDB(manager *manager.DatabaseManager, cfg *config.Config, user, credential string) error {
+	currentTime := time.Now().Format("2006-01-02-15")
+	err := manager.ExecuteHighPriority(func(db *sql.DB) error {
+		_, err := db.Exec("INSERT OR IGNORE INTO clients_stats(user, uuid, rate, enabled, created, sub_token) VALUES (?, ?, ?, ?, ?, lower(hex(randomblob(16))))",
+			user, credential, "0", "true", currentTime)
+		return err
+	})
+	if err != nil {
+		cfg.Logger.Error("Failed to insert user", "user", user, "error", err)
+		return fmt.Errorf("failed to insert user %s: %v", user, err)
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db/manager"
+)
+
+// Reset policies stored in clients_stats.reset_policy.
+const (
+	ResetPolicyNone    = "none"
+	ResetPolicyDaily   = "daily"
+	ResetPolicyWeekly  = "weekly"
+	ResetPolicyMonthly = "monthly"
+	ResetPolicyDays    = "days"
+)
+
+// TrafficReset represents an archived traffic cycle of a user.
+type TrafficReset struct {
+	ID          int64  `json:"id"`
+	User        string `json:"user"`
+	Policy      string `json:"policy"`
+	Uplink      int64  `json:"uplink"`
+	Downlink    int64  `json:"downlink"`
+	PeriodStart string `json:"period_start"`
+	PeriodEnd   string `json:"period_end"`
+}
+
+// ValidateResetPolicy checks a reset policy and returns the normalized reset day.
+// For weekly policies the day is 1 (Monday) to 7 (Sunday), defaulting to Monday; for monthly
+// policies it is 1 to 31, where 0 uses the day the user was created; for days it is the cycle length.
+func ValidateResetPolicy(policy string, day int) (int, error) {
+	switch policy {
+	case ResetPolicyNone, ResetPolicyDaily:
+		return 0, nil
+	case ResetPolicyWeekly:
+		if day == 0 {
+			return 1, nil
+		}
+		if day < 1 || day > 7 {
+			return 0, fmt.Errorf("reset_day for weekly policy must be between 1 and 7")
+		}
+		return day, nil
+	case ResetPolicyMonthly:
+		if day < 0 || day > 31 {
+			return 0, fmt.Errorf("reset_day for monthly policy must be between 1 and 31")
+		}
+		return day, nil
+	case ResetPolicyDays:
+		if day < 1 {
+			return 0, fmt.Errorf("reset_day for days policy must be a positive number of days")
+		}
+		return day, nil
+	default:
+		return 0, fmt.Errorf("invalid reset_policy %q, expected none, daily, weekly, monthly or days", policy)
+	}
+}
+
+// UpdateResetPolicy sets the traffic reset policy of a user. The current cycle starts now.
+func UpdateResetPolicy(manager *manager.DatabaseManager, cfg *config.Config, user, policy string, day int) error {
+	cfg.Logger.Debug("Updating reset policy", "user", user, "reset_policy", policy, "reset_day", day)
+	lastReset := time.Now().In(HistoryLocation(cfg)).Format("2006-01-02-15")
+	err := manager.ExecuteHighPriority(func(db *sql.DB) error {
+		result, err := db.Exec("UPDATE clients_stats SET reset_policy = ?, reset_day = ?, last_reset = ? WHERE user = ?",
+			policy, day, lastReset, user)
+		if err != nil {
+			return fmt.Errorf("failed to update reset policy for user %s: %v", user, err)
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get affected rows for user %s: %v", user, err)
+		}
+		if rowsAffected == 0 {
+			return fmt.Errorf("user %s not found", user)
+		}
+		return nil
+	})
+	if err != nil {
+		cfg.Logger.Error("Error in UpdateResetPolicy", "user", user, "error", err)
+		return err
+	}
+
+	cfg.Logger.Info("Reset policy updated", "user", user, "reset_policy", policy, "reset_day", day)
+	return nil
+}
+
+// startOfDay returns midnight of the day of t in its location.
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// monthlyBoundary returns midnight of the given day in the given month, clamped to the month's last day.
+func monthlyBoundary(year int, month time.Month, day int, loc *time.Location) time.Time {
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// cycleStart returns the start of the reset cycle that contains now.
+func cycleStart(policy string, day int, created, now time.Time) time.Time {
+	today := startOfDay(now)
+	switch policy {
+	case ResetPolicyDaily:
+		return today
+	case ResetPolicyWeekly:
+		weekday := int(today.Weekday())
+		if weekday == 0 {
+			weekday = 7
+		}
+		return today.AddDate(0, 0, -((weekday - day + 7) % 7))
+	case ResetPolicyMonthly:
+		if day == 0 {
+			day = created.Day()
+		}
+		boundary := monthlyBoundary(now.Year(), now.Month(), day, now.Location())
+		if boundary.After(now) {
+			boundary = monthlyBoundary(now.Year(), now.Month()-1, day, now.Location())
+		}
+		return boundary
+	case ResetPolicyDays:
+		start := startOfDay(created)
+		startDate := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		todayDate := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+		elapsed := int(todayDate.Sub(startDate).Hours() / 24)
+		if elapsed < 0 {
+			return start
+		}
+		return start.AddDate(0, 0, elapsed-elapsed%day)
+	}
+	return time.Time{}
+}
+
+// ResetTrafficCycles archives and zeroes the traffic of users whose reset cycle has rolled over,
+// then re-enables users that were disabled only because of their traffic limit.
+func ResetTrafficCycles(manager *manager.DatabaseManager, cfg *config.Config) error {
+	type resetCandidate struct {
+		user, policy, created, lastReset string
+		day                              int
+	}
+
+	var candidates []resetCandidate
+	err := manager.ExecuteLowPriority(func(db *sql.DB) error {
+		rows, err := db.Query("SELECT user, reset_policy, reset_day, created, last_reset FROM clients_stats WHERE reset_policy != ?", ResetPolicyNone)
+		if err != nil {
+			return fmt.Errorf("failed to query reset policies: %v", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var c resetCandidate
+			var created, lastReset sql.NullString
+			if err := rows.Scan(&c.user, &c.policy, &c.day, &created, &lastReset); err != nil {
+				return fmt.Errorf("failed to scan reset policy row: %v", err)
+			}
+			c.created, c.lastReset = created.String, lastReset.String
+			candidates = append(candidates, c)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		cfg.Logger.Error("Error in ResetTrafficCycles", "error", err)
+		return err
+	}
+
+	loc := HistoryLocation(cfg)
+	now := time.Now().In(loc)
+	nowStr := now.Format("2006-01-02-15")
+	resetCount := 0
+	for _, c := range candidates {
+		created, err := time.ParseInLocation("2006-01-02-15", c.created, loc)
+		if err != nil {
+			cfg.Logger.Warn("Invalid created date, skipping traffic reset", "user", c.user, "created", c.created)
+			continue
+		}
+		lastReset := created
+		if c.lastReset != "" {
+			if lastReset, err = time.ParseInLocation("2006-01-02-15", c.lastReset, loc); err != nil {
+				cfg.Logger.Warn("Invalid last reset date, skipping traffic reset", "user", c.user, "last_reset", c.lastReset)
+				continue
+			}
+		}
+		if !cycleStart(c.policy, c.day, created, now).After(lastReset) {
+			continue
+		}
+
+		cfg.Logger.Debug("Traffic cycle rolled over", "user", c.user, "reset_policy", c.policy, "last_reset", lastReset.Format("2006-01-02-15"))
+		err = manager.ExecuteHighPriority(func(db *sql.DB) error {
+			tx, err := db.Begin()
+			if err != nil {
+				return fmt.Errorf("failed to start transaction: %v", err)
+			}
+			defer tx.Rollback()
+
+			if _, err := tx.Exec(`
+				INSERT INTO traffic_resets (user, policy, uplink, downlink, period_start, period_end)
+				SELECT user, reset_policy, uplink, downlink, ?, ? FROM clients_stats WHERE user = ?`,
+				lastReset.Format("2006-01-02-15"), nowStr, c.user); err != nil {
+				return fmt.Errorf("failed to archive traffic for user %s: %v", c.user, err)
+			}
+			if _, err := tx.Exec("UPDATE clients_stats SET uplink = 0, downlink = 0, last_reset = ? WHERE user = ?", nowStr, c.user); err != nil {
+				return fmt.Errorf("failed to reset traffic for user %s: %v", c.user, err)
+			}
+			return tx.Commit()
+		})
+		if err != nil {
+			cfg.Logger.Error("Failed to reset traffic cycle", "user", c.user, "error", err)
+			continue
+		}
+		resetCount++
+		cfg.Logger.Info("Traffic reset for new cycle", "user", c.user, "reset_policy", c.policy)
+	}
+
+	if resetCount > 0 {
+		return CheckTrafficLimits(manager, cfg)
+	}
+	return nil
+}
+
+// GetTrafficResets returns archived traffic cycles, newest first, optionally filtered by user.
+func GetTrafficResets(manager *manager.DatabaseManager, cfg *config.Config, user string, limit int) ([]TrafficReset, error) {
+	resets := []TrafficReset{}
+	err := manager.ExecuteLowPriority(func(db *sql.DB) error {
+		query := "SELECT id, user, policy, uplink, downlink, period_start, period_end FROM traffic_resets"
+		var args []any
+		if user != "" {
+			query += " WHERE user = ?"
+			args = append(args, user)
+		}
+		query += " ORDER BY id DESC LIMIT ?"
+		args = append(args, limit)
+
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to query traffic resets: %v", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var r TrafficReset
+			if err := rows.Scan(&r.ID, &r.User, &r.Policy, &r.Uplink, &r.Downlink, &r.PeriodStart, &r.PeriodEnd); err != nil {
+				return fmt.Errorf("failed to scan traffic reset: %v", err)
+			}
+			resets = append(resets, r)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		cfg.Logger.Error("Error in GetTrafficResets", "error", err)
+		return nil, err
+	}
+	return resets, nil
+}
+
+// MonitorTrafficResets periodically resets traffic of users whose reset cycle has rolled over.
+func MonitorTrafficResets(ctx context.Context, manager *manager.DatabaseManager, cfg *config.Config, wg *sync.WaitGroup) {
+	cfg.Logger.Debug("Starting traffic reset scheduler")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(5 * time.Minute)
+		defer ticker.Stop()
+
+		if err := ResetTrafficCycles(manager, cfg); err != nil {
+			cfg.Logger.Error("Failed to reset traffic cycles", "error", err)
+		}
+		for {
+			select {
+			case <-ticker.C:
+				if err := ResetTrafficCycles(manager, cfg); err != nil {
+					cfg.Logger.Error("Failed to reset traffic cycles", "error", err)
+				}
+			case <-ctx.Done():
+				cfg.Logger.Debug("Stopped traffic reset scheduler")
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCycleStart(t *testing.T) {
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	at := func(value string) time.Time {
+		ts, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	created := at("2025-01-30 15:00")
+
+	tests := []struct {
+		name   string
+		policy string
+		day    int
+		now    time.Time
+		want   time.Time
+	}{
+		{"daily", ResetPolicyDaily, 0, at("2025-06-10 13:47"), at("2025-06-10 00:00")},
+		{"weekly on Monday", ResetPolicyWeekly, 1, at("2025-06-10 13:47"), at("2025-06-09 00:00")},
+		{"weekly on the reset day", ResetPolicyWeekly, 2, at("2025-06-10 00:00"), at("2025-06-10 00:00")},
+		{"weekly on Sunday", ResetPolicyWeekly, 7, at("2025-06-10 13:47"), at("2025-06-08 00:00")},
+		{"weekly on Sunday, same day", ResetPolicyWeekly, 7, at("2025-06-08 23:59"), at("2025-06-08 00:00")},
+		{"monthly", ResetPolicyMonthly, 15, at("2025-06-20 10:00"), at("2025-06-15 00:00")},
+		{"monthly before the reset day", ResetPolicyMonthly, 15, at("2025-06-14 23:59"), at("2025-05-15 00:00")},
+		{"monthly across the year", ResetPolicyMonthly, 15, at("2025-01-10 10:00"), at("2024-12-15 00:00")},
+		{"monthly on the 31st in a short month", ResetPolicyMonthly, 31, at("2025-02-15 10:00"), at("2025-01-31 00:00")},
+		{"monthly on the 31st, clamped to February", ResetPolicyMonthly, 31, at("2025-02-28 10:00"), at("2025-02-28 00:00")},
+		{"monthly on the 31st, previous month clamped", ResetPolicyMonthly, 31, at("2025-03-15 10:00"), at("2025-02-28 00:00")},
+		{"monthly on the 31st in a leap year", ResetPolicyMonthly, 31, at("2024-03-01 10:00"), at("2024-02-29 00:00")},
+		{"monthly on the creation day", ResetPolicyMonthly, 0, at("2025-03-01 10:00"), at("2025-02-28 00:00")},
+		{"monthly on the creation day, reached", ResetPolicyMonthly, 0, at("2025-03-30 00:00"), at("2025-03-30 00:00")},
+		{"days, first cycle", ResetPolicyDays, 10, at("2025-02-08 23:59"), at("2025-01-30 00:00")},
+		{"days, second cycle", ResetPolicyDays, 10, at("2025-02-09 00:00"), at("2025-02-09 00:00")},
+		{"days, before creation", ResetPolicyDays, 10, at("2025-01-20 10:00"), at("2025-01-30 00:00")},
+		{"none", ResetPolicyNone, 0, at("2025-06-10 13:47"), time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := cycleStart(tt.policy, tt.day, created, tt.now); !got.Equal(tt.want) {
+			t.Errorf("%s: cycleStart(%q, %d, %s) = %s, want %s", tt.name, tt.policy, tt.day, tt.now, got, tt.want)
+		}
+	}
+}
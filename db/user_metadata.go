@@ -0,0 +1,81 @@
+package db
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Limits of the user metadata stored in clients_stats.
+const (
+	MaxNotesLength   = 1000
+	MaxTags          = 20
+	MaxTagLength     = 32
+	MaxContactLength = 254
+)
+
+// telegramContactRegex matches a Telegram username (with or without @) or a numeric user or chat ID.
+var telegramContactRegex = regexp.MustCompile(`^(@?[A-Za-z][A-Za-z0-9_]{3,31}|-?\d{1,20})$`)
+
+// NormalizeTags trims tags, drops empty and duplicate ones and checks their count and length.
+// Tags are stored comma-separated, so they cannot contain commas.
+func NormalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || slices.Contains(normalized, tag) {
+			continue
+		}
+		if strings.Contains(tag, ",") {
+			return nil, fmt.Errorf("tag %q must not contain commas", tag)
+		}
+		if len(tag) > MaxTagLength {
+			return nil, fmt.Errorf("tag %q too long (max %d characters)", tag, MaxTagLength)
+		}
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > MaxTags {
+		return nil, fmt.Errorf("too many tags (max %d)", MaxTags)
+	}
+	return normalized, nil
+}
+
+// SplitTags returns the tags of a clients_stats.tags value.
+func SplitTags(value string) []string {
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(value, ",")
+}
+
+// ValidateNotes checks the length of user notes.
+func ValidateNotes(notes string) error {
+	if len(notes) > MaxNotesLength {
+		return fmt.Errorf("notes too long (max %d characters)", MaxNotesLength)
+	}
+	return nil
+}
+
+// ValidateContactTelegram checks a Telegram contact; an empty value clears the contact.
+func ValidateContactTelegram(contact string) error {
+	if contact != "" && !telegramContactRegex.MatchString(contact) {
+		return fmt.Errorf("invalid contact_telegram %q, expected a username like @name or a numeric ID", contact)
+	}
+	return nil
+}
+
+// ValidateContactEmail checks an email contact; an empty value clears the contact.
+func ValidateContactEmail(contact string) error {
+	if contact == "" {
+		return nil
+	}
+	if len(contact) > MaxContactLength {
+		return fmt.Errorf("contact_email too long (max %d characters)", MaxContactLength)
+	}
+	if addr, err := mail.ParseAddress(contact); err != nil || addr.Address != contact {
+		return fmt.Errorf("invalid contact_email %q", contact)
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"v2ray-stat/config"
+	"v2ray-stat/db/manager"
+)
+
+// ErrUserNotFound is returned when a user does not exist in clients_stats.
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrUserExists is returned when a user name is already taken in clients_stats.
+var ErrUserExists = errors.New("user already exists")
+
+// UserUpdate holds user attributes to change in clients_stats; nil fields are left unchanged.
+// SubEnd is an offset such as +30d, -3d12h or 0 applied to the current end of the subscription
+// (or to the current time if the subscription is unlimited), as in AdjustDateOffset; SubEndAt
+// sets the end directly in 2006-01-02-15 format, or "" for an unlimited subscription.
+type UserUpdate struct {
+	SubEnd        *string
+	Renew         *int
+	LimIP         *int
+	Enabled       *bool
+	TrafficLimit  *int64
+	UplinkLimit   *int64
+	DownlinkLimit *int64
+	ResetPolicy   *string
+	ResetDay      *int
+
+	Notes           *string
+	Tags            []string // Normalized tags; nil leaves the tags unchanged
+	ContactTelegram *string
+	ContactEmail    *string
+
+	SubEndAt *string
+	SubToken *string
+	Uplink   *int64
+	Downlink *int64
+}
+
+// ValidateDateOffset checks that offset has the format accepted by AdjustDateOffset.
+func ValidateDateOffset(offset string) error {
+	offset = strings.TrimSpace(offset)
+	if offset == "" || dateOffsetRegex.FindStringSubmatch(offset) == nil {
+		return fmt.Errorf("invalid sub_end offset %q, expected a format like +30d, -3d12h or 0", offset)
+	}
+	return nil
+}
+
+// UpdateUser applies the non-nil attributes of update to a user in a single transaction.
+// Changing the reset policy starts a new traffic cycle, and setting Enabled clears the disabled reason.
+// Only the database is changed; the caller toggles the user in the core config.
+func UpdateUser(manager *manager.DatabaseManager, cfg *config.Config, user string, update UserUpdate) error {
+	cfg.Logger.Debug("Updating user attributes", "user", user)
+	err := manager.ExecuteHighPriority(func(db *sql.DB) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to start transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		var subEnd sql.NullString
+		if err := tx.QueryRow("SELECT sub_end FROM clients_stats WHERE user = ?", user).Scan(&subEnd); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("%w: %s", ErrUserNotFound, user)
+			}
+			return fmt.Errorf("failed to query user %s: %v", user, err)
+		}
+
+		var sets []string
+		var args []any
+		set := func(column string, value any) {
+			sets = append(sets, column+" = ?")
+			args = append(args, value)
+		}
+
+		if update.SubEnd != nil {
+			baseDate := time.Now().UTC()
+			if subEnd.String != "" {
+				if baseDate, err = ParseSubEnd(subEnd.String); err != nil {
+					return fmt.Errorf("failed to parse current subscription date: %v", err)
+				}
+			}
+			newDate, err := parseAndAdjustDate(strings.TrimSpace(*update.SubEnd), baseDate, cfg)
+			if err != nil {
+				return fmt.Errorf("invalid offset format: %v", err)
+			}
+			subEndValue := ""
+			if !newDate.IsZero() {
+				subEndValue = newDate.Format("2006-01-02-15")
+			}
+			set("sub_end", subEndValue)
+		}
+		if update.SubEndAt != nil {
+			set("sub_end", *update.SubEndAt)
+		}
+		if update.Renew != nil {
+			set("renew", *update.Renew)
+		}
+		if update.LimIP != nil {
+			set("lim_ip", *update.LimIP)
+		}
+		if update.Enabled != nil {
+			set("enabled", fmt.Sprintf("%t", *update.Enabled))
+			set("disabled_reason", "")
+		}
+		if update.TrafficLimit != nil {
+			set("traffic_limit", *update.TrafficLimit)
+		}
+		if update.UplinkLimit != nil {
+			set("uplink_limit", *update.UplinkLimit)
+		}
+		if update.DownlinkLimit != nil {
+			set("downlink_limit", *update.DownlinkLimit)
+		}
+		if update.ResetPolicy != nil {
+			set("reset_policy", *update.ResetPolicy)
+			set("last_reset", time.Now().In(HistoryLocation(cfg)).Format("2006-01-02-15"))
+		}
+		if update.ResetDay != nil {
+			set("reset_day", *update.ResetDay)
+		}
+		if update.Notes != nil {
+			set("notes", *update.Notes)
+		}
+		if update.Tags != nil {
+			set("tags", strings.Join(update.Tags, ","))
+		}
+		if update.ContactTelegram != nil {
+			set("contact_telegram", *update.ContactTelegram)
+		}
+		if update.ContactEmail != nil {
+			set("contact_email", *update.ContactEmail)
+		}
+		if update.SubToken != nil {
+			set("sub_token", *update.SubToken)
+		}
+		if update.Uplink != nil {
+			set("uplink", *update.Uplink)
+		}
+		if update.Downlink != nil {
+			set("downlink", *update.Downlink)
+		}
+		if len(sets) == 0 {
+			return nil
+		}
+
+		args = append(args, user)
+		if _, err := tx.Exec("UPDATE clients_stats SET "+strings.Join(sets, ", ")+" WHERE user = ?", args...); err != nil {
+			return fmt.Errorf("failed to update user %s: %v", user, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit transaction: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		cfg.Logger.Error("Error in UpdateUser", "user", user, "error", err)
+		return err
+	}
+
+	cfg.Logger.Info("User attributes updated", "user", user)
+	return nil
+}
+
+// InboundUsers returns the names of the users of an inbound, including users disabled
+// into .disabled_users.
+func InboundUsers(cfg *config.Config, inboundTag string) ([]string, error) {
+	paths := []string{cfg.Core.Config, filepath.Join(cfg.Core.Dir, ".disabled_users")}
+	var users []string
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if i > 0 && os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		if len(data) == 0 {
+			continue
+		}
+
+		switch cfg.V2rayStat.Type {
+		case "xray":
+			var cfgXray config.ConfigXray
+			if err := json.Unmarshal(data, &cfgXray); err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+			}
+			for _, inbound := range cfgXray.Inbounds {
+				if inbound.Tag == inboundTag {
+					for _, client := range inbound.Settings.Clients {
+						users = append(users, client.Email)
+					}
+				}
+			}
+		case "singbox":
+			var cfgSingbox config.ConfigSingbox
+			if err := json.Unmarshal(data, &cfgSingbox); err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+			}
+			for _, inbound := range cfgSingbox.Inbounds {
+				if inbound.Tag == inboundTag {
+					for _, user := range inbound.Users {
+						users = append(users, user.Name)
+					}
+				}
+			}
+		}
+	}
+	cfg.Logger.Trace("Read inbound users", "inboundTag", inboundTag, "count", len(users))
+	return users, nil
+}
+
+// UpdateUserCredential stores a new credential of a user in clients_stats.
+func UpdateUserCredential(manager *manager.DatabaseManager, cfg *config.Config, user, credential string) error {
+	err := manager.ExecuteHighPriority(func(db *sql.DB) error {
+		result, err := db.Exec("UPDATE clients_stats SET uuid = ? WHERE user = ?", credential, user)
+		if err != nil {
+			return fmt.Errorf("failed to update credential of user %s: %v", user, err)
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return fmt.Errorf("%w: %s", ErrUserNotFound, user)
+		}
+		return nil
+	})
+	if err != nil {
+		cfg.Logger.Error("Error in UpdateUserCredential", "user", user, "error", err)
+		return err
+	}
+	cfg.Logger.Debug("User credential updated in database", "user", user)
+	return nil
+}
+
+// DeleteUserFromDB removes the clients_stats row of a user, for example one that was just created
+// and has to be rolled back.
+func DeleteUserFromDB(manager *manager.DatabaseManager, cfg *config.Config, user string) error {
+	err := manager.ExecuteHighPriority(func(db *sql.DB) error {
+		if _, err := db.Exec("DELETE FROM clients_stats WHERE user = ?", user); err != nil {
+			return fmt.Errorf("failed to delete user %s: %v", user, err)
+		}
+		return nil
+	})
+	if err != nil {
+		cfg.Logger.Error("Error in DeleteUserFromDB", "user", user, "error", err)
+		return err
+	}
+	cfg.Logger.Debug("User deleted from database", "user", user)
+	return nil
+}
+
+// RenameUser renames a user in clients_stats and moves their DNS statistics, traffic history,
+// traffic resets, sent reminders and notification state to the new name. renameConfig is called
+// with the old and new name to rename the user in the core config files between checking the
+// names and updating the database; it runs outside the database worker, since it waits for the
+// core file lock and the validate command. If the database cannot be updated, renameConfig is
+// called again with the names swapped. The user sync is locked for the whole rename, so it does
+// not replace the row of the user while only the config files have the new name.
+func RenameUser(manager *manager.DatabaseManager, cfg *config.Config, oldName, newName string, renameConfig func(from, to string) error) error {
+	cfg.Logger.Debug("Renaming user", "user", oldName, "new_user", newName)
+	defer LockUserSync()()
+	err := manager.ExecuteHighPriority(func(db *sql.DB) error {
+		var exists int
+		if err := db.QueryRow("SELECT COUNT(*) FROM clients_stats WHERE user = ?", oldName).Scan(&exists); err != nil {
+			return fmt.Errorf("failed to query user %s: %v", oldName, err)
+		}
+		if exists == 0 {
+			return fmt.Errorf("%w: %s", ErrUserNotFound, oldName)
+		}
+		if err := db.QueryRow("SELECT COUNT(*) FROM clients_stats WHERE user = ?", newName).Scan(&exists); err != nil {
+			return fmt.Errorf("failed to query user %s: %v", newName, err)
+		}
+		if exists > 0 {
+			return fmt.Errorf("%w: %s", ErrUserExists, newName)
+		}
+		return nil
+	})
+	if err == nil {
+		err = renameConfig(oldName, newName)
+	}
+	if err != nil {
+		cfg.Logger.Error("Error in RenameUser", "user", oldName, "new_user", newName, "error", err)
+		return err
+	}
+
+	err = manager.ExecuteHighPriority(func(db *sql.DB) error {
+		return renameUserRows(db, oldName, newName)
+	})
+	if err != nil {
+		cfg.Logger.Error("Error in RenameUser", "user", oldName, "new_user", newName, "error", err)
+		if revertErr := renameConfig(newName, oldName); revertErr != nil {
+			cfg.Logger.Error("Failed to revert user rename in configuration", "user", oldName, "new_user", newName, "error", revertErr)
+		}
+		return err
+	}
+
+	cfg.Logger.Info("User renamed", "user", oldName, "new_user", newName)
+	return nil
+}
+
+// renameUserRows moves the rows of a user to a new name in one transaction. Rows left under
+// the new name by a deleted user are removed first, so they are not merged into the statistics.
+// It returns ErrUserNotFound if the user has no clients_stats row.
+func renameUserRows(db *sql.DB, oldName, newName string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM clients_stats WHERE user = ?", newName); err != nil {
+		return fmt.Errorf("failed to rename user %s to %s: %v", oldName, newName, err)
+	}
+	result, err := tx.Exec("UPDATE clients_stats SET user = ? WHERE user = ?", newName, oldName)
+	if err != nil {
+		return fmt.Errorf("failed to rename user %s to %s: %v", oldName, newName, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows for user %s: %v", oldName, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, oldName)
+	}
+
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{"DELETE FROM dns_stats WHERE user = ?", []any{newName}},
+		{"DELETE FROM traffic_history WHERE kind = ? AND name = ?", []any{HistoryKindUser, newName}},
+		{"DELETE FROM traffic_resets WHERE user = ?", []any{newName}},
+		{"DELETE FROM reminders_sent WHERE user = ?", []any{newName}},
+		{"DELETE FROM notification_state WHERE kind IN (?, ?) AND key = ?", []any{NotificationKindExpired, NotificationKindRenewed, newName}},
+		{"UPDATE dns_stats SET user = ? WHERE user = ?", []any{newName, oldName}},
+		{"UPDATE traffic_history SET name = ? WHERE kind = ? AND name = ?", []any{newName, HistoryKindUser, oldName}},
+		{"UPDATE traffic_resets SET user = ? WHERE user = ?", []any{newName, oldName}},
+		{"UPDATE reminders_sent SET user = ? WHERE user = ?", []any{newName, oldName}},
+		{"UPDATE notification_state SET key = ? WHERE kind IN (?, ?) AND key = ?", []any{newName, NotificationKindExpired, NotificationKindRenewed, oldName}},
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			return fmt.Errorf("failed to rename user %s to %s: %v", oldName, newName, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
@@ -3,23 +3,23 @@ module v2ray-stat
 go 1.24.2
 
 require (
+	github.com/google/uuid v1.6.0
 	github.com/mattn/go-sqlite3 v1.14.28
 	github.com/v2ray/v2ray-core v4.15.0+incompatible
 	github.com/xtls/xray-core v1.250516.0
 	google.golang.org/grpc v1.72.2
+	google.golang.org/protobuf v1.36.6
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/golang/protobuf v1.5.4 // indirect
-	github.com/google/uuid v1.6.0
 	github.com/pires/go-proxyproto v0.8.1 // indirect
 	github.com/sagernet/sing v0.5.1 // indirect
 	golang.org/x/net v0.40.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
 	golang.org/x/text v0.25.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20250218202821-56aae31c358a // indirect
-	google.golang.org/protobuf v1.36.6 // indirect
-	gopkg.in/yaml.v3 v3.0.1
 	v2ray.com/core v4.19.1+incompatible // indirect
 	v2ray.com/ext v4.15.0+incompatible // indirect
 )
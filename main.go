@@ -10,15 +10,16 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"v2ray-stat/api"
+	"v2ray-stat/backup"
 	"v2ray-stat/config"
 	"v2ray-stat/constant"
+	"v2ray-stat/coreapi"
 	"v2ray-stat/db"
 	"v2ray-stat/db/manager"
 	"v2ray-stat/monitor"
@@ -30,8 +31,11 @@ import (
 var (
 	uniqueEntries       = make(map[string]map[string]time.Time)
 	uniqueEntriesMutex  sync.Mutex
-	previousStats       string
-	clientPreviousStats string
+	previousStats       trafficCounters
+	clientPreviousStats trafficCounters
+
+	// Время работы ядра при прошлом опросе для обнаружения перезапуска в режиме reset_on_read
+	previousCoreUptime uint32
 
 	// Хранит статус неактивности пользователя
 	isInactive      = make(map[string]bool)
@@ -55,29 +59,40 @@ func initTimezone(cfg *config.Config) {
 	}
 }
 
-// extractProxyTraffic filters and formats proxy traffic stats from API response.
-func extractProxyTraffic(apiData *api.ApiResponse) []string {
-	var result []string
+// trafficCounters maps "<name> <direction>" to a counter value reported by the core.
+type trafficCounters map[string]int64
+
+// trafficUpdate holds the traffic increments collected for a single source or user during one tick.
+type trafficUpdate struct {
+	uplink       int64
+	downlink     int64
+	sessUplink   int64
+	sessDownlink int64
+}
+
+// extractProxyTraffic filters proxy traffic stats from API response.
+func extractProxyTraffic(apiData *api.ApiResponse) trafficCounters {
+	result := make(trafficCounters)
 	for _, stat := range apiData.Stat {
 		if strings.Contains(stat.Name, "user") || strings.Contains(stat.Name, "api") || strings.Contains(stat.Name, "block") {
 			continue
 		}
 		parts := splitAndCleanName(stat.Name)
 		if len(parts) > 0 {
-			result = append(result, fmt.Sprintf("%s %s", strings.Join(parts, " "), stat.Value))
+			result[strings.Join(parts, " ")] = stat.Value
 		}
 	}
 	return result
 }
 
-// extractUserTraffic filters and formats user traffic stats from API response.
-func extractUserTraffic(apiData *api.ApiResponse) []string {
-	var result []string
+// extractUserTraffic filters user traffic stats from API response.
+func extractUserTraffic(apiData *api.ApiResponse) trafficCounters {
+	result := make(trafficCounters)
 	for _, stat := range apiData.Stat {
 		if strings.Contains(stat.Name, "user") {
 			parts := splitAndCleanName(stat.Name)
 			if len(parts) > 0 {
-				result = append(result, fmt.Sprintf("%s %s", strings.Join(parts, " "), stat.Value))
+				result[strings.Join(parts, " ")] = stat.Value
 			}
 		}
 	}
@@ -93,65 +108,108 @@ func splitAndCleanName(name string) []string {
 	return nil
 }
 
-// updateProxyStats updates proxy traffic statistics in the database.
-func updateProxyStats(manager *manager.DatabaseManager, apiData *api.ApiResponse, cfg *config.Config) {
-	cfg.Logger.Debug("Starting proxy stats update")
-	currentStats := extractProxyTraffic(apiData)
-	if previousStats == "" {
-		previousStats = strings.Join(currentStats, "\n")
-		cfg.Logger.Debug("Initialized previousStats", "count", len(currentStats))
-		return
-	}
-
-	currentValues := make(map[string]int)
-	previousValues := make(map[string]int)
-
-	// Parse current stats
-	for _, line := range currentStats {
-		parts := strings.Fields(line)
-		if len(parts) == 3 {
-			cfg.Logger.Trace("Parsing current stats line", "line", line)
-			currentValues[parts[0]+" "+parts[1]] = stringToInt(cfg, parts[2])
-		} else {
-			cfg.Logger.Warn("Invalid stats line format", "line", line)
+// collectTrafficUpdates converts core counters into per-name increments and returns the session counters to keep for the next tick.
+// In reset mode the core returns deltas that are added to the session totals; otherwise counters are diffed against the previous tick.
+// A counter lower than its previous value means the core was restarted, so its current value is counted as the delta.
+// The number of such counters is returned as resets.
+func collectTrafficUpdates(current, previous trafficCounters, cfg *config.Config) (updates map[string]*trafficUpdate, next trafficCounters, resets int) {
+	updates = make(map[string]*trafficUpdate)
+	next = make(trafficCounters, len(current))
+
+	for key, value := range current {
+		name, direction, ok := strings.Cut(key, " ")
+		if !ok {
+			cfg.Logger.Warn("Invalid stats key format", "key", key)
+			continue
 		}
-	}
 
-	// Parse previous stats
-	for _, line := range strings.Split(previousStats, "\n") {
-		parts := strings.Fields(line)
-		if len(parts) == 3 {
-			cfg.Logger.Trace("Parsing previous stats line", "line", line)
-			previousValues[parts[0]+" "+parts[1]] = stringToInt(cfg, parts[2])
+		var delta, session int64
+		if cfg.Core.API.ResetOnRead {
+			delta = value
+			session = previous[key] + value
+		} else {
+			prev, exists := previous[key]
+			if !exists {
+				cfg.Logger.Warn("Missing previous data for key", "key", key)
+			}
+			if value < prev {
+				cfg.Logger.Debug("Counter reset detected", "key", key, "previous", prev, "current", value)
+				delta = value
+				resets++
+			} else {
+				delta = value - prev
+			}
+			session = value
 		}
-	}
-
-	uplinkValues := make(map[string]int)
-	downlinkValues := make(map[string]int)
-	sessUplinkValues := make(map[string]int)
-	sessDownlinkValues := make(map[string]int)
+		next[key] = session
 
-	for key, current := range currentValues {
-		previous, exists := previousValues[key]
+		update, exists := updates[name]
 		if !exists {
-			cfg.Logger.Warn("Missing previous data for key", "key", key)
-			previous = 0
+			update = &trafficUpdate{}
+			updates[name] = update
 		}
-		diff := max(current-previous, 0)
-		parts := strings.Fields(key)
-		source := parts[0]
-		direction := parts[1]
-
 		switch direction {
 		case "uplink":
-			uplinkValues[source] = diff
-			sessUplinkValues[source] = current
+			update.uplink = delta
+			update.sessUplink = session
 		case "downlink":
-			downlinkValues[source] = diff
-			sessDownlinkValues[source] = current
+			update.downlink = delta
+			update.sessDownlink = session
 		}
 	}
 
+	return updates, next, resets
+}
+
+// detectResetModeRestart reports whether the core was restarted since the previous query in reset mode, where
+// counters always start from zero and a restart cannot be seen in their values. Only the core uptime going down
+// counts as a restart; if the core does not report its uptime, no restart is inferred.
+func detectResetModeRestart(apiData *api.ApiResponse, cfg *config.Config) (restarted bool, details string) {
+	if apiData.Uptime == 0 {
+		cfg.Logger.Trace("Core uptime unavailable, skipping restart detection")
+		return false, ""
+	}
+	restarted = previousCoreUptime > 0 && apiData.Uptime < previousCoreUptime
+	if restarted {
+		details = fmt.Sprintf("core uptime dropped from %ds to %ds", previousCoreUptime, apiData.Uptime)
+	}
+	previousCoreUptime = apiData.Uptime
+	return restarted, details
+}
+
+// trafficRate returns the rate in bits per second for the increments of one tick.
+func trafficRate(update *trafficUpdate, cfg *config.Config) int64 {
+	return (update.uplink + update.downlink) * 8 / int64(cfg.V2rayStat.Monitor.TickerInterval)
+}
+
+// recordHistory stores the non-zero increments of one tick in the traffic history.
+func recordHistory(manager *manager.DatabaseManager, cfg *config.Config, kind string, updates map[string]*trafficUpdate) {
+	if !cfg.Features["history"] {
+		return
+	}
+
+	var deltas []db.TrafficDelta
+	for name, update := range updates {
+		if update.uplink > 0 || update.downlink > 0 {
+			deltas = append(deltas, db.TrafficDelta{Name: name, Uplink: update.uplink, Downlink: update.downlink})
+		}
+	}
+	db.AddTrafficHistory(manager, cfg, kind, deltas, time.Now())
+}
+
+// updateProxyStats updates proxy traffic statistics in the database and returns the number of counters found reset.
+func updateProxyStats(manager *manager.DatabaseManager, apiData *api.ApiResponse, cfg *config.Config) int {
+	cfg.Logger.Debug("Starting proxy stats update")
+	currentStats := extractProxyTraffic(apiData)
+	if previousStats == nil && !cfg.Core.API.ResetOnRead {
+		previousStats = currentStats
+		cfg.Logger.Debug("Initialized previousStats", "count", len(currentStats))
+		return 0
+	}
+
+	firstTick := previousStats == nil
+	updates, nextStats, resets := collectTrafficUpdates(currentStats, previousStats, cfg)
+
 	err := manager.ExecuteHighPriority(func(db *sql.DB) error {
 		tx, err := db.Begin()
 		if err != nil {
@@ -160,28 +218,13 @@ func updateProxyStats(manager *manager.DatabaseManager, apiData *api.ApiResponse
 		}
 		defer tx.Rollback()
 
-		for source := range uplinkValues {
-			uplink := uplinkValues[source]
-			downlink := downlinkValues[source]
-			sessUplink := sessUplinkValues[source]
-			sessDownlink := sessDownlinkValues[source]
-			previousUplink, uplinkExists := previousValues[source+" uplink"]
-			previousDownlink, downlinkExists := previousValues[source+" downlink"]
-
-			if !uplinkExists {
-				cfg.Logger.Warn("Missing previous uplink data", "source", source)
-				previousUplink = 0
-			}
-			if !downlinkExists {
-				cfg.Logger.Warn("Missing previous downlink data", "source", source)
-				previousDownlink = 0
+		for source, update := range updates {
+			var rate int64
+			if !firstTick {
+				rate = trafficRate(update, cfg)
 			}
 
-			uplinkOnline := max(sessUplink-previousUplink, 0)
-			downlinkOnline := max(sessDownlink-previousDownlink, 0)
-			rate := (uplinkOnline + downlinkOnline) * 8 / cfg.V2rayStat.Monitor.TickerInterval
-
-			cfg.Logger.Debug("Updating proxy stats", "source", source, "rate", rate, "uplink", uplink, "downlink", downlink)
+			cfg.Logger.Debug("Updating proxy stats", "source", source, "rate", rate, "uplink", update.uplink, "downlink", update.downlink)
 
 			_, err := tx.Exec(`
 				INSERT INTO traffic_stats (source, rate, uplink, downlink, sess_uplink, sess_downlink)
@@ -192,8 +235,8 @@ func updateProxyStats(manager *manager.DatabaseManager, apiData *api.ApiResponse
 					downlink = downlink + ?,
 					sess_uplink = ?,
 					sess_downlink = ?`,
-				source, rate, uplink, downlink, sessUplink, sessDownlink,
-				rate, uplink, downlink, sessUplink, sessDownlink)
+				source, rate, update.uplink, update.downlink, update.sessUplink, update.sessDownlink,
+				rate, update.uplink, update.downlink, update.sessUplink, update.sessDownlink)
 			if err != nil {
 				cfg.Logger.Error("Failed to update traffic_stats", "source", source, "error", err)
 				return err
@@ -208,94 +251,40 @@ func updateProxyStats(manager *manager.DatabaseManager, apiData *api.ApiResponse
 	})
 	if err != nil {
 		cfg.Logger.Error("Failed to update proxy stats", "error", err)
-		return
+		return 0
 	}
 
+	recordHistory(manager, cfg, db.HistoryKindSource, updates)
+
 	cfg.Logger.Debug("Finished proxy stats update", "entries", len(currentStats))
-	previousStats = strings.Join(currentStats, "\n")
+	previousStats = nextStats
+	return resets
 }
 
-// updateClientStats updates client traffic statistics in the database.
-func updateClientStats(manager *manager.DatabaseManager, apiData *api.ApiResponse, cfg *config.Config) {
+// updateClientStats updates client traffic statistics in the database and returns the number of counters found reset.
+func updateClientStats(manager *manager.DatabaseManager, apiData *api.ApiResponse, cfg *config.Config) int {
 	cfg.Logger.Debug("Starting client stats update")
 
 	clientCurrentStats := extractUserTraffic(apiData)
-	if clientPreviousStats == "" {
-		clientPreviousStats = strings.Join(clientCurrentStats, "\n")
+	if clientPreviousStats == nil && !cfg.Core.API.ResetOnRead {
+		clientPreviousStats = clientCurrentStats
 		cfg.Logger.Debug("Initialized clientPreviousStats", "stats_count", len(clientCurrentStats))
-		return
-	}
-
-	clientCurrentValues := make(map[string]int)
-	clientPreviousValues := make(map[string]int)
-
-	for _, line := range clientCurrentStats {
-		parts := strings.Fields(line)
-		if len(parts) == 3 {
-			cfg.Logger.Trace("Processing client stats line", "line", line)
-			clientCurrentValues[parts[0]+" "+parts[1]] = stringToInt(cfg, parts[2])
-		} else {
-			cfg.Logger.Warn("Invalid client stats line format", "line", line)
-		}
-	}
-
-	previousLines := strings.SplitSeq(clientPreviousStats, "\n")
-	for line := range previousLines {
-		parts := strings.Fields(line)
-		if len(parts) == 3 {
-			cfg.Logger.Trace("Processing previous client stats line", "line", line)
-			clientPreviousValues[parts[0]+" "+parts[1]] = stringToInt(cfg, parts[2])
-		}
+		return 0
 	}
 
-	clientUplinkValues := make(map[string]int)
-	clientDownlinkValues := make(map[string]int)
-	clientSessUplinkValues := make(map[string]int)
-	clientSessDownlinkValues := make(map[string]int)
+	firstTick := clientPreviousStats == nil
+	clientUpdates, nextStats, resets := collectTrafficUpdates(clientCurrentStats, clientPreviousStats, cfg)
 
-	for key, current := range clientCurrentValues {
-		previous, exists := clientPreviousValues[key]
-		if !exists {
-			cfg.Logger.Warn("Missing previous data for key", "key", key)
-			previous = 0
-		}
-		diff := max(current-previous, 0)
-		parts := strings.Fields(key)
-		user := parts[0]
-		direction := parts[1]
-
-		switch direction {
-		case "uplink":
-			clientUplinkValues[user] = diff
-			clientSessUplinkValues[user] = current
-		case "downlink":
-			clientDownlinkValues[user] = diff
-			clientSessDownlinkValues[user] = current
-		}
-	}
-
-	for key := range clientPreviousValues {
-		parts := strings.Fields(key)
-		if len(parts) != 2 {
+	// Users that disappeared from the core keep zero session values
+	for key := range clientPreviousStats {
+		user, _, ok := strings.Cut(key, " ")
+		if !ok {
 			cfg.Logger.Warn("Invalid key format in previous stats", "key", key)
 			continue
 		}
-		user := parts[0]
-		direction := parts[1]
-
-		switch direction {
-		case "uplink":
-			if _, exists := clientSessUplinkValues[user]; !exists {
-				cfg.Logger.Debug("Setting zero values for uplink", "user", user)
-				clientSessUplinkValues[user] = 0
-				clientUplinkValues[user] = 0
-			}
-		case "downlink":
-			if _, exists := clientSessDownlinkValues[user]; !exists {
-				cfg.Logger.Debug("Setting zero values for downlink", "user", user)
-				clientSessDownlinkValues[user] = 0
-				clientDownlinkValues[user] = 0
-			}
+		if _, exists := clientUpdates[user]; !exists {
+			cfg.Logger.Debug("Setting zero values for client", "user", user)
+			clientUpdates[user] = &trafficUpdate{}
 		}
 	}
 
@@ -311,31 +300,16 @@ func updateClientStats(manager *manager.DatabaseManager, apiData *api.ApiRespons
 		isInactiveMutex.Lock()
 		defer isInactiveMutex.Unlock()
 
-		for user := range clientUplinkValues {
-			uplink := clientUplinkValues[user]
-			downlink := clientDownlinkValues[user]
-			sessUplink := clientSessUplinkValues[user]
-			sessDownlink := clientSessDownlinkValues[user]
-			previousUplink, uplinkExists := clientPreviousValues[user+" uplink"]
-			previousDownlink, downlinkExists := clientPreviousValues[user+" downlink"]
-
-			if !uplinkExists {
-				cfg.Logger.Warn("Missing previous uplink data", "user", user)
-				previousUplink = 0
+		for user, update := range clientUpdates {
+			var rate int64
+			if !firstTick {
+				rate = trafficRate(update, cfg)
 			}
-			if !downlinkExists {
-				cfg.Logger.Warn("Missing previous downlink data", "user", user)
-				previousDownlink = 0
-			}
-
-			uplinkOnline := max(sessUplink-previousUplink, 0)
-			downlinkOnline := max(sessDownlink-previousDownlink, 0)
-			rate := (uplinkOnline + downlinkOnline) * 8 / cfg.V2rayStat.Monitor.TickerInterval
 
-			cfg.Logger.Debug("Updating stats for client", "user", user, "rate", rate, "uplink", uplink, "downlink", downlink)
+			cfg.Logger.Debug("Updating stats for client", "user", user, "rate", rate, "uplink", update.uplink, "downlink", update.downlink)
 
 			var lastSeen string
-			if rate > cfg.V2rayStat.Monitor.OnlineRateThreshold*1000 {
+			if rate > int64(cfg.V2rayStat.Monitor.OnlineRateThreshold)*1000 {
 				lastSeen = "online"
 				isInactive[user] = false
 				cfg.Logger.Debug("Client is active", "user", user)
@@ -354,7 +328,7 @@ func updateClientStats(manager *manager.DatabaseManager, apiData *api.ApiRespons
 					WHERE user = ? AND EXISTS (
 						SELECT 1 FROM clients_stats WHERE user = ?
 					)`,
-					lastSeen, rate, uplink, downlink, sessUplink, sessDownlink, user, user)
+					lastSeen, rate, update.uplink, update.downlink, update.sessUplink, update.sessDownlink, user, user)
 				if err != nil {
 					cfg.Logger.Error("Failed to execute query for client", "user", user, "error", err)
 					return fmt.Errorf("failed to execute query for %s: %v", user, err)
@@ -366,7 +340,7 @@ func updateClientStats(manager *manager.DatabaseManager, apiData *api.ApiRespons
 					WHERE user = ? AND EXISTS (
 						SELECT 1 FROM clients_stats WHERE user = ?
 					)`,
-					rate, uplink, downlink, sessUplink, sessDownlink, user, user)
+					rate, update.uplink, update.downlink, update.sessUplink, update.sessDownlink, user, user)
 				if err != nil {
 					cfg.Logger.Error("Failed to execute query for client", "user", user, "error", err)
 					return fmt.Errorf("failed to execute query for %s: %v", user, err)
@@ -382,20 +356,14 @@ func updateClientStats(manager *manager.DatabaseManager, apiData *api.ApiRespons
 	})
 	if err != nil {
 		cfg.Logger.Error("SQL error in updateClientStats", "error", err)
-		return
+		return 0
 	}
 
-	clientPreviousStats = strings.Join(clientCurrentStats, "\n")
-	cfg.Logger.Debug("Client stats successfully updated", "stats_count", len(clientCurrentStats))
-}
+	recordHistory(manager, cfg, db.HistoryKindUser, clientUpdates)
 
-func stringToInt(cfg *config.Config, s string) int {
-	result, err := strconv.Atoi(s)
-	if err != nil {
-		cfg.Logger.Warn("Failed to convert string to integer", "string", s, "error", err)
-		return 0
-	}
-	return result
+	clientPreviousStats = nextStats
+	cfg.Logger.Debug("Client stats successfully updated", "stats_count", len(clientCurrentStats))
+	return resets
 }
 
 func processLogLine(line string, dnsStats map[string]map[string]int, cfg *config.Config) (string, []string, bool) {
@@ -539,8 +507,20 @@ func monitorUsersAndLogs(ctx context.Context, manager *manager.DatabaseManager,
 				if err != nil {
 					cfg.Logger.Error("Failed to retrieve API data", "error", err)
 				} else {
-					updateProxyStats(manager, apiData, cfg)
-					updateClientStats(manager, apiData, cfg)
+					resets := updateProxyStats(manager, apiData, cfg) + updateClientStats(manager, apiData, cfg)
+					if resets > 0 {
+						cfg.Logger.Warn("Core counters were reset, core restart detected", "counters", resets)
+						db.AddCoreEvent(manager, cfg, db.EventCoreRestarted, fmt.Sprintf("%d counters reset", resets))
+					}
+					if cfg.Core.API.ResetOnRead {
+						if restarted, details := detectResetModeRestart(apiData, cfg); restarted {
+							cfg.Logger.Warn("Core restart detected", "details", details)
+							db.AddCoreEvent(manager, cfg, db.EventCoreRestarted, details)
+						}
+					}
+				}
+				if err := db.CheckTrafficLimits(manager, cfg); err != nil {
+					cfg.Logger.Error("Failed to check traffic limits", "error", err)
 				}
 				readNewLines(manager, accessLog, &accessOffset, cfg)
 
@@ -589,19 +569,44 @@ func startAPIServer(ctx context.Context, manager *manager.DatabaseManager, cfg *
 	http.HandleFunc("/api/v1/stats", api.StatsCustomHandler(manager, cfg))
 	http.HandleFunc("/api/v1/stats/base", api.StatsHandler(manager, cfg))
 	http.HandleFunc("/api/v1/dns_stats", api.DnsStatsHandler(manager, cfg))
+	http.HandleFunc("/api/v1/core_events", api.CoreEventsHandler(manager, cfg))
+	http.HandleFunc("/api/v1/history", api.HistoryHandler(manager, cfg))
+	http.HandleFunc("/api/v1/traffic_resets", api.TrafficResetsHandler(manager, cfg))
+	http.HandleFunc("/api/v1/notification_state", api.NotificationStateHandler(manager, cfg))
+
+	if cfg.Features["metrics"] {
+		http.HandleFunc("/metrics", api.MetricsHandler(manager, cfg))
+	}
+	if cfg.Features["subscription"] {
+		http.HandleFunc("/sub/", api.SubscriptionHandler(manager, cfg))
+	}
 
 	// Data-modifying endpoints (token required)
-	http.HandleFunc("/api/v1/add_user", api.TokenAuthMiddleware(cfg, api.AddUserHandler(cfg)))
-	http.HandleFunc("/api/v1/bulk_add_users", api.TokenAuthMiddleware(cfg, api.BulkAddUsersHandler(cfg)))
-	http.HandleFunc("/api/v1/delete_user", api.TokenAuthMiddleware(cfg, api.DeleteUserHandler(cfg)))
-	http.HandleFunc("/api/v1/set_enabled", api.TokenAuthMiddleware(cfg, api.SetEnabledHandler(manager, cfg)))
+	http.HandleFunc("/api/v1/add_user", api.TokenAuthMiddleware(cfg, api.ConfigBackupMiddleware(cfg, api.AddUserHandler(cfg))))
+	http.HandleFunc("/api/v1/bulk_add_users", api.TokenAuthMiddleware(cfg, api.ConfigBackupMiddleware(cfg, api.BulkAddUsersHandler(manager, cfg))))
+	http.HandleFunc("/api/v1/users/export", api.TokenAuthMiddleware(cfg, api.ExportUsersHandler(manager, cfg)))
+	http.HandleFunc("/api/v1/users/import", api.TokenAuthMiddleware(cfg, api.ConfigBackupMiddleware(cfg, api.ImportUsersHandler(manager, cfg))))
+	http.HandleFunc("/api/v1/delete_user", api.TokenAuthMiddleware(cfg, api.ConfigBackupMiddleware(cfg, api.DeleteUserHandler(cfg))))
+	http.HandleFunc("/api/v1/set_enabled", api.TokenAuthMiddleware(cfg, api.ConfigBackupMiddleware(cfg, api.SetEnabledHandler(manager, cfg))))
+	http.HandleFunc("/api/v1/rotate_credential", api.TokenAuthMiddleware(cfg, api.ConfigBackupMiddleware(cfg, api.RotateCredentialHandler(manager, cfg))))
+	http.HandleFunc("/api/v1/rename_user", api.TokenAuthMiddleware(cfg, api.ConfigBackupMiddleware(cfg, api.RenameUserHandler(manager, cfg))))
 	http.HandleFunc("/api/v1/update_lim_ip", api.TokenAuthMiddleware(cfg, api.UpdateIPLimitHandler(manager, cfg)))
+	http.HandleFunc("/api/v1/update_traffic_limit", api.TokenAuthMiddleware(cfg, api.UpdateTrafficLimitHandler(manager, cfg)))
+	http.HandleFunc("/api/v1/update_reset_policy", api.TokenAuthMiddleware(cfg, api.UpdateResetPolicyHandler(manager, cfg)))
 	http.HandleFunc("/api/v1/adjust_date", api.TokenAuthMiddleware(cfg, api.AdjustDateOffsetHandler(manager, cfg)))
 	http.HandleFunc("/api/v1/update_renew", api.TokenAuthMiddleware(cfg, api.UpdateRenewHandler(manager, cfg)))
 	http.HandleFunc("/api/v1/delete_dns_stats", api.TokenAuthMiddleware(cfg, api.DeleteDNSStatsHandler(manager, cfg)))
 	http.HandleFunc("/api/v1/reset_traffic", api.TokenAuthMiddleware(cfg, api.ResetTrafficHandler(cfg)))
 	http.HandleFunc("/api/v1/reset_traffic_stats", api.TokenAuthMiddleware(cfg, api.ResetTrafficStatsHandler(manager, cfg)))
 	http.HandleFunc("/api/v1/reset_clients_stats", api.TokenAuthMiddleware(cfg, api.ResetClientsStatsHandler(manager, cfg)))
+	http.HandleFunc("/api/v1/clear_notification_state", api.TokenAuthMiddleware(cfg, api.ClearNotificationStateHandler(manager, cfg)))
+	http.HandleFunc("/api/v1/config_versions", api.TokenAuthMiddleware(cfg, api.ConfigVersionsHandler(cfg)))
+	http.HandleFunc("/api/v1/config_versions/diff", api.TokenAuthMiddleware(cfg, api.ConfigVersionsDiffHandler(cfg)))
+	http.HandleFunc("/api/v1/config_versions/rollback", api.TokenAuthMiddleware(cfg, api.ConfigRollbackHandler(cfg)))
+
+	// v2 user resource; changes are authorized by the handler itself
+	http.HandleFunc("/api/v2/users", api.ConfigBackupMiddleware(cfg, api.UsersV2Handler(manager, cfg)))
+	http.HandleFunc("/api/v2/users/", api.ConfigBackupMiddleware(cfg, api.UsersV2Handler(manager, cfg)))
 
 	cfg.Logger.Debug("Starting API server", "address", server.Addr)
 
@@ -630,6 +635,7 @@ func main() {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
 	initTimezone(&cfg)
+	backup.Snapshot(&cfg, "startup")
 
 	// Setup context and signals
 	ctx, cancel := context.WithCancel(context.Background())
@@ -664,6 +670,15 @@ func main() {
 	db.MonitorSubscriptionsAndSync(ctx, manager, fileDB, &cfg, &wg)
 	monitor.MonitorExcessIPs(ctx, manager, &cfg, &wg)
 	monitor.MonitorBannedLog(ctx, &cfg, &wg)
+	db.MonitorTrafficResets(ctx, manager, &cfg, &wg)
+
+	if coreapi.LiveUpdateEnabled(&cfg) {
+		coreapi.MonitorReconcile(ctx, &cfg, &wg)
+	}
+
+	if cfg.Features["history"] {
+		db.MonitorTrafficHistory(ctx, manager, &cfg, &wg)
+	}
 
 	if cfg.Features["network"] {
 		if err := stats.InitNetworkMonitoring(&cfg); err != nil {
@@ -674,7 +689,7 @@ func main() {
 
 	if cfg.Features["telegram"] {
 		stats.MonitorDailyReport(ctx, manager, &cfg, &wg)
-		stats.MonitorStats(ctx, &cfg, &wg)
+		stats.MonitorStats(ctx, manager, &cfg, &wg)
 	}
 
 	log.Printf("[START] v2ray-stat application %s, with core: %s", constant.Version, cfg.V2rayStat.Type)
@@ -684,6 +699,7 @@ func main() {
 	cfg.Logger.Info("Received termination signal, saving data")
 	cancel()
 	wg.Wait()
+	coreapi.Close()
 
 	// Ensure file database exists before final synchronization
 	syncCtx, syncCancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -15,19 +15,25 @@ import (
 	"time"
 
 	"v2ray-stat/config"
+	"v2ray-stat/db"
 	"v2ray-stat/db/manager"
 	"v2ray-stat/telegram"
 	"v2ray-stat/util"
 )
 
+// Service and alert states persisted in notification_state.
+const (
+	serviceStateRunning = "running"
+	serviceStateStopped = "stopped"
+	alertStateExceeded  = "exceeded"
+	alertKeyDisk        = "disk"
+	alertKeyMemory      = "memory"
+)
+
 var (
-	serviceStatuses   = make(map[string]bool)
-	isFirstCheck      = true
 	statusMutex       sync.Mutex
 	diskMutex         sync.Mutex
 	memoryMutex       sync.Mutex
-	diskExceeded      bool
-	memoryExceeded    bool
 	diskPercentages   []float64
 	memoryPercentages []float64
 )
@@ -98,16 +104,25 @@ func getIPAddresses(cfg *config.Config) (ipv4, ipv6 string) {
 	return ipv4, ipv6
 }
 
-// GetUptime returns the system uptime.
-func GetUptime(cfg *config.Config) string {
+// GetUptimeSeconds returns the system uptime in seconds.
+func GetUptimeSeconds(cfg *config.Config) (float64, error) {
 	cfg.Logger.Debug("Retrieving system uptime")
 	data, err := os.ReadFile("/proc/uptime")
 	if err != nil {
 		cfg.Logger.Error("Failed to read /proc/uptime", "error", err)
-		return "unknown"
+		return 0, fmt.Errorf("failed to read /proc/uptime: %v", err)
 	}
 	var uptimeSeconds float64
 	fmt.Sscanf(string(data), "%f", &uptimeSeconds)
+	return uptimeSeconds, nil
+}
+
+// GetUptime returns the system uptime.
+func GetUptime(cfg *config.Config) string {
+	uptimeSeconds, err := GetUptimeSeconds(cfg)
+	if err != nil {
+		return "unknown"
+	}
 
 	days := int(uptimeSeconds / (24 * 3600))
 	hours := int(uptimeSeconds/3600) % 24
@@ -115,27 +130,35 @@ func GetUptime(cfg *config.Config) string {
 	return fmt.Sprintf("%d days %02d hours", days, hours)
 }
 
-// GetLoadAverage returns the system load average.
-func GetLoadAverage(cfg *config.Config) string {
+// GetLoadAverageValues returns the 1, 5 and 15 minute system load averages.
+func GetLoadAverageValues(cfg *config.Config) (load1, load5, load15 float64, err error) {
 	cfg.Logger.Debug("Retrieving system load average")
 	data, err := os.ReadFile("/proc/loadavg")
 	if err != nil {
 		cfg.Logger.Error("Failed to read /proc/loadavg", "error", err)
-		return "unknown"
+		return 0, 0, 0, fmt.Errorf("failed to read /proc/loadavg: %v", err)
 	}
-	var load1, load5, load15 float64
 	fmt.Sscanf(string(data), "%f %f %f", &load1, &load5, &load15)
 	cfg.Logger.Trace("System load average retrieved", "load1", load1, "load5", load5, "load15", load15)
+	return load1, load5, load15, nil
+}
+
+// GetLoadAverage returns the system load average.
+func GetLoadAverage(cfg *config.Config) string {
+	load1, load5, load15, err := GetLoadAverageValues(cfg)
+	if err != nil {
+		return "unknown"
+	}
 	return fmt.Sprintf("%.2f, %.2f, %.2f", load1, load5, load15)
 }
 
-// GetMemoryUsage returns memory usage information without sending notifications.
-func GetMemoryUsage(cfg *config.Config) string {
+// GetMemoryBytes returns used and total memory in bytes.
+func GetMemoryBytes(cfg *config.Config) (used, total uint64, err error) {
 	cfg.Logger.Debug("Retrieving memory usage")
 	data, err := os.ReadFile("/proc/meminfo")
 	if err != nil {
 		cfg.Logger.Error("Failed to read /proc/meminfo", "error", err)
-		return "unknown"
+		return 0, 0, fmt.Errorf("failed to read /proc/meminfo: %v", err)
 	}
 
 	var memTotal, memAvailable uint64
@@ -158,12 +181,24 @@ func GetMemoryUsage(cfg *config.Config) string {
 
 	if memTotal == 0 {
 		cfg.Logger.Error("Invalid memory data: MemTotal is zero")
+		return 0, 0, fmt.Errorf("invalid memory data: MemTotal is zero")
+	}
+
+	// /proc/meminfo reports values in kB
+	return (memTotal - memAvailable) * 1024, memTotal * 1024, nil
+}
+
+// GetMemoryUsage returns memory usage information without sending notifications.
+func GetMemoryUsage(cfg *config.Config) string {
+	used, total, err := GetMemoryBytes(cfg)
+	if err != nil {
 		return "unknown"
 	}
 
-	usedMem := memTotal - memAvailable
-	cfg.Logger.Trace("Memory usage retrieved", "used_mb", float64(usedMem)/1024, "total_mb", float64(memTotal)/1024)
-	return fmt.Sprintf("%.2f MB used / %.2f MB total", float64(usedMem)/1024, float64(memTotal)/1024)
+	usedMB := float64(used) / (1024 * 1024)
+	totalMB := float64(total) / (1024 * 1024)
+	cfg.Logger.Trace("Memory usage retrieved", "used_mb", usedMB, "total_mb", totalMB)
+	return fmt.Sprintf("%.2f MB used / %.2f MB total", usedMB, totalMB)
 }
 
 // getConnectionCounts returns the number of TCP and UDP connections.
@@ -219,8 +254,8 @@ func LoadTrafficStats(manager *manager.DatabaseManager, cfg *config.Config) (tot
 	return totalTraffic, uplinkTraffic, downlinkTraffic, nil
 }
 
-// isServiceRunning checks if a service is running by checking /proc.
-func isServiceRunning(svc string, cfg *config.Config) bool {
+// IsServiceRunning checks if a service is running by checking /proc.
+func IsServiceRunning(svc string, cfg *config.Config) bool {
 	cfg.Logger.Debug("Checking service status", "service", svc)
 	procDir, err := os.Open("/proc")
 	if err != nil {
@@ -256,24 +291,39 @@ func isServiceRunning(svc string, cfg *config.Config) bool {
 }
 
 // CheckServiceStatus checks service statuses and sends notifications if changed.
-func CheckServiceStatus(cfg *config.Config) {
+// The last seen statuses are stored in the database, so changes that happen while
+// v2ray-stat is stopped are reported after restart.
+func CheckServiceStatus(manager *manager.DatabaseManager, cfg *config.Config) {
 	cfg.Logger.Debug("Checking service statuses")
 	statusMutex.Lock()
 	defer statusMutex.Unlock()
 
+	serviceStatuses, err := db.LoadNotificationState(manager, cfg, db.NotificationKindService)
+	if err != nil {
+		return
+	}
+
 	var changed []string
 	var statusLines []string
 
 	for _, svc := range cfg.Services {
-		running := isServiceRunning(svc, cfg)
+		running := IsServiceRunning(svc, cfg)
+		current := serviceStateStopped
+		if running {
+			current = serviceStateRunning
+		}
 		prev, seen := serviceStatuses[svc]
 
-		if !isFirstCheck && seen && prev != running {
+		if seen && prev != current {
 			cfg.Logger.Info("Service status changed", "service", svc, "running", running)
 			changed = append(changed, svc)
 		}
+		if !seen || prev != current {
+			if err := db.SetNotificationState(manager, cfg, db.NotificationKindService, svc, current); err != nil {
+				cfg.Logger.Error("Failed to store service status", "service", svc, "error", err)
+			}
+		}
 
-		serviceStatuses[svc] = running
 		state := "▼"
 		if running {
 			state = "▲"
@@ -281,7 +331,7 @@ func CheckServiceStatus(cfg *config.Config) {
 		statusLines = append(statusLines, fmt.Sprintf("%s %s", state, svc))
 	}
 
-	if !isFirstCheck && len(changed) > 0 {
+	if len(changed) > 0 {
 		message := fmt.Sprintf("⚠️ Service Status Update:\n%s", strings.Join(statusLines, "\n"))
 		if err := telegram.SendNotification(cfg, message); err != nil {
 			cfg.Logger.Error("Failed to send service status notification", "error", err)
@@ -289,15 +339,32 @@ func CheckServiceStatus(cfg *config.Config) {
 			cfg.Logger.Info("Service status notification sent successfully")
 		}
 	}
+}
+
+// isAlertActive reports whether a resource alert is stored as exceeded.
+func isAlertActive(manager *manager.DatabaseManager, cfg *config.Config, resource string) (bool, error) {
+	states, err := db.GetNotificationStates(manager, cfg, db.NotificationKindAlert, resource)
+	if err != nil {
+		return false, err
+	}
+	return len(states) > 0 && states[0].Value == alertStateExceeded, nil
+}
 
-	if isFirstCheck {
-		cfg.Logger.Debug("First service status check completed")
-		isFirstCheck = false
+// setAlertActive stores or clears the exceeded state of a resource alert.
+func setAlertActive(manager *manager.DatabaseManager, cfg *config.Config, resource string, active bool) {
+	var err error
+	if active {
+		err = db.SetNotificationState(manager, cfg, db.NotificationKindAlert, resource, alertStateExceeded)
+	} else {
+		_, err = db.ClearNotificationState(manager, cfg, db.NotificationKindAlert, resource)
+	}
+	if err != nil {
+		cfg.Logger.Error("Failed to store alert state", "resource", resource, "active", active, "error", err)
 	}
 }
 
 // CheckMemoryUsage checks memory usage and sends notifications if thresholds are exceeded.
-func CheckMemoryUsage(cfg *config.Config) {
+func CheckMemoryUsage(manager *manager.DatabaseManager, cfg *config.Config) {
 	cfg.Logger.Debug("Checking memory usage")
 	data, err := os.ReadFile("/proc/meminfo")
 	if err != nil {
@@ -349,13 +416,18 @@ func CheckMemoryUsage(cfg *config.Config) {
 		average := sum / float64(len(memoryPercentages))
 		cfg.Logger.Debug("Calculated average memory usage", "average", average)
 
+		memoryExceeded, err := isAlertActive(manager, cfg, alertKeyMemory)
+		if err != nil {
+			return
+		}
+
 		if average > float64(cfg.SystemMonitoring.Memory.Threshold) && !memoryExceeded {
 			message := fmt.Sprintf("🚨 ALERT: Average memory usage over *%d* seconds exceeded *%d%%*! (Current: *%.2f%%*)", cfg.SystemMonitoring.AverageInterval, cfg.SystemMonitoring.Memory.Threshold, average)
 			if err := telegram.SendNotification(cfg, message); err != nil {
 				cfg.Logger.Error("Failed to send memory usage notification", "error", err)
 			} else {
 				cfg.Logger.Info("Memory usage notification sent successfully")
-				memoryExceeded = true
+				setAlertActive(manager, cfg, alertKeyMemory, true)
 			}
 		} else if average <= float64(cfg.SystemMonitoring.Memory.Threshold) && memoryExceeded {
 			message := fmt.Sprintf("✅ Average memory usage over *%d* seconds dropped below *%d%%*. (Current: *%.2f%%*)", cfg.SystemMonitoring.AverageInterval, cfg.SystemMonitoring.Memory.Threshold, average)
@@ -363,27 +435,35 @@ func CheckMemoryUsage(cfg *config.Config) {
 				cfg.Logger.Error("Failed to send memory usage notification", "error", err)
 			} else {
 				cfg.Logger.Info("Memory usage notification sent successfully")
-				memoryExceeded = false
+				setAlertActive(manager, cfg, alertKeyMemory, false)
 			}
 		}
 	}
 }
 
-// GetDiskUsage returns disk usage information without sending notifications.
-func GetDiskUsage(cfg *config.Config) string {
+// GetDiskBytes returns used and total disk space of the root filesystem in bytes.
+func GetDiskBytes(cfg *config.Config) (used, total uint64, err error) {
 	cfg.Logger.Debug("Retrieving disk usage")
 	var stat syscall.Statfs_t
 	if err := syscall.Statfs("/", &stat); err != nil {
 		cfg.Logger.Error("Failed to get disk usage", "error", err)
-		return "unknown"
+		return 0, 0, fmt.Errorf("failed to get disk usage: %v", err)
 	}
 
-	total := stat.Blocks * uint64(stat.Bsize)
+	total = stat.Blocks * uint64(stat.Bsize)
 	free := stat.Bfree * uint64(stat.Bsize)
-	used := total - free
 
 	if total == 0 {
 		cfg.Logger.Error("Invalid disk data: total size is zero")
+		return 0, 0, fmt.Errorf("invalid disk data: total size is zero")
+	}
+	return total - free, total, nil
+}
+
+// GetDiskUsage returns disk usage information without sending notifications.
+func GetDiskUsage(cfg *config.Config) string {
+	used, total, err := GetDiskBytes(cfg)
+	if err != nil {
 		return "unknown"
 	}
 
@@ -392,7 +472,7 @@ func GetDiskUsage(cfg *config.Config) string {
 }
 
 // CheckDiskUsage checks disk usage and sends notifications if thresholds are exceeded.
-func CheckDiskUsage(cfg *config.Config) {
+func CheckDiskUsage(manager *manager.DatabaseManager, cfg *config.Config) {
 	cfg.Logger.Debug("Checking disk usage")
 	var stat syscall.Statfs_t
 	if err := syscall.Statfs("/", &stat); err != nil {
@@ -429,13 +509,18 @@ func CheckDiskUsage(cfg *config.Config) {
 		average := sum / float64(len(diskPercentages))
 		cfg.Logger.Debug("Calculated average disk usage", "average", average)
 
+		diskExceeded, err := isAlertActive(manager, cfg, alertKeyDisk)
+		if err != nil {
+			return
+		}
+
 		if average > float64(cfg.SystemMonitoring.Disk.Threshold) && !diskExceeded {
 			message := fmt.Sprintf("🚨 ALERT: Average disk usage over *%d* seconds exceeded *%d%%*! (Current: *%.2f%%*)", cfg.SystemMonitoring.AverageInterval, cfg.SystemMonitoring.Disk.Threshold, average)
 			if err := telegram.SendNotification(cfg, message); err != nil {
 				cfg.Logger.Error("Failed to send disk usage notification", "error", err)
 			} else {
 				cfg.Logger.Info("Disk usage notification sent successfully")
-				diskExceeded = true
+				setAlertActive(manager, cfg, alertKeyDisk, true)
 			}
 		} else if average <= float64(cfg.SystemMonitoring.Disk.Threshold) && diskExceeded {
 			message := fmt.Sprintf("✅ Average disk usage over *%d* seconds dropped below *%d%%*. (Current: *%.2f%%*)", cfg.SystemMonitoring.AverageInterval, cfg.SystemMonitoring.Disk.Threshold, average)
@@ -443,29 +528,41 @@ func CheckDiskUsage(cfg *config.Config) {
 				cfg.Logger.Error("Failed to send disk usage notification", "error", err)
 			} else {
 				cfg.Logger.Info("Disk usage notification sent successfully")
-				diskExceeded = false
+				setAlertActive(manager, cfg, alertKeyDisk, false)
 			}
 		}
 	}
 }
 
-// GetStatus returns the status of specified services without sending notifications.
-func GetStatus(cfg *config.Config) string {
-	cfg.Logger.Debug("Retrieving service statuses")
-	var status strings.Builder
+// ServiceState describes whether a monitored service is running.
+type ServiceState struct {
+	Name    string `json:"name"`
+	Running bool   `json:"running"`
+}
 
+// GetServiceStates returns the state of specified services without sending notifications.
+func GetServiceStates(cfg *config.Config) []ServiceState {
+	cfg.Logger.Debug("Retrieving service statuses")
 	statusMutex.Lock()
 	defer statusMutex.Unlock()
 
+	states := make([]ServiceState, 0, len(cfg.Services))
 	for _, svc := range cfg.Services {
-		isRunning := isServiceRunning(svc, cfg)
-		serviceStatuses[svc] = isRunning
+		isRunning := IsServiceRunning(svc, cfg)
+		states = append(states, ServiceState{Name: svc, Running: isRunning})
+	}
+	return states
+}
 
-		state := "▼"
-		if isRunning {
-			state = "▲"
+// GetStatus returns the status of specified services without sending notifications.
+func GetStatus(cfg *config.Config) string {
+	var status strings.Builder
+	for _, state := range GetServiceStates(cfg) {
+		symbol := "▼"
+		if state.Running {
+			symbol = "▲"
 		}
-		fmt.Fprintf(&status, "%s %s ", state, svc)
+		fmt.Fprintf(&status, "%s %s ", symbol, state.Name)
 	}
 
 	result := strings.TrimSpace(status.String())
@@ -474,7 +571,7 @@ func GetStatus(cfg *config.Config) string {
 }
 
 // MonitorStats runs periodic checks for service, disk, and memory usage.
-func MonitorStats(ctx context.Context, cfg *config.Config, wg *sync.WaitGroup) {
+func MonitorStats(ctx context.Context, manager *manager.DatabaseManager, cfg *config.Config, wg *sync.WaitGroup) {
 	cfg.Logger.Debug("Starting stats monitoring")
 	wg.Add(1)
 	go func() {
@@ -485,9 +582,9 @@ func MonitorStats(ctx context.Context, cfg *config.Config, wg *sync.WaitGroup) {
 			select {
 			case <-ticker.C:
 				cfg.Logger.Debug("Running periodic stats check")
-				CheckServiceStatus(cfg)
-				CheckDiskUsage(cfg)
-				CheckMemoryUsage(cfg)
+				CheckServiceStatus(manager, cfg)
+				CheckDiskUsage(manager, cfg)
+				CheckMemoryUsage(manager, cfg)
 			case <-ctx.Done():
 				cfg.Logger.Debug("Stopped stats monitoring")
 				return
@@ -0,0 +1,305 @@
+package subscription
+
+import (
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"v2ray-stat/config"
+)
+
+// Endpoint describes one connection of a user, built from an inbound definition of the core config.
+type Endpoint struct {
+	Name        string
+	Protocol    string
+	Server      string
+	Port        int
+	Credential  string
+	Flow        string
+	Encryption  string
+	Network     string
+	Security    string
+	SNI         string
+	ALPN        []string
+	Fingerprint string
+	PublicKey   string
+	ShortID     string
+	SpiderX     string
+	Path        string
+	Host        string
+	ServiceName string
+	Mode        string
+	HeaderType  string
+}
+
+// obj returns a nested object of a JSON map, or nil.
+func obj(m map[string]any, key string) map[string]any {
+	v, _ := m[key].(map[string]any)
+	return v
+}
+
+// str returns a string value of a JSON map, or an empty string.
+func str(m map[string]any, key string) string {
+	v, _ := m[key].(string)
+	return v
+}
+
+// strSlice returns a string array of a JSON map; a single string is returned as a one-element slice.
+func strSlice(m map[string]any, key string) []string {
+	switch v := m[key].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var result []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// first returns the first element of a slice, or an empty string.
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// realityPublicKey derives the Reality public key from the server's X25519 private key.
+func realityPublicKey(privateKey string) string {
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(privateKey, "="))
+	if err != nil {
+		return ""
+	}
+	priv, err := ecdh.X25519().NewPrivateKey(key)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(priv.PublicKey().Bytes())
+}
+
+// includeInbound reports whether an inbound is published in subscriptions.
+func includeInbound(cfg *config.Config, protocol, tag string) bool {
+	if protocol != "vless" && protocol != "trojan" {
+		return false
+	}
+	return len(cfg.Subscription.Inbounds) == 0 || slices.Contains(cfg.Subscription.Inbounds, tag)
+}
+
+// xrayEndpoint builds an endpoint from an Xray inbound and one of its clients.
+func xrayEndpoint(inbound config.XrayInbound, client config.XrayClient) Endpoint {
+	ss := inbound.StreamSettings
+	e := Endpoint{
+		Name:       inbound.Tag,
+		Protocol:   inbound.Protocol,
+		Port:       inbound.PortNumber(),
+		Credential: client.Credential(inbound.Protocol),
+		Flow:       client.Flow,
+		Network:    str(ss, "network"),
+		Security:   str(ss, "security"),
+	}
+	if inbound.Protocol == "vless" {
+		e.Encryption = "none"
+		if inbound.Settings.Decryption != nil && *inbound.Settings.Decryption != "" {
+			e.Encryption = *inbound.Settings.Decryption
+		}
+	}
+
+	switch e.Network {
+	case "", "raw":
+		e.Network = "tcp"
+	case "h2":
+		e.Network = "http"
+	case "splithttp":
+		e.Network = "xhttp"
+	}
+	if e.Security == "" {
+		e.Security = "none"
+	}
+
+	switch e.Security {
+	case "tls":
+		t := obj(ss, "tlsSettings")
+		e.SNI = str(t, "serverName")
+		e.ALPN = strSlice(t, "alpn")
+		e.Fingerprint = str(t, "fingerprint")
+	case "reality":
+		r := obj(ss, "realitySettings")
+		settings := obj(r, "settings")
+		e.SNI = first(strSlice(r, "serverNames"))
+		e.ShortID = first(strSlice(r, "shortIds"))
+		e.PublicKey = str(settings, "publicKey")
+		if e.PublicKey == "" {
+			e.PublicKey = realityPublicKey(str(r, "privateKey"))
+		}
+		e.Fingerprint = str(settings, "fingerprint")
+		if e.Fingerprint == "" {
+			e.Fingerprint = "chrome"
+		}
+		e.SpiderX = str(settings, "spiderX")
+	}
+
+	switch e.Network {
+	case "ws":
+		w := obj(ss, "wsSettings")
+		e.Path = str(w, "path")
+		e.Host = str(w, "host")
+		if e.Host == "" {
+			e.Host = str(obj(w, "headers"), "Host")
+		}
+	case "grpc":
+		g := obj(ss, "grpcSettings")
+		e.ServiceName = str(g, "serviceName")
+		e.Mode = "gun"
+		if multi, _ := g["multiMode"].(bool); multi {
+			e.Mode = "multi"
+		}
+	case "httpupgrade":
+		h := obj(ss, "httpupgradeSettings")
+		e.Path = str(h, "path")
+		e.Host = str(h, "host")
+	case "xhttp":
+		x := obj(ss, "xhttpSettings")
+		if x == nil {
+			x = obj(ss, "splithttpSettings")
+		}
+		e.Path = str(x, "path")
+		e.Host = str(x, "host")
+		e.Mode = str(x, "mode")
+	case "http":
+		h := obj(ss, "httpSettings")
+		e.Path = str(h, "path")
+		e.Host = first(strSlice(h, "host"))
+	case "tcp":
+		t := obj(ss, "tcpSettings")
+		if t == nil {
+			t = obj(ss, "rawSettings")
+		}
+		header := obj(t, "header")
+		if str(header, "type") == "http" {
+			e.HeaderType = "http"
+			request := obj(header, "request")
+			e.Path = first(strSlice(request, "path"))
+			e.Host = first(strSlice(obj(request, "headers"), "Host"))
+		}
+	}
+	return e
+}
+
+// singboxEndpoint builds an endpoint from a Singbox inbound and one of its users.
+func singboxEndpoint(inbound config.SingboxInbound, user config.SingboxClient) Endpoint {
+	e := Endpoint{
+		Name:       inbound.Tag,
+		Protocol:   inbound.Type,
+		Port:       inbound.ListenPort,
+		Credential: user.Credential(inbound.Type),
+		Flow:       user.Flow,
+		Network:    "tcp",
+		Security:   "none",
+	}
+	if inbound.Type == "vless" {
+		e.Encryption = "none"
+	}
+
+	if enabled, _ := inbound.TLS["enabled"].(bool); enabled {
+		e.Security = "tls"
+		e.SNI = str(inbound.TLS, "server_name")
+		e.ALPN = strSlice(inbound.TLS, "alpn")
+		reality := obj(inbound.TLS, "reality")
+		if realityEnabled, _ := reality["enabled"].(bool); realityEnabled {
+			e.Security = "reality"
+			if e.SNI == "" {
+				e.SNI = str(obj(reality, "handshake"), "server")
+			}
+			e.ShortID = first(strSlice(reality, "short_id"))
+			e.PublicKey = realityPublicKey(str(reality, "private_key"))
+			e.Fingerprint = "chrome"
+		}
+	}
+
+	t := inbound.Transport
+	switch str(t, "type") {
+	case "ws":
+		e.Network = "ws"
+		e.Path = str(t, "path")
+		e.Host = first(strSlice(obj(t, "headers"), "Host"))
+	case "grpc":
+		e.Network = "grpc"
+		e.ServiceName = str(t, "service_name")
+		e.Mode = "gun"
+	case "httpupgrade":
+		e.Network = "httpupgrade"
+		e.Path = str(t, "path")
+		e.Host = str(t, "host")
+	case "http":
+		e.Network = "http"
+		e.Path = str(t, "path")
+		e.Host = first(strSlice(t, "host"))
+	}
+	return e
+}
+
+// UserEndpoints returns the endpoints of an enabled user built from the inbounds of config.json.
+// The server address is taken from subscription.address, or host if it is not set.
+func UserEndpoints(cfg *config.Config, user, host string) ([]Endpoint, error) {
+	data, err := os.ReadFile(cfg.Core.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config.json: %v", err)
+	}
+
+	var endpoints []Endpoint
+	switch cfg.V2rayStat.Type {
+	case "xray":
+		var cfgXray config.ConfigXray
+		if err := json.Unmarshal(data, &cfgXray); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON: %v", err)
+		}
+		for _, inbound := range cfgXray.Inbounds {
+			if !includeInbound(cfg, inbound.Protocol, inbound.Tag) {
+				continue
+			}
+			for _, client := range inbound.Settings.Clients {
+				if client.Email == user {
+					endpoints = append(endpoints, xrayEndpoint(inbound, client))
+					break
+				}
+			}
+		}
+	case "singbox":
+		var cfgSingbox config.ConfigSingbox
+		if err := json.Unmarshal(data, &cfgSingbox); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON: %v", err)
+		}
+		for _, inbound := range cfgSingbox.Inbounds {
+			if !includeInbound(cfg, inbound.Type, inbound.Tag) {
+				continue
+			}
+			for _, u := range inbound.Users {
+				if u.Name == user {
+					endpoints = append(endpoints, singboxEndpoint(inbound, u))
+					break
+				}
+			}
+		}
+	}
+
+	server := cfg.Subscription.Address
+	if server == "" {
+		server = host
+	}
+	for i := range endpoints {
+		endpoints[i].Server = server
+		if cfg.Subscription.Port != 0 {
+			endpoints[i].Port = cfg.Subscription.Port
+		}
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,283 @@
+package subscription
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Subscription output formats.
+const (
+	FormatBase64  = "base64"
+	FormatClash   = "clash"
+	FormatSingbox = "singbox"
+)
+
+// Link returns the share link (vless:// or trojan://) of an endpoint.
+func Link(e Endpoint) string {
+	q := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+	set("type", e.Network)
+	set("security", e.Security)
+	set("encryption", e.Encryption)
+	set("flow", e.Flow)
+	set("sni", e.SNI)
+	set("alpn", strings.Join(e.ALPN, ","))
+	set("fp", e.Fingerprint)
+	set("pbk", e.PublicKey)
+	set("sid", e.ShortID)
+	set("spx", e.SpiderX)
+	set("path", e.Path)
+	set("host", e.Host)
+	set("serviceName", e.ServiceName)
+	set("mode", e.Mode)
+	set("headerType", e.HeaderType)
+
+	u := url.URL{
+		Scheme:   e.Protocol,
+		User:     url.User(e.Credential),
+		Host:     net.JoinHostPort(e.Server, strconv.Itoa(e.Port)),
+		RawQuery: q.Encode(),
+		Fragment: e.Name,
+	}
+	return u.String()
+}
+
+// Base64 returns the share links of the endpoints, one per line, encoded as base64.
+func Base64(endpoints []Endpoint) []byte {
+	links := make([]string, 0, len(endpoints))
+	for _, e := range endpoints {
+		links = append(links, Link(e))
+	}
+	return []byte(base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n"))))
+}
+
+type clashReality struct {
+	PublicKey string `yaml:"public-key"`
+	ShortID   string `yaml:"short-id,omitempty"`
+}
+
+type clashWS struct {
+	Path             string            `yaml:"path,omitempty"`
+	Headers          map[string]string `yaml:"headers,omitempty"`
+	V2rayHTTPUpgrade bool              `yaml:"v2ray-http-upgrade,omitempty"`
+}
+
+type clashGRPC struct {
+	ServiceName string `yaml:"grpc-service-name"`
+}
+
+type clashProxy struct {
+	Name              string        `yaml:"name"`
+	Type              string        `yaml:"type"`
+	Server            string        `yaml:"server"`
+	Port              int           `yaml:"port"`
+	UUID              string        `yaml:"uuid,omitempty"`
+	Password          string        `yaml:"password,omitempty"`
+	Network           string        `yaml:"network,omitempty"`
+	UDP               bool          `yaml:"udp"`
+	TLS               bool          `yaml:"tls,omitempty"`
+	Flow              string        `yaml:"flow,omitempty"`
+	ServerName        string        `yaml:"servername,omitempty"`
+	SNI               string        `yaml:"sni,omitempty"`
+	ALPN              []string      `yaml:"alpn,omitempty"`
+	ClientFingerprint string        `yaml:"client-fingerprint,omitempty"`
+	RealityOpts       *clashReality `yaml:"reality-opts,omitempty"`
+	WSOpts            *clashWS      `yaml:"ws-opts,omitempty"`
+	GRPCOpts          *clashGRPC    `yaml:"grpc-opts,omitempty"`
+}
+
+type clashGroup struct {
+	Name    string   `yaml:"name"`
+	Type    string   `yaml:"type"`
+	Proxies []string `yaml:"proxies"`
+}
+
+type clashConfig struct {
+	Proxies     []clashProxy `yaml:"proxies"`
+	ProxyGroups []clashGroup `yaml:"proxy-groups"`
+	Rules       []string     `yaml:"rules"`
+}
+
+// clashEndpoint converts an endpoint to a Clash/Mihomo proxy; ok is false for unsupported transports.
+func clashEndpoint(e Endpoint) (proxy clashProxy, ok bool) {
+	proxy = clashProxy{
+		Name:   e.Name,
+		Type:   e.Protocol,
+		Server: e.Server,
+		Port:   e.Port,
+		UDP:    true,
+		ALPN:   e.ALPN,
+	}
+	switch e.Protocol {
+	case "vless":
+		proxy.UUID = e.Credential
+		proxy.Flow = e.Flow
+		proxy.TLS = e.Security != "none"
+		proxy.ServerName = e.SNI
+	case "trojan":
+		proxy.Password = e.Credential
+		proxy.SNI = e.SNI
+	}
+	proxy.ClientFingerprint = e.Fingerprint
+	if e.Security == "reality" {
+		proxy.RealityOpts = &clashReality{PublicKey: e.PublicKey, ShortID: e.ShortID}
+	}
+
+	switch e.Network {
+	case "tcp":
+		if e.HeaderType != "" {
+			return proxy, false
+		}
+	case "ws", "httpupgrade":
+		proxy.Network = "ws"
+		proxy.WSOpts = &clashWS{Path: e.Path, V2rayHTTPUpgrade: e.Network == "httpupgrade"}
+		if e.Host != "" {
+			proxy.WSOpts.Headers = map[string]string{"Host": e.Host}
+		}
+	case "grpc":
+		proxy.Network = "grpc"
+		proxy.GRPCOpts = &clashGRPC{ServiceName: e.ServiceName}
+	default:
+		return proxy, false
+	}
+	return proxy, true
+}
+
+// Clash returns a Clash/Mihomo YAML profile with the endpoints and a selector group named title.
+// Endpoints with transports Clash does not support are skipped.
+func Clash(endpoints []Endpoint, title string) ([]byte, error) {
+	profile := clashConfig{
+		Proxies: []clashProxy{},
+		Rules:   []string{"MATCH," + title},
+	}
+	var names []string
+	for _, e := range endpoints {
+		proxy, ok := clashEndpoint(e)
+		if !ok {
+			continue
+		}
+		profile.Proxies = append(profile.Proxies, proxy)
+		names = append(names, proxy.Name)
+	}
+	profile.ProxyGroups = []clashGroup{{Name: title, Type: "select", Proxies: append(names, "DIRECT")}}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(profile); err != nil {
+		return nil, fmt.Errorf("failed to encode Clash profile: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode Clash profile: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// singboxOutbound converts an endpoint to a sing-box outbound; ok is false for unsupported transports.
+func singboxOutbound(e Endpoint) (outbound map[string]any, ok bool) {
+	outbound = map[string]any{
+		"type":        e.Protocol,
+		"tag":         e.Name,
+		"server":      e.Server,
+		"server_port": e.Port,
+	}
+	switch e.Protocol {
+	case "vless":
+		outbound["uuid"] = e.Credential
+		if e.Flow != "" {
+			outbound["flow"] = e.Flow
+		}
+	case "trojan":
+		outbound["password"] = e.Credential
+	}
+
+	if e.Security != "none" {
+		tls := map[string]any{"enabled": true}
+		if e.SNI != "" {
+			tls["server_name"] = e.SNI
+		}
+		if len(e.ALPN) > 0 {
+			tls["alpn"] = e.ALPN
+		}
+		if e.Fingerprint != "" {
+			tls["utls"] = map[string]any{"enabled": true, "fingerprint": e.Fingerprint}
+		}
+		if e.Security == "reality" {
+			tls["reality"] = map[string]any{"enabled": true, "public_key": e.PublicKey, "short_id": e.ShortID}
+		}
+		outbound["tls"] = tls
+	}
+
+	switch e.Network {
+	case "tcp":
+		if e.HeaderType != "" {
+			return outbound, false
+		}
+	case "ws":
+		transport := map[string]any{"type": "ws", "path": e.Path}
+		if e.Host != "" {
+			transport["headers"] = map[string]any{"Host": e.Host}
+		}
+		outbound["transport"] = transport
+	case "httpupgrade":
+		outbound["transport"] = map[string]any{"type": "httpupgrade", "path": e.Path, "host": e.Host}
+	case "grpc":
+		outbound["transport"] = map[string]any{"type": "grpc", "service_name": e.ServiceName}
+	case "http":
+		transport := map[string]any{"type": "http", "path": e.Path}
+		if e.Host != "" {
+			transport["host"] = []string{e.Host}
+		}
+		outbound["transport"] = transport
+	default:
+		return outbound, false
+	}
+	return outbound, true
+}
+
+// Singbox returns a sing-box JSON profile with a TUN inbound, the endpoints and a selector outbound.
+// Endpoints with transports sing-box does not support are skipped.
+func Singbox(endpoints []Endpoint) ([]byte, error) {
+	var tags []string
+	outbounds := []any{}
+	for _, e := range endpoints {
+		outbound, ok := singboxOutbound(e)
+		if !ok {
+			continue
+		}
+		outbounds = append(outbounds, outbound)
+		tags = append(tags, e.Name)
+	}
+	selector := map[string]any{"type": "selector", "tag": "proxy", "outbounds": append(tags, "direct")}
+	outbounds = append([]any{selector}, outbounds...)
+	outbounds = append(outbounds, map[string]any{"type": "direct", "tag": "direct"})
+
+	profile := map[string]any{
+		"log": map[string]any{"level": "warn"},
+		"inbounds": []any{map[string]any{
+			"type":         "tun",
+			"tag":          "tun-in",
+			"address":      []string{"172.19.0.1/30"},
+			"auto_route":   true,
+			"strict_route": true,
+		}},
+		"outbounds": outbounds,
+		"route": map[string]any{
+			"auto_detect_interface": true,
+			"final":                 "proxy",
+		},
+	}
+	return json.MarshalIndent(profile, "", "  ")
+}
@@ -1,6 +1,11 @@
 package util
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
 
 // FormatData formats a numerical traffic or speed value.
 func FormatData(value float64, unit string) string {
@@ -37,3 +42,41 @@ func FormatData(value float64, unit string) string {
 		return fmt.Sprintf("%.0f %s", value, unit)
 	}
 }
+
+// ParseDataSize parses a data size such as "1048576", "500MiB", "100GB" or "1.5TiB" into bytes.
+// Binary suffixes (KiB, MiB, GiB, TiB) use powers of 1024, decimal ones (KB, MB, GB, TB) powers of 1000.
+func ParseDataSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if n < 0 {
+			return 0, fmt.Errorf("data size must not be negative: %s", value)
+		}
+		return n, nil
+	}
+
+	units := []struct {
+		suffix     string
+		multiplier float64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+		{"B", 1},
+	}
+	for _, u := range units {
+		if !strings.HasSuffix(strings.ToUpper(value), strings.ToUpper(u.suffix)) {
+			continue
+		}
+		number := strings.TrimSpace(value[:len(value)-len(u.suffix)])
+		f, err := strconv.ParseFloat(number, 64)
+		if err != nil || math.IsNaN(f) || f < 0 {
+			return 0, fmt.Errorf("invalid data size: %s", value)
+		}
+		// float64(math.MaxInt64) rounds up to 2^63, which no longer fits, so it is excluded too
+		size := f * u.multiplier
+		if size >= math.MaxInt64 {
+			return 0, fmt.Errorf("data size too large: %s", value)
+		}
+		return int64(size), nil
+	}
+	return 0, fmt.Errorf("invalid data size: %s", value)
+}
@@ -0,0 +1,52 @@
+package util
+
+import "testing"
+
+func TestParseDataSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		valid bool
+	}{
+		{"0", 0, true},
+		{"1048576", 1048576, true},
+		{" 42 ", 42, true},
+		{"512B", 512, true},
+		{"10KB", 10_000, true},
+		{"10 KiB", 10_240, true},
+		{"1.5MB", 1_500_000, true},
+		{"1.5MiB", 1_572_864, true},
+		{"2GB", 2_000_000_000, true},
+		{"2gib", 2 << 30, true},
+		{"1TB", 1_000_000_000_000, true},
+		{"1TiB", 1 << 40, true},
+		{"-1", 0, false},
+		{"-1GB", 0, false},
+		{"GB", 0, false},
+		{"ten MB", 0, false},
+		{"10PB", 0, false},
+		{"", 0, false},
+		{"NaN GB", 0, false},
+		{"nan", 0, false},
+		{"Inf", 0, false},
+		{"Inf GB", 0, false},
+		{"+Inf B", 0, false},
+		{"-Inf B", 0, false},
+		{"1e30TB", 0, false},
+		{"8EiB", 0, false},
+		{"8388608TiB", 0, false},
+		{"8388607TiB", 8388607 << 40, true},
+		{"9223372036854775807", 9223372036854775807, true},
+		{"9223372036854775808", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseDataSize(tt.value)
+		if (err == nil) != tt.valid {
+			t.Errorf("ParseDataSize(%q) error = %v, want valid %v", tt.value, err, tt.valid)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseDataSize(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}